@@ -0,0 +1,218 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+var ErrMediaSessionLimit = errors.New("media session limit reached")
+
+// AdmissionDecision is decision on admitting new incoming call
+type AdmissionDecision int
+
+const (
+	AdmissionAccept AdmissionDecision = iota
+	// AdmissionBusy rejects call with 486 Busy Here
+	AdmissionBusy
+	// AdmissionOverload rejects call with 503 Service Unavailable and Retry-After
+	AdmissionOverload
+)
+
+// AdmissionOptions configures admission control of incoming calls. Zero limit means unlimited
+type AdmissionOptions struct {
+	// MaxCalls limits concurrent incoming calls
+	MaxCalls int
+	// MaxCallsPerSource limits concurrent incoming calls per source IP. Exceeding calls are rejected as busy
+	MaxCallsPerSource int
+	// MaxCPS limits new incoming calls per second per transport
+	MaxCPS int
+	// MaxMediaSessions limits media sessions, and therefore RTP ports, of incoming and outgoing calls.
+	// Creating media session over limit fails with ErrMediaSessionLimit
+	MaxMediaSessions int
+	// RetryAfter is sent with 503 Service Unavailable. Default is 5s
+	RetryAfter time.Duration
+
+	// Admit allows application to decide on each new INVITE.
+	// State contains decision made by limits, which is used if callback returns it unchanged
+	Admit func(req *sip.Request, state AdmissionState) AdmissionDecision
+}
+
+// AdmissionState is current load seen by new incoming call
+type AdmissionState struct {
+	// Source IP and transport of request
+	Source    string
+	Transport string
+
+	Calls         int
+	SourceCalls   int
+	CPS           int
+	MediaSessions int
+
+	// Decision based on configured limits
+	Decision AdmissionDecision
+}
+
+// WithAdmission enables admission control of incoming calls.
+// Rejected calls never reach serve handler
+func WithAdmission(opts AdmissionOptions) DiagoOption {
+	return func(dg *Diago) {
+		if opts.RetryAfter <= 0 {
+			opts.RetryAfter = 5 * time.Second
+		}
+		dg.admission = &admission{
+			opts:    opts,
+			sources: make(map[string]int),
+			windows: make(map[string]*admissionWindow),
+			media:   &mediaSessionLimit{max: int64(opts.MaxMediaSessions)},
+		}
+	}
+}
+
+type admissionWindow struct {
+	start time.Time
+	count int
+}
+
+type admission struct {
+	opts AdmissionOptions
+
+	mu      sync.Mutex
+	calls   int
+	sources map[string]int
+	// windows counts admitted calls per transport in current second
+	windows map[string]*admissionWindow
+
+	media *mediaSessionLimit
+}
+
+// admit decides on new call. If admitted, release must be called when call ends,
+// otherwise rejection response is returned
+func (a *admission) admit(req *sip.Request) (release func(), res *sip.Response) {
+	source := req.Source()
+	if host, _, err := net.SplitHostPort(source); err == nil {
+		source = host
+	}
+	state := AdmissionState{
+		Source:        source,
+		Transport:     req.Transport(),
+		MediaSessions: int(a.media.count.Load()),
+	}
+
+	a.mu.Lock()
+	w := a.windows[state.Transport]
+	if w == nil {
+		w = &admissionWindow{}
+		a.windows[state.Transport] = w
+	}
+	if now := time.Now(); now.Sub(w.start) >= time.Second {
+		w.start = now
+		w.count = 0
+	}
+
+	state.Calls = a.calls
+	state.SourceCalls = a.sources[source]
+	state.CPS = w.count
+	state.Decision = a.decide(state)
+	if state.Decision == AdmissionAccept {
+		a.reserveUnsafe(source, w)
+	}
+	a.mu.Unlock()
+
+	decision := state.Decision
+	if a.opts.Admit != nil {
+		decision = a.opts.Admit(req, state)
+		if decision != state.Decision {
+			a.mu.Lock()
+			if state.Decision == AdmissionAccept {
+				a.releaseUnsafe(source)
+			} else if decision == AdmissionAccept {
+				a.reserveUnsafe(source, w)
+			}
+			a.mu.Unlock()
+		}
+	}
+
+	switch decision {
+	case AdmissionAccept:
+		var once sync.Once
+		return func() {
+			once.Do(func() {
+				a.mu.Lock()
+				a.releaseUnsafe(source)
+				a.mu.Unlock()
+			})
+		}, nil
+	case AdmissionBusy:
+		return nil, sip.NewResponseFromRequest(req, sip.StatusBusyHere, "Busy Here", nil)
+	}
+	res = sip.NewResponseFromRequest(req, sip.StatusServiceUnavailable, "Service Unavailable", nil)
+	res.AppendHeader(sip.NewHeader("Retry-After", strconv.Itoa(int(a.opts.RetryAfter.Seconds()))))
+	return nil, res
+}
+
+func (a *admission) decide(state AdmissionState) AdmissionDecision {
+	opts := a.opts
+	switch {
+	case opts.MaxCalls > 0 && state.Calls >= opts.MaxCalls,
+		opts.MaxCPS > 0 && state.CPS >= opts.MaxCPS,
+		opts.MaxMediaSessions > 0 && state.MediaSessions >= opts.MaxMediaSessions:
+		return AdmissionOverload
+	case opts.MaxCallsPerSource > 0 && state.SourceCalls >= opts.MaxCallsPerSource:
+		return AdmissionBusy
+	}
+	return AdmissionAccept
+}
+
+func (a *admission) reserveUnsafe(source string, w *admissionWindow) {
+	a.calls++
+	a.sources[source]++
+	w.count++
+}
+
+func (a *admission) releaseUnsafe(source string) {
+	a.calls--
+	a.sources[source]--
+	if a.sources[source] <= 0 {
+		delete(a.sources, source)
+	}
+}
+
+// mediaSessionLimit counts media sessions created from media config. Nil limit is no-op
+type mediaSessionLimit struct {
+	max   int64
+	count atomic.Int64
+}
+
+func (l *mediaSessionLimit) acquire() bool {
+	if l == nil {
+		return true
+	}
+	if n := l.count.Add(1); l.max > 0 && n > l.max {
+		l.count.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (l *mediaSessionLimit) release() {
+	if l == nil {
+		return
+	}
+	l.count.Add(-1)
+}
+
+func (dg *Diago) mediaSessionLimit() *mediaSessionLimit {
+	if dg.admission == nil {
+		return nil
+	}
+	return dg.admission.media
+}
@@ -0,0 +1,175 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emiago/diago/media"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAdmissionTestRequest(source string) *sip.Request {
+	req := sip.NewRequest(sip.INVITE, sip.Uri{User: "bob", Host: "127.0.0.1"})
+	req.SetSource(source)
+	req.SetTransport("UDP")
+	return req
+}
+
+func TestAdmission(t *testing.T) {
+	dg := &Diago{}
+
+	t.Run("MaxCalls", func(t *testing.T) {
+		WithAdmission(AdmissionOptions{MaxCalls: 1})(dg)
+		release, res := dg.admission.admit(newAdmissionTestRequest("10.0.0.1:5060"))
+		require.Nil(t, res)
+
+		_, res = dg.admission.admit(newAdmissionTestRequest("10.0.0.2:5060"))
+		require.NotNil(t, res)
+		assert.Equal(t, sip.StatusServiceUnavailable, res.StatusCode)
+		assert.Equal(t, "5", res.GetHeader("Retry-After").Value())
+
+		release()
+		release()
+		_, res = dg.admission.admit(newAdmissionTestRequest("10.0.0.2:5060"))
+		require.Nil(t, res)
+	})
+
+	t.Run("MaxCallsPerSource", func(t *testing.T) {
+		WithAdmission(AdmissionOptions{MaxCallsPerSource: 1})(dg)
+		_, res := dg.admission.admit(newAdmissionTestRequest("10.0.0.1:5060"))
+		require.Nil(t, res)
+
+		_, res = dg.admission.admit(newAdmissionTestRequest("10.0.0.1:5070"))
+		require.NotNil(t, res)
+		assert.Equal(t, sip.StatusBusyHere, res.StatusCode)
+
+		_, res = dg.admission.admit(newAdmissionTestRequest("10.0.0.2:5060"))
+		require.Nil(t, res)
+	})
+
+	t.Run("MaxCPS", func(t *testing.T) {
+		WithAdmission(AdmissionOptions{MaxCPS: 2})(dg)
+		for i := 0; i < 2; i++ {
+			release, res := dg.admission.admit(newAdmissionTestRequest("10.0.0.1:5060"))
+			require.Nil(t, res)
+			release()
+		}
+		_, res := dg.admission.admit(newAdmissionTestRequest("10.0.0.1:5060"))
+		require.NotNil(t, res)
+		assert.Equal(t, sip.StatusServiceUnavailable, res.StatusCode)
+	})
+
+	t.Run("MaxMediaSessions", func(t *testing.T) {
+		WithAdmission(AdmissionOptions{MaxMediaSessions: 1})(dg)
+		conf := MediaConfig{Codecs: []media.Codec{media.CodecAudioUlaw}, bindIP: net.IPv4(127, 0, 0, 1), sessions: dg.mediaSessionLimit()}
+
+		m1 := &DialogMedia{}
+		require.NoError(t, m1.initMediaSessionFromConf(conf))
+		m2 := &DialogMedia{}
+		require.ErrorIs(t, m2.initMediaSessionFromConf(conf), ErrMediaSessionLimit)
+
+		_, res := dg.admission.admit(newAdmissionTestRequest("10.0.0.1:5060"))
+		require.NotNil(t, res)
+		assert.Equal(t, sip.StatusServiceUnavailable, res.StatusCode)
+
+		require.NoError(t, m1.Close())
+		require.NoError(t, m2.initMediaSessionFromConf(conf))
+		require.NoError(t, m2.Close())
+	})
+
+	t.Run("Admit", func(t *testing.T) {
+		var states []AdmissionState
+		WithAdmission(AdmissionOptions{
+			MaxCalls: 1,
+			Admit: func(req *sip.Request, state AdmissionState) AdmissionDecision {
+				states = append(states, state)
+				if state.Source == "10.0.0.9" {
+					return AdmissionBusy
+				}
+				return AdmissionAccept
+			},
+		})(dg)
+
+		_, res := dg.admission.admit(newAdmissionTestRequest("10.0.0.9:5060"))
+		require.NotNil(t, res)
+		assert.Equal(t, sip.StatusBusyHere, res.StatusCode)
+
+		// Rejected call does not count and limit can be overridden
+		_, res = dg.admission.admit(newAdmissionTestRequest("10.0.0.1:5060"))
+		require.Nil(t, res)
+		_, res = dg.admission.admit(newAdmissionTestRequest("10.0.0.1:5060"))
+		require.Nil(t, res)
+
+		require.Len(t, states, 3)
+		assert.Equal(t, AdmissionAccept, states[0].Decision)
+		assert.Equal(t, AdmissionAccept, states[1].Decision)
+		assert.Equal(t, AdmissionOverload, states[2].Decision)
+		assert.Equal(t, 1, states[2].Calls)
+		assert.Equal(t, "UDP", states[2].Transport)
+	})
+}
+
+func TestIntegrationAdmission(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("server"))
+		defer ua.Close()
+
+		dg := NewDiago(ua,
+			WithTransport(
+				Transport{
+					Transport: "udp",
+					BindHost:  "127.0.0.1",
+					BindPort:  15121,
+				},
+			),
+			WithAdmission(AdmissionOptions{MaxCalls: 1, MaxMediaSessions: 1}),
+		)
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			if err := d.Answer(); err != nil {
+				return
+			}
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	recipient := sip.Uri{User: "bob", Host: "127.0.0.1", Port: 15121}
+	d, err := dg.Invite(ctx, recipient, InviteOptions{})
+	require.NoError(t, err)
+	defer d.Close()
+
+	_, err = dg.Invite(ctx, recipient, InviteOptions{})
+	res := dialogErrorResponse(err)
+	require.NotNil(t, res, err)
+	assert.Equal(t, sip.StatusServiceUnavailable, res.StatusCode)
+	assert.NotNil(t, res.GetHeader("Retry-After"))
+
+	require.NoError(t, d.Hangup(ctx))
+
+	// Slot is released when serve handler returns
+	require.Eventually(t, func() bool {
+		d, err := dg.Invite(ctx, recipient, InviteOptions{})
+		if err != nil {
+			return false
+		}
+		defer d.Close()
+		return d.Hangup(ctx) == nil
+	}, 2*time.Second, 100*time.Millisecond)
+}
@@ -30,13 +30,16 @@ type Bridge struct {
 	// DTMFpass is also dtmf pipeline and proxy. By default only audio media is proxied
 	// NOTE: this may not work if you are already processing DTMF with AudioReaderDTMF
 	DTMFpass bool
+	// MusicOnHold is played to dialog while other dialog holds call
+	MusicOnHold *MusicOnHold
 
 	log *slog.Logger
 	// TODO: RTPpass. RTP pass means that RTP will be proxied.
 	// This gives high performance but you can not attach any pipeline in media processing
 	// RTPpass bool
 
-	dialogs []DialogSession
+	dialogs     []DialogSession
+	holdPlayers [2]*musicOnHoldPlayer
 
 	// minDialogs is just helper flag when to start proxy
 	WaitDialogsNum int
@@ -106,6 +109,9 @@ func (b *Bridge) AddDialogSession(d DialogSession) error {
 	if len(b.dialogs) > 2 {
 		return fmt.Errorf("currently bridge only support 2 party")
 	}
+	// Hangup cause is carried from one leg to the other
+	bridgeCarryReason(b.dialogs[0], b.dialogs[1])
+	bridgeCarryReason(b.dialogs[1], b.dialogs[0])
 	// Check are both answered
 	for _, d := range b.dialogs {
 		// TODO remove this double locking. Read once
@@ -114,6 +120,15 @@ func (b *Bridge) AddDialogSession(d DialogSession) error {
 		}
 	}
 
+	if b.MusicOnHold != nil {
+		for i, d := range b.dialogs {
+			p := newMusicOnHoldPlayer(b.MusicOnHold, d.Media(), b.log)
+			b.holdPlayers[i] = p
+			// Other dialog holding plays music to this one
+			b.dialogs[1-i].Media().OnHold(p.onHold)
+		}
+	}
+
 	go func() {
 		defer func(start time.Time) {
 			b.log.Debug("Proxy media setup", "dur", time.Since(start).String())
@@ -208,7 +223,7 @@ func (b *Bridge) proxyMedia() error {
 	func() {
 		p1, p2 := MediaProps{}, MediaProps{}
 		r := m1.audioReaderProps(&p1)
-		w := m2.audioWriterProps(&p2)
+		w := b.proxyWriter(m2, m2.audioWriterProps(&p2))
 
 		log := log.With("from", p1.Raddr+" > "+p1.Laddr, "to", p2.Laddr+" > "+p2.Raddr)
 		log.Debug("Starting proxy media routine")
@@ -219,7 +234,7 @@ func (b *Bridge) proxyMedia() error {
 	func() {
 		p1, p2 := MediaProps{}, MediaProps{}
 		r := m2.audioReaderProps(&p1)
-		w := m1.audioWriterProps(&p2)
+		w := b.proxyWriter(m1, m1.audioWriterProps(&p2))
 		log := log.With("from", p1.Raddr+" > "+p1.Laddr, "to", p2.Laddr+" > "+p2.Raddr)
 		log.Debug("Starting proxy media routine")
 		go proxyMediaBackground(log, r, w, errCh)
@@ -252,7 +267,8 @@ func (b *Bridge) proxyMediaWithDTMF(m1 *DialogMedia, m2 *DialogMedia) error {
 	if err != nil {
 		return err
 	}
-	dtmfWriter := DTMFWriter{}
+	// Peer may not support RTP DTMF, so let writer choose SIP INFO in that case
+	dtmfWriter := DTMFWriter{Mode: DTMFModeAuto}
 	w, err := m2.AudioWriter(WithAudioWriterDTMF(&dtmfWriter), WithAudioWriterMediaProps(&p2))
 	if err != nil {
 		return err
@@ -266,11 +282,21 @@ func (b *Bridge) proxyMediaWithDTMF(m1 *DialogMedia, m2 *DialogMedia) error {
 
 	log := b.log.With("from", p1.Raddr+" > "+p1.Laddr, "to", p2.Laddr+" > "+p2.Raddr)
 	log.Debug("Starting proxy media routine")
-	written, err := copyWithBuf(r, w, buf.([]byte))
+	written, err := copyWithBuf(r, b.proxyWriter(m2, w), buf.([]byte))
 	log.Debug("Bridge proxy stream finished", "bytes", written)
 	return err
 }
 
+// proxyWriter gates proxied audio to dialog with music on hold
+func (b *Bridge) proxyWriter(m *DialogMedia, w io.Writer) io.Writer {
+	for _, p := range b.holdPlayers {
+		if p != nil && p.m == m {
+			return p.gate(w)
+		}
+	}
+	return w
+}
+
 // BridgeMix is mixing audio when having 2 or more parties.
 //
 // Experimental: not fully tested yet
@@ -286,7 +312,12 @@ type BridgeMix struct {
 	// RealtimeReader is almost always nesessary if you are delaying audio streaming(mixing) in bridge
 	RealtimeReader bool
 	Poll           bool
-	log            *slog.Logger
+	// MusicOnHold replaces audio of dialog holding call, so other parties hear music
+	MusicOnHold *MusicOnHold
+
+	// held are dialogs currently holding call
+	held map[string]bool
+	log  *slog.Logger
 }
 
 var (
@@ -352,6 +383,16 @@ func (b *BridgeMix) AddDialogSession(d DialogSession) error {
 
 	b.dialogs = append(b.dialogs, d)
 	b.log.Debug("Added dialog", "dialog", d.Id(), "total", len(b.dialogs))
+	if b.MusicOnHold != nil {
+		d.Media().OnHold(func(ev HoldEvent) {
+			if ev != HoldEventRemoteHold && ev != HoldEventResumed {
+				return
+			}
+			if err := b.holdDialog(d, ev == HoldEventRemoteHold); err != nil {
+				b.log.Error("Failed to update music on hold", "error", err, "dialog", d.Id())
+			}
+		})
+	}
 	b.mixStart()
 	return nil
 }
@@ -385,6 +426,7 @@ func (b *BridgeMix) RemoveDialogSession(d DialogSession) error {
 			break
 		}
 	}
+	delete(b.held, dialogID)
 
 	b.log.Debug("Removed dialog", "dialog", dialog.Id(), "total", len(b.dialogs))
 	return b.mixStart()
@@ -485,6 +527,14 @@ func (b *BridgeMix) mixStart() error {
 		if err := b.mixLoop(rwStreams, poll); err != nil {
 			b.log.Info("Mix stopped with error", "error", err)
 		}
+		if !poll {
+			// With polling, stream readers are closed by poll routines
+			for _, s := range rwStreams {
+				if c, ok := s.r.(io.Closer); ok {
+					c.Close()
+				}
+			}
+		}
 	}(rwStreams)
 	return nil
 }
@@ -606,6 +656,11 @@ func (b *BridgeMix) addDialogStream(ctx context.Context, d DialogSession, stream
 	if err := pcmReader.Init(p.Codec, rtr); err != nil {
 		return err
 	}
+	var streamReader io.Reader = &pcmReader
+	if b.held[d.Id()] && b.MusicOnHold != nil && len(b.dialogs) > 1 {
+		// Dialog is holding, so others hear music instead
+		streamReader = b.MusicOnHold.pcmReader(p.Codec)
+	}
 
 	// Now do write stream
 	p = MediaProps{}
@@ -620,7 +675,7 @@ func (b *BridgeMix) addDialogStream(ctx context.Context, d DialogSession, stream
 	}
 
 	*stream = bridgePCMStream{
-		r:            &pcmReader,
+		r:            streamReader,
 		w:            &pcmWriter,
 		mediaSession: m.mediaSession,
 		id:           m.RTPPacketWriter.SSRC,
@@ -640,6 +695,9 @@ func (b *BridgeMix) addDialogStream(ctx context.Context, d DialogSession, stream
 			defer bridgeReadPool.Put(bufPtr)
 
 			defer close(s.pipeWrite)
+			if c, ok := s.r.(io.Closer); ok {
+				defer c.Close()
+			}
 
 			buf := *bufPtr
 			for {
@@ -0,0 +1,326 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/emiago/diago/media"
+	"github.com/emiago/sipgo/sip"
+)
+
+const (
+	CDRDirectionInbound  = "inbound"
+	CDRDirectionOutbound = "outbound"
+
+	CDRHangupLocal  = "local"
+	CDRHangupRemote = "remote"
+)
+
+// CDR is call detail record of dialog. It is emitted when dialog is closed
+type CDR struct {
+	CallID string `json:"call_id"`
+	// Direction is inbound for server dialog and outbound for client dialog
+	Direction string `json:"direction"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Transport string `json:"transport"`
+
+	// SetupTime is time when dialog is created. Ring and answer time are zero if call did not ring or was not answered
+	SetupTime  time.Time `json:"setup_time"`
+	RingTime   time.Time `json:"ring_time"`
+	AnswerTime time.Time `json:"answer_time"`
+	EndTime    time.Time `json:"end_time"`
+
+	// HangupBy is party that ended call, local or remote
+	HangupBy string `json:"hangup_by"`
+	// StatusCode is final response status code on INVITE, 0 if there was none
+	StatusCode int `json:"status_code"`
+	// Reason is Reason header of BYE or final response. Without it, it is reason phrase of final response
+	Reason string `json:"reason"`
+
+	// Codec is negotiated audio codec name
+	Codec string `json:"codec"`
+	// SRTP is sdes or dtls when media is secured
+	SRTP string `json:"srtp"`
+
+	RTPReadStats  media.RTPReadStats  `json:"rtp_read_stats"`
+	RTPWriteStats media.RTPWriteStats `json:"rtp_write_stats"`
+}
+
+// CDRSink receives CDR of every ended dialog. WriteCDR is called from dialog closing and should not block for long
+type CDRSink interface {
+	WriteCDR(ctx context.Context, cdr CDR) error
+}
+
+// WithCDRSink emits CDR for every server and client dialog into sink
+func WithCDRSink(sink CDRSink) DiagoOption {
+	return func(dg *Diago) {
+		dg.cdrSink = sink
+	}
+}
+
+// CDRJSONSink writes CDR as JSON lines
+type CDRJSONSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewCDRJSONSink(w io.Writer) *CDRJSONSink {
+	return &CDRJSONSink{enc: json.NewEncoder(w)}
+}
+
+func (s *CDRJSONSink) WriteCDR(ctx context.Context, cdr CDR) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(cdr)
+}
+
+// CDRCSVSink writes CDR as CSV rows. Header row is written before first record
+type CDRCSVSink struct {
+	mu            sync.Mutex
+	w             *csv.Writer
+	headerWritten bool
+}
+
+var cdrCSVHeader = []string{
+	"call_id", "direction", "from", "to", "transport",
+	"setup_time", "ring_time", "answer_time", "end_time",
+	"hangup_by", "status_code", "reason", "codec", "srtp",
+	"rtp_packets_received", "rtp_octets_received", "rtp_packets_sent", "rtp_octets_sent", "rtt",
+}
+
+func NewCDRCSVSink(w io.Writer) *CDRCSVSink {
+	return &CDRCSVSink{w: csv.NewWriter(w)}
+}
+
+func (s *CDRCSVSink) WriteCDR(ctx context.Context, cdr CDR) error {
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339Nano)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.headerWritten {
+		if err := s.w.Write(cdrCSVHeader); err != nil {
+			return err
+		}
+		s.headerWritten = true
+	}
+
+	row := []string{
+		cdr.CallID, cdr.Direction, cdr.From, cdr.To, cdr.Transport,
+		formatTime(cdr.SetupTime), formatTime(cdr.RingTime), formatTime(cdr.AnswerTime), formatTime(cdr.EndTime),
+		cdr.HangupBy, strconv.Itoa(cdr.StatusCode), cdr.Reason, cdr.Codec, cdr.SRTP,
+		strconv.FormatUint(cdr.RTPReadStats.PacketsCount, 10), strconv.FormatUint(cdr.RTPReadStats.OctetCount, 10),
+		strconv.FormatUint(cdr.RTPWriteStats.PacketsCount, 10), strconv.FormatUint(cdr.RTPWriteStats.OctetCount, 10),
+		cdr.RTPReadStats.RTT.String(),
+	}
+	if err := s.w.Write(row); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// cdrRecord collects call timings and remote hangup during dialog lifetime
+type cdrRecord struct {
+	mu         sync.Mutex
+	direction  string
+	setupTime  time.Time
+	ringTime   time.Time
+	answerTime time.Time
+	// bye is BYE received from remote
+	bye *sip.Request
+	// localReason is Reason header of BYE sent
+	localReason string
+}
+
+func newCDRRecord(direction string) *cdrRecord {
+	return &cdrRecord{direction: direction, setupTime: time.Now()}
+}
+
+func (r *cdrRecord) ring() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ringTime.IsZero() {
+		r.ringTime = time.Now()
+	}
+}
+
+func (r *cdrRecord) answer() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.answerTime.IsZero() {
+		r.answerTime = time.Now()
+	}
+}
+
+// remoteBye marks call hanguped by remote. It is safe to call on nil record
+func (r *cdrRecord) remoteBye(req *sip.Request) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bye = req
+}
+
+// localBye keeps reason of BYE we send. It is safe to call on nil record
+func (r *cdrRecord) localBye(req *sip.Request) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h := req.GetHeader("Reason"); h != nil {
+		r.localReason = h.Value()
+	}
+}
+
+// cdr builds CDR of ended dialog. Canceled is set when server dialog is canceled by caller
+func (r *cdrRecord) cdr(req *sip.Request, res *sip.Response, canceled bool, m *DialogMedia) CDR {
+	r.mu.Lock()
+	cdr := CDR{
+		Direction:  r.direction,
+		SetupTime:  r.setupTime,
+		RingTime:   r.ringTime,
+		AnswerTime: r.answerTime,
+		EndTime:    time.Now(),
+	}
+	bye, localReason := r.bye, r.localReason
+	r.mu.Unlock()
+
+	if h := req.CallID(); h != nil {
+		cdr.CallID = h.Value()
+	}
+	if h := req.From(); h != nil {
+		cdr.From = h.Address.String()
+	}
+	if h := req.To(); h != nil {
+		cdr.To = h.Address.String()
+	}
+	cdr.Transport = req.Transport()
+
+	if res != nil && !res.IsProvisional() {
+		cdr.StatusCode = res.StatusCode
+		cdr.Reason = res.Reason
+		if h := res.GetHeader("Reason"); h != nil {
+			cdr.Reason = h.Value()
+		}
+	}
+
+	switch {
+	case bye != nil:
+		cdr.HangupBy = CDRHangupRemote
+		cdr.Reason = ""
+		if h := bye.GetHeader("Reason"); h != nil {
+			cdr.Reason = h.Value()
+		}
+	case !cdr.AnswerTime.IsZero():
+		cdr.HangupBy = CDRHangupLocal
+		cdr.Reason = localReason
+	case canceled:
+		cdr.HangupBy = CDRHangupRemote
+		cdr.StatusCode, cdr.Reason = sip.StatusRequestTerminated, "Request Terminated"
+	case r.direction == CDRDirectionOutbound && cdr.StatusCode >= 300:
+		cdr.HangupBy = CDRHangupRemote
+	default:
+		cdr.HangupBy = CDRHangupLocal
+	}
+
+	m.mu.Lock()
+	sess := m.mediaSession
+	rtpSess := m.rtpSession
+	m.mu.Unlock()
+	if sess != nil {
+		if codec, ok := media.CodecAudioFromList(sess.CommonCodecs()); ok {
+			cdr.Codec = codec.Name
+		}
+		switch sess.SecureRTP {
+		case 1:
+			cdr.SRTP = "sdes"
+		case 2:
+			cdr.SRTP = "dtls"
+		}
+	}
+	if rtpSess != nil {
+		cdr.RTPReadStats = rtpSess.ReadStats()
+		cdr.RTPWriteStats = rtpSess.WriteStats()
+	}
+	return cdr
+}
+
+func (dg *Diago) writeCDR(cdr CDR) {
+	if err := dg.cdrSink.WriteCDR(context.Background(), cdr); err != nil {
+		dg.log.Error("Failed to write CDR", "error", err, "call_id", cdr.CallID)
+	}
+}
+
+// trackServerCDR emits CDR of incoming dialog when dialog is closed
+func (dg *Diago) trackServerCDR(d *DialogServerSession) {
+	if dg.cdrSink == nil {
+		return
+	}
+
+	rec := newCDRRecord(CDRDirectionInbound)
+	d.cdr = rec
+	onProvisional := d.onProvisional
+	d.onProvisional = func(res *sip.Response) {
+		if onProvisional != nil {
+			onProvisional(res)
+		}
+		if res.StatusCode > sip.StatusTrying {
+			rec.ring()
+		}
+	}
+	d.OnState(func(s sip.DialogState) {
+		if s == sip.DialogStateEstablished {
+			rec.answer()
+		}
+	})
+	d.OnClose(func() error {
+		canceled := errors.Is(context.Cause(d.DialogServerSession.Context()), sip.ErrTransactionCanceled)
+		dg.writeCDR(rec.cdr(d.InviteRequest, d.InviteResponse, canceled, &d.DialogMedia))
+		return nil
+	})
+}
+
+// trackClientCDR emits CDR of outgoing dialog when dialog is closed
+func (dg *Diago) trackClientCDR(d *DialogClientSession) {
+	if dg.cdrSink == nil {
+		return
+	}
+
+	rec := newCDRRecord(CDRDirectionOutbound)
+	d.cdr = rec
+	onProvisional := d.onProvisional
+	d.onProvisional = func(res *sip.Response) {
+		if onProvisional != nil {
+			onProvisional(res)
+		}
+		if res.StatusCode > sip.StatusTrying {
+			rec.ring()
+		}
+	}
+	d.OnState(func(s sip.DialogState) {
+		if s == sip.DialogStateEstablished {
+			rec.answer()
+		}
+	})
+	d.OnClose(func() error {
+		dg.writeCDR(rec.cdr(d.InviteRequest, d.InviteResponse, false, &d.DialogMedia))
+		return nil
+	})
+}
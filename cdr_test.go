@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type cdrChanSink chan CDR
+
+func (s cdrChanSink) WriteCDR(ctx context.Context, cdr CDR) error {
+	s <- cdr
+	return nil
+}
+
+func (s cdrChanSink) next(t *testing.T) CDR {
+	select {
+	case cdr := <-s:
+		return cdr
+	case <-time.After(2 * time.Second):
+		t.Fatal("CDR not written")
+	}
+	return CDR{}
+}
+
+func TestCDRSinks(t *testing.T) {
+	now := time.Now()
+	cdr := CDR{
+		CallID:     "callid",
+		Direction:  CDRDirectionInbound,
+		From:       "sip:alice@127.0.0.1",
+		To:         "sip:bob@127.0.0.1",
+		Transport:  "UDP",
+		SetupTime:  now,
+		AnswerTime: now.Add(time.Second),
+		EndTime:    now.Add(2 * time.Second),
+		HangupBy:   CDRHangupRemote,
+		StatusCode: 200,
+		Reason:     `Q.850;cause=16;text="Normal call clearing"`,
+		Codec:      "PCMU",
+	}
+	cdr.RTPReadStats.PacketsCount = 50
+
+	t.Run("JSON", func(t *testing.T) {
+		buf := bytes.NewBuffer(nil)
+		sink := NewCDRJSONSink(buf)
+		require.NoError(t, sink.WriteCDR(context.Background(), cdr))
+		require.NoError(t, sink.WriteCDR(context.Background(), cdr))
+
+		lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+		require.Len(t, lines, 2)
+		var decoded CDR
+		require.NoError(t, json.Unmarshal(lines[1], &decoded))
+		assert.Equal(t, cdr.CallID, decoded.CallID)
+		assert.Equal(t, cdr.Reason, decoded.Reason)
+		assert.True(t, cdr.AnswerTime.Equal(decoded.AnswerTime))
+		assert.Equal(t, uint64(50), decoded.RTPReadStats.PacketsCount)
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		buf := bytes.NewBuffer(nil)
+		sink := NewCDRCSVSink(buf)
+		require.NoError(t, sink.WriteCDR(context.Background(), cdr))
+		require.NoError(t, sink.WriteCDR(context.Background(), cdr))
+
+		rows, err := csv.NewReader(buf).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, rows, 3)
+		assert.Equal(t, cdrCSVHeader, rows[0])
+		assert.Equal(t, "callid", rows[1][0])
+		assert.Equal(t, "", rows[1][6], "ring time should be empty")
+		assert.Equal(t, cdr.Reason, rows[1][11])
+		assert.Equal(t, "50", rows[1][14])
+	})
+}
+
+func TestIntegrationCDR(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverCDRs := make(cdrChanSink, 10)
+	// Hangup is sent only after server received ACK, otherwise server terminates call itself
+	serverAnswered := make(chan struct{}, 1)
+	{
+		ua, _ := sipgo.NewUA()
+		defer ua.Close()
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15126,
+			},
+		), WithCDRSink(serverCDRs))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			if d.ToUser() == "busy" {
+				d.Respond(sip.StatusBusyHere, "Busy Here", nil)
+				return
+			}
+			d.Ringing()
+			if err := d.Answer(); err != nil {
+				return
+			}
+			serverAnswered <- struct{}{}
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	clientCDRs := make(cdrChanSink, 10)
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := NewDiago(ua, WithTransport(Transport{Transport: "udp", BindHost: "127.0.0.1", BindPort: 0}), WithCDRSink(clientCDRs))
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	t.Run("Answered", func(t *testing.T) {
+		d, err := dg.Invite(ctx, sip.Uri{User: "bob", Host: "127.0.0.1", Port: 15126}, InviteOptions{})
+		require.NoError(t, err)
+		select {
+		case <-serverAnswered:
+		case <-time.After(2 * time.Second):
+			t.Fatal("server did not answer")
+		}
+		require.NoError(t, d.Hangup(ctx))
+		require.NoError(t, d.Close())
+
+		cdr := clientCDRs.next(t)
+		assert.Equal(t, CDRDirectionOutbound, cdr.Direction)
+		assert.Equal(t, d.InviteRequest.CallID().Value(), cdr.CallID)
+		assert.Equal(t, CDRHangupLocal, cdr.HangupBy)
+		assert.Equal(t, sip.StatusOK, cdr.StatusCode)
+		assert.Equal(t, "UDP", cdr.Transport)
+		assert.Equal(t, "PCMU", cdr.Codec)
+		assert.False(t, cdr.AnswerTime.IsZero())
+		assert.False(t, cdr.EndTime.Before(cdr.AnswerTime))
+
+		cdr = serverCDRs.next(t)
+		assert.Equal(t, CDRDirectionInbound, cdr.Direction)
+		assert.Equal(t, d.InviteRequest.CallID().Value(), cdr.CallID)
+		assert.Equal(t, CDRHangupRemote, cdr.HangupBy)
+		assert.Equal(t, sip.StatusOK, cdr.StatusCode)
+		assert.Contains(t, cdr.To, "bob@127.0.0.1")
+		assert.False(t, cdr.RingTime.IsZero())
+		assert.False(t, cdr.AnswerTime.Before(cdr.RingTime))
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		_, err := dg.Invite(ctx, sip.Uri{User: "busy", Host: "127.0.0.1", Port: 15126}, InviteOptions{})
+		require.Error(t, err)
+
+		cdr := clientCDRs.next(t)
+		assert.Equal(t, CDRHangupRemote, cdr.HangupBy)
+		assert.Equal(t, sip.StatusBusyHere, cdr.StatusCode)
+		assert.Equal(t, "Busy Here", cdr.Reason)
+		assert.True(t, cdr.AnswerTime.IsZero())
+
+		cdr = serverCDRs.next(t)
+		assert.Equal(t, CDRHangupLocal, cdr.HangupBy)
+		assert.Equal(t, sip.StatusBusyHere, cdr.StatusCode)
+	})
+}
@@ -13,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/emiago/diago/media"
@@ -32,11 +33,35 @@ type Diago struct {
 
 	auth      sipgo.DigestAuth
 	mediaConf MediaConfig
+	rel100    Rel100Mode
 
 	log *slog.Logger
 
 	cache            DialogCachePool
 	serverMiddleware func(next sipgo.RequestHandler) sipgo.RequestHandler
+
+	// failoverBlocked keeps destinations skipped by InviteFailover until Retry-After time
+	failoverBlocked sync.Map
+
+	subscriptions subscriptionCache
+	dialogEvents  dialogEventPackage
+
+	messageHandler atomic.Pointer[MessageHandlerFunc]
+	registrar      *Registrar
+	serverAuth     *serverAuth
+	admission      *admission
+
+	// registrations keeps registered RegisterTransaction for unregistering on shutdown
+	registrations      sync.Map
+	shutdown           atomic.Bool
+	shutdownRetryAfter atomic.Int64
+	// dialogRemoved is closed on dialog removal, waking up shutdown
+	dialogRemovedMu sync.Mutex
+	dialogRemoved   chan struct{}
+
+	dialogStore DialogDataStore
+	cdrSink     CDRSink
+	metrics     *Metrics
 }
 
 // We can extend this WithClientOptions, WithServerOptions
@@ -145,6 +170,7 @@ type MediaConfig struct {
 	externalIP net.IP
 	rtpNAT     int
 	dtlsConf   media.DTLSConfig
+	sessions   *mediaSessionLimit
 
 	// TODO, For now it is global on media package
 	// RTPPortStart int
@@ -212,6 +238,8 @@ func NewDiago(ua *sipgo.UserAgent, opts ...DiagoOption) *Diago {
 	for _, o := range opts {
 		o(dg)
 	}
+	dg.cache.client = &dialogCacheNotify[*DialogClientSession]{DialogCache: dg.cache.client, onDelete: dg.notifyDialogRemoved}
+	dg.cache.server = &dialogCacheNotify[*DialogServerSession]{DialogCache: dg.cache.server, onDelete: dg.notifyDialogRemoved}
 
 	if len(dg.transports) == 0 {
 		tran := Transport{
@@ -229,6 +257,23 @@ func NewDiago(ua *sipgo.UserAgent, opts ...DiagoOption) *Diago {
 
 	errHandler := func(f func(req *sip.Request, tx sip.ServerTransaction) error) sipgo.RequestHandler {
 		handler := func(req *sip.Request, tx sip.ServerTransaction) {
+			if dg.shutdownRejects(req) {
+				if err := tx.Respond(dg.shutdownResponse(req)); err != nil {
+					dg.log.Warn("Failed to respond on shutdown", "error", err, "req.method", req.Method.String())
+				}
+				return
+			}
+
+			// INVITE, MESSAGE and SUBSCRIBE are authorized by their handlers to keep identity
+			if dg.serverAuth != nil && !dg.serverAuth.keepsIdentity(req.Method) && dg.serverAuth.requires(req) {
+				if _, authorized, err := dg.serverAuth.authorize(req, tx); !authorized {
+					if err != nil {
+						dg.log.Warn("Failed to authorize request", "error", err, "req.method", req.Method.String(), "req.from", req.From().String())
+					}
+					return
+				}
+			}
+
 			if err := f(req, tx); err != nil {
 				dg.log.Warn("Failed to handle request", "error", err, "req.method", req.Method.String(), "req.from", req.From().String(), "req.to", req.To().Value())
 				return
@@ -262,6 +307,23 @@ func NewDiago(ua *sipgo.UserAgent, opts ...DiagoOption) *Diago {
 			return dg.handleReInvite(req, tx, id)
 		}
 
+		var identity AuthIdentity
+		if dg.serverAuth != nil && dg.serverAuth.requires(req) {
+			var authorized bool
+			identity, authorized, err = dg.serverAuth.authorize(req, tx)
+			if !authorized {
+				return err
+			}
+		}
+
+		if dg.admission != nil {
+			release, res := dg.admission.admit(req)
+			if res != nil {
+				return tx.Respond(res)
+			}
+			defer release()
+		}
+
 		tran, _ := dg.getTransport(req.Transport())
 
 		// Proceed as new call
@@ -276,10 +338,9 @@ func NewDiago(ua *sipgo.UserAgent, opts ...DiagoOption) *Diago {
 			return fmt.Errorf("handling new INVITE failed: %w", err)
 		}
 
-		// TODO authentication
 		dWrap := &DialogServerSession{
 			DialogServerSession: dialog,
-			DialogMedia:         DialogMedia{},
+			DialogMedia:         DialogMedia{metrics: dg.metrics},
 			// TODO we may actually just build media session with this conf here
 			mediaConf: MediaConfig{
 				Codecs:     dg.mediaConf.Codecs,
@@ -287,14 +348,42 @@ func NewDiago(ua *sipgo.UserAgent, opts ...DiagoOption) *Diago {
 				bindIP:     tran.mediaBindIP,
 				externalIP: tran.MediaExternalIP,
 				dtlsConf:   tran.MediaDTLSConf,
+				sessions:   dg.mediaSessionLimit(),
 			},
+			rel100:       dg.rel100,
+			authIdentity: identity,
 		}
+		dWrap.ctx, dWrap.cancel = context.WithCancelCause(dialog.Context())
+		dWrap.infoDTMFWriter = dWrap.writeInfoDTMF
+		tx.OnCancel(func(r *sip.Request) { dWrap.setRemoteReason(r) })
 
 		defer closeAndLog(dWrap, "closing dialog server returned error")
+		dg.trackServerCDR(dWrap)
+		dg.trackServerMetrics(dWrap)
+
+		if dg.rel100 == Rel100ModeRequired && !dWrap.remoteSupports100rel() {
+			// https://datatracker.ietf.org/doc/html/rfc3262#section-3
+			return dWrap.Respond(sip.StatusExtensionRequired, "Extension Required", nil, sip.NewHeader("Require", optionTag100rel))
+		}
+
+		if h := req.GetHeader("Replaces"); h != nil {
+			replaced, code, reason := dg.replacedDialog(h.Value())
+			if replaced == nil {
+				return dWrap.Respond(code, reason, nil)
+			}
+			dWrap.replaced = replaced
+			dWrap.OnState(func(s sip.DialogState) {
+				if s == sip.DialogStateConfirmed {
+					go dg.hangupReplaced(replaced)
+				}
+			})
+		}
 
 		if err := dg.cache.server.DialogStore(dWrap.Context(), dWrap.ID, dWrap); err != nil {
 			return fmt.Errorf("failed to store server dialog: %w", err)
 		}
+		dg.dialogEvents.trackServer(dWrap)
+		dg.trackDialogData(dWrap)
 		defer func() {
 			// TODO: have better context
 			if err := dg.cache.server.DialogDelete(context.Background(), dWrap.ID); err != nil {
@@ -305,7 +394,7 @@ func NewDiago(ua *sipgo.UserAgent, opts ...DiagoOption) *Diago {
 		dg.serveHandler(dWrap)
 
 		// Check is dialog closed
-		dialogCtx := dialog.Context()
+		dialogCtx := dWrap.Context()
 		// Always try hanguping call
 		ctx, cancel := context.WithTimeout(dialogCtx, 10*time.Second)
 		defer cancel()
@@ -362,16 +451,24 @@ func NewDiago(ua *sipgo.UserAgent, opts ...DiagoOption) *Diago {
 		if cd != nil {
 			defer closeAndLog(&cd.DialogMedia, "failed to close client media")
 
+			cd.cdr.remoteBye(req)
+			cd.setRemoteReason(req)
 			return cd.ReadBye(req, tx)
 		}
 
 		defer closeAndLog(&sd.DialogMedia, "failed to close server media")
+		sd.cdr.remoteBye(req)
+		sd.setRemoteReason(req)
 		return sd.ReadBye(req, tx)
 	}))
 
 	server.OnInfo(errHandler(func(req *sip.Request, tx sip.ServerTransaction) error {
 		// Handle DTMF out of band
-		if req.ContentType().Value() != "application/dtmf-relay" {
+		contentType := ""
+		if h := req.ContentType(); h != nil {
+			contentType = h.Value()
+		}
+		if _, ok := sipInfoDTMFContentType(contentType); !ok {
 			return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusNotAcceptable, "Not Acceptable", nil))
 		}
 
@@ -410,10 +507,42 @@ func NewDiago(ua *sipgo.UserAgent, opts ...DiagoOption) *Diago {
 		res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
 		res.AppendHeader(sip.NewHeader("Allow", strings.Join(methods, ", ")))
 		res.AppendHeader(sip.NewHeader("Accept", "application/sdp"))
-		// res.AppendHeader(sip.NewHeader("Supported", "replaces, 100rel"))
+		supported := []string{optionTagReplaces}
+		if dg.rel100 != Rel100ModeDisabled {
+			supported = append(supported, optionTag100rel)
+		}
+		res.AppendHeader(sip.NewHeader("Supported", strings.Join(supported, ", ")))
+		if events := dg.subscriptions.events(); len(events) > 0 {
+			res.AppendHeader(sip.NewHeader("Allow-Events", strings.Join(events, ", ")))
+		}
 		return tx.Respond(res)
 	}))
 
+	dg.server.OnPrack(errHandler(func(req *sip.Request, tx sip.ServerTransaction) error {
+		sd, cd, err := dg.cache.MatchDialog(req)
+		if err != nil {
+			return handleNoDialog(req, tx, err)
+		}
+
+		if cd != nil {
+			// We never send reliable provisional responses as UAC
+			return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil))
+		}
+		return sd.readPrack(req, tx)
+	}))
+
+	dg.server.OnUpdate(errHandler(func(req *sip.Request, tx sip.ServerTransaction) error {
+		sd, cd, err := dg.cache.MatchDialog(req)
+		if err != nil {
+			return handleNoDialog(req, tx, err)
+		}
+
+		if cd != nil {
+			return cd.handleUpdate(req, tx)
+		}
+		return sd.handleUpdate(req, tx)
+	}))
+
 	dg.server.OnRefer(errHandler(func(req *sip.Request, tx sip.ServerTransaction) error {
 		sd, cd, err := dg.cache.MatchDialog(req)
 		if err != nil {
@@ -429,8 +558,24 @@ func NewDiago(ua *sipgo.UserAgent, opts ...DiagoOption) *Diago {
 		return nil
 	}))
 
+	dg.server.OnSubscribe(errHandler(func(req *sip.Request, tx sip.ServerTransaction) error {
+		return dg.handleSubscribe(req, tx)
+	}))
+
+	if dg.registrar != nil {
+		dg.server.OnRegister(errHandler(dg.registrar.handleRegister))
+	}
+
+	dg.server.OnMessage(errHandler(func(req *sip.Request, tx sip.ServerTransaction) error {
+		return dg.handleMessage(req, tx)
+	}))
+
 	dg.server.OnNotify(errHandler(func(req *sip.Request, tx sip.ServerTransaction) error {
-		// THIS should match now subscribtion instead dialog
+		if s, ok := dg.subscriptions.loadClient(subscriptionKeyFromRequest(req)); ok {
+			return s.handleNotify(req, tx)
+		}
+
+		// Implicit subscription created by REFER is matched by dialog
 		sd, cd, err := dg.cache.MatchDialog(req)
 		if err != nil {
 			return handleNoDialog(req, tx, err)
@@ -472,10 +617,18 @@ func (dg *Diago) handleReInvite(req *sip.Request, tx sip.ServerTransaction, id s
 			return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil))
 		}
 
-		return c.handleReInvite(req, tx)
+		if err := c.handleReInvite(req, tx); err != nil {
+			return err
+		}
+		dg.saveDialogData(c)
+		return nil
 	}
 
-	return s.handleReInvite(req, tx)
+	if err := s.handleReInvite(req, tx); err != nil {
+		return err
+	}
+	dg.saveDialogData(s)
+	return nil
 }
 
 // Serve starts 'Server' handle for SIP.
@@ -559,6 +712,10 @@ type InviteOptions struct {
 	Password string
 	// Custom headers to pass. DO NOT SET THIS to nil
 	Headers []sip.Header
+	// Redirect follows 3xx responses
+	Redirect InviteRedirectOptions
+	// LateOffer sends INVITE without SDP and answers 200 OK offer in ACK
+	LateOffer bool
 }
 
 // Invite makes outgoing call leg and waits for answer.
@@ -568,8 +725,28 @@ type InviteOptions struct {
 //
 // For better control more details use above functions instead.
 // If you want to bridge call then use helper InviteBridge
+//
+// With registrar, AOR registered with multiple contacts is called on all contacts as with InviteFork
 func (dg *Diago) Invite(ctx context.Context, recipient sip.Uri, opts InviteOptions) (d *DialogClientSession, err error) {
-	d, err = dg.NewDialog(recipient, NewDialogOptions{Transport: opts.Transport})
+	dialogOpts := NewDialogOptions{Transport: opts.Transport}
+	if dg.registrar != nil {
+		targets, err := dg.registrar.resolve(ctx, recipient)
+		if err != nil {
+			return nil, err
+		}
+
+		// Registered AOR with multiple contacts is called on all of them
+		if len(targets) > 1 {
+			return dg.inviteRegistered(ctx, targets, opts)
+		}
+		if len(targets) == 1 {
+			recipient = targets[0].Recipient
+			opts.Headers = mergeHeaders(opts.Headers, targets[0].Headers)
+			dialogOpts.resolved = true
+		}
+	}
+
+	d, err = dg.newDialog(ctx, recipient, dialogOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -580,6 +757,8 @@ func (dg *Diago) Invite(ctx context.Context, recipient sip.Uri, opts InviteOptio
 		Headers:    opts.Headers,
 		Username:   opts.Username,
 		Password:   opts.Password,
+		Redirect:   opts.Redirect,
+		LateOffer:  opts.LateOffer,
 	}); err != nil {
 		closeErr := d.Close()
 		return nil, errors.Join(err, closeErr)
@@ -597,7 +776,7 @@ func (dg *Diago) Invite(ctx context.Context, recipient sip.Uri, opts InviteOptio
 // If bridge has Originator (first participant) it will be used for creating outgoing call leg as in B2BUA
 // When bridge is provided then this call will be bridged with any participant already present in bridge
 func (dg *Diago) InviteBridge(ctx context.Context, recipient sip.Uri, bridge *Bridge, opts InviteOptions) (d *DialogClientSession, err error) {
-	d, err = dg.NewDialog(recipient, NewDialogOptions{})
+	d, err = dg.newDialog(ctx, recipient, NewDialogOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -613,7 +792,17 @@ func (dg *Diago) InviteBridge(ctx context.Context, recipient sip.Uri, bridge *Br
 		Headers:    opts.Headers,
 		Username:   opts.Username,
 		Password:   opts.Password,
+		Redirect:   opts.Redirect,
+		LateOffer:  opts.LateOffer,
 	}); err != nil {
+		// Failure cause is carried to originator
+		if res := dialogErrorResponse(err); res != nil && opts.Originator != nil {
+			r, ok := reasonFromMessage(res)
+			if !ok {
+				r = ReasonSIP(res.StatusCode, res.Reason)
+			}
+			opts.Originator.Media().carryReason(r)
+		}
 		return nil, errors.Join(err, d.Hangup(d.Context()), d.Close())
 	}
 
@@ -635,36 +824,50 @@ type NewDialogOptions struct {
 	Transport string
 	// TransportID matches diago transport by ID instead protocol
 	TransportID string
+
+	// resolved recipient is contact already resolved by registrar
+	resolved bool
 }
 
 // NewDialog creates a new client dialog session after you can perform dialog Invite
 // - You call Invite(...) after this call followed with ACK
+// Registered AOR is resolved to its most preferred contact
 func (dg *Diago) NewDialog(recipient sip.Uri, opts NewDialogOptions) (d *DialogClientSession, err error) {
-	transport := opts.Transport
-	if transport == "" && recipient.UriParams != nil {
-		if t, ok := recipient.UriParams.Get("transport"); t != "" && ok {
-			transport = t
-			recipient.UriParams.Remove("transport")
-		}
+	return dg.newDialog(context.Background(), recipient, opts)
+}
 
+func (dg *Diago) newDialog(ctx context.Context, recipient sip.Uri, opts NewDialogOptions) (d *DialogClientSession, err error) {
+	// Registered AOR is resolved to its contact
+	var routes []sip.Header
+	if dg.registrar != nil && !opts.resolved {
+		targets, err := dg.registrar.resolve(ctx, recipient)
+		if err != nil {
+			return nil, err
+		}
+		if len(targets) > 0 {
+			recipient, routes = targets[0].Recipient, targets[0].Headers
+		}
 	}
-	tran, exists := dg.findTransport(transport, opts.TransportID)
-	if !exists {
-		return nil, fmt.Errorf("transport %s does not exists", transport)
+
+	tran, err := dg.recipientTransport(&recipient, opts.Transport, opts.TransportID)
+	if err != nil {
+		return nil, err
 	}
 
-	return dg.newSipDialog(recipient, tran, opts)
+	d, err = dg.newSipDialog(recipient, tran, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range routes {
+		d.InviteRequest.AppendHeader(r)
+	}
+	return d, nil
 }
 
 func (dg *Diago) newSipDialog(recipient sip.Uri, tran *Transport, opts NewDialogOptions) (d *DialogClientSession, err error) {
 	transport := tran.Transport
 
-	// TODO: remove this alloc of UA each time
-	client := dg.getClient(tran)
-	dialogUA := sipgo.DialogUA{
-		Client:         client,
-		RewriteContact: tran.RewriteContact,
-	}
+	dialogUA, requester := newDialogClientUA(dg.getClient(tran), tran.RewriteContact)
 	dg.contactHDRFromTransport(tran, &dialogUA.ContactHDR)
 
 	inviteReq := sip.NewRequest(sip.INVITE, recipient)
@@ -672,13 +875,15 @@ func (dg *Diago) newSipDialog(recipient sip.Uri, tran *Transport, opts NewDialog
 
 	d = &DialogClientSession{
 		DialogClientSession: &sipgo.DialogClientSession{
-			UA: &dialogUA,
+			UA: dialogUA,
 			Dialog: sipgo.Dialog{
 				InviteRequest: inviteReq,
 			},
 		},
+		requester: requester,
 	}
 	d.Init()
+	d.ctx, d.cancel = context.WithCancelCause(d.DialogClientSession.Context())
 
 	d.mediaConfig = MediaConfig{
 		Codecs:     dg.mediaConf.Codecs,
@@ -686,7 +891,11 @@ func (dg *Diago) newSipDialog(recipient sip.Uri, tran *Transport, opts NewDialog
 		bindIP:     tran.mediaBindIP,
 		externalIP: tran.MediaExternalIP,
 		dtlsConf:   tran.MediaDTLSConf,
+		sessions:   dg.mediaSessionLimit(),
 	}
+	d.infoDTMFWriter = d.writeInfoDTMF
+	d.metrics = dg.metrics
+	d.rel100 = dg.rel100
 
 	// This should be run on ACK
 	d.OnState(func(s sip.DialogState) {
@@ -703,6 +912,10 @@ func (dg *Diago) newSipDialog(recipient sip.Uri, tran *Transport, opts NewDialog
 	d.OnClose(func() error {
 		return dg.cache.client.DialogDelete(context.Background(), d.ID)
 	})
+	dg.dialogEvents.trackClient(d)
+	dg.trackDialogData(d)
+	dg.trackClientCDR(d)
+	dg.trackClientMetrics(d, transport)
 	return d, nil
 }
 
@@ -751,6 +964,22 @@ func (dg *Diago) getTransport(transport string) (*Transport, bool) {
 	return nil, false
 }
 
+// recipientTransport finds transport for request to recipient.
+// Without transport or ID set, transport uri param of recipient is used and removed from it
+func (dg *Diago) recipientTransport(recipient *sip.Uri, transport string, id string) (*Transport, error) {
+	if transport == "" && recipient.UriParams != nil {
+		if t, ok := recipient.UriParams.Get("transport"); t != "" && ok {
+			transport = t
+			recipient.UriParams.Remove("transport")
+		}
+	}
+	tran, exists := dg.findTransport(transport, id)
+	if !exists {
+		return nil, fmt.Errorf("transport %s does not exists", transport)
+	}
+	return tran, nil
+}
+
 func (dg *Diago) findTransport(transport string, id string) (*Transport, bool) {
 	if id != "" {
 		for _, t := range dg.transports {
@@ -782,7 +1011,12 @@ func (dg *Diago) Register(ctx context.Context, recipient sip.Uri, opts RegisterO
 
 	// Unregister
 	defer func() {
-		ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+		// Already unregistered on shutdown. Deleting makes sure only one side unregisters
+		if _, ok := dg.registrations.LoadAndDelete(t); !ok {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
 		err := t.Unregister(ctx)
 		if err != nil {
 			dg.log.Error("Failed to unregister", "error", err)
@@ -849,7 +1083,20 @@ func (dg *Diago) RegisterTransaction(ctx context.Context, recipient sip.Uri, opt
 	// 	return nil, err
 	// }
 	client := dg.getClient(tran)
-	return newRegisterTransaction(client, recipient, contactHDR, dg.log, opts), nil
+	t := newRegisterTransaction(client, recipient, contactHDR, dg.log, opts)
+	// Contact is taken before it is updated by NAT, so that registration is tracked under same key
+	contact := contactHDR.Address.String()
+	t.onRegistered = func(registered bool) {
+		if registered {
+			dg.registrations.Store(t, struct{}{})
+		} else {
+			dg.registrations.Delete(t)
+		}
+		if dg.metrics != nil {
+			dg.metrics.setRegistered(recipient.String(), contact, registered)
+		}
+	}
+	return t, nil
 }
 
 func (dg *Diago) createClient(tran Transport) (client *sipgo.Client) {
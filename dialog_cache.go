@@ -48,11 +48,6 @@ func (m *dialogCacheMap[T]) DialogRange(ctx context.Context, f func(id string, d
 	return nil
 }
 
-type DialogData struct {
-	InviteRequest sip.Request
-	State         sip.DialogState
-}
-
 type DialogCachePool struct {
 	client DialogCache[*DialogClientSession]
 	server DialogCache[*DialogServerSession]
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	mrand "math/rand/v2"
 	"net"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -33,6 +34,36 @@ type DialogClientSession struct {
 	mediaConfig   MediaConfig
 
 	closed atomic.Uint32
+
+	rel100 Rel100Mode
+	// prackRSeq is last RSeq acknowledged with PRACK and prackTag is remote tag of that response.
+	// Protected by mu
+	prackRSeq uint32
+	prackTag  string
+	// remoteCSeq is last CSeq of request received within dialog. Protected by mu
+	remoteCSeq uint32
+
+	// requester sends all dialog requests. It is set once on dialog creation
+	requester *dialogClientRequester
+
+	// ctx is canceled when diago terminates dialog, ex. on session timer expiry
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	sessionTimerOpts SessionTimerOptions
+	sessionTimer     *sessionTimer
+
+	// onProvisional is called for every provisional response received on INVITE
+	onProvisional func(res *sip.Response)
+
+	// cdr is set when CDR sink is configured
+	cdr *cdrRecord
+
+	// redirects are targets followed on 3xx responses
+	redirects []sip.Uri
+
+	// lateOfferAnswer is our SDP answer sent in ACK when INVITE had no offer
+	lateOfferAnswer []byte
 }
 
 func (d *DialogClientSession) Close() error {
@@ -48,8 +79,16 @@ func (d *DialogClientSession) Id() string {
 	return d.ID
 }
 
+// Context returns dialog context. It is done when dialog is terminated
+func (d *DialogClientSession) Context() context.Context {
+	if d.ctx != nil {
+		return d.ctx
+	}
+	return d.DialogClientSession.Context()
+}
+
 func (d *DialogClientSession) Hangup(ctx context.Context) error {
-	return d.Bye(ctx)
+	return d.HangupOptions(ctx, HangupOptions{})
 }
 
 func (d *DialogClientSession) FromUser() string {
@@ -100,6 +139,18 @@ type InviteClientOptions struct {
 	Headers []sip.Header
 	// Stop on early media. ErrClientEarlyMedia will be returned
 	EarlyMediaDetect bool
+
+	// SessionTimer requests session timer (RFC 4028).
+	// On 422 Session Interval Too Small, INVITE is resent with Min-SE of response.
+	SessionTimer SessionTimerOptions
+
+	// Redirect follows 3xx responses
+	Redirect InviteRedirectOptions
+
+	// LateOffer sends INVITE without SDP (delayed offer).
+	// SDP of 200 OK is treated as offer and our answer is sent with Ack.
+	// Media session is created only when answer is received.
+	LateOffer bool
 }
 
 // WithAnonymousCaller sets from user Anonymous per RFC
@@ -125,6 +176,10 @@ func (o *InviteClientOptions) WithCaller(displayName string, callerID string, ho
 // Normal Answer with 200 OK (SDP)
 // - You MUST call Ack() after to acknowledge session.
 //
+// Late Offer:
+// - LateOffer=true sends INVITE without SDP and media is created on 200 OK
+// - Ack() sends SDP answer
+//
 // Early Media Detect:
 // - EarlyMediaDetect=true must be set as part of options otherwise it ignores early media
 // - It RETURNS ErrClientEarlyMedia if remote answers with 183 Session in Progress
@@ -138,7 +193,11 @@ func (o *InviteClientOptions) WithCaller(displayName string, callerID string, ho
 // NOTE: It updates internal invite request so NOT THREAD SAFE.
 // If you pass originator it will use originator to set correct from header and avoid media transcoding
 func (d *DialogClientSession) Invite(ctx context.Context, opts InviteClientOptions) error {
-	if err := d.initMediaSessionFromConf(d.mediaConfig); err != nil {
+	if opts.LateOffer {
+		if opts.EarlyMediaDetect {
+			return fmt.Errorf("early media detect is not supported with late offer")
+		}
+	} else if err := d.initMediaSessionFromConf(d.mediaConfig); err != nil {
 		return err
 	}
 	return d.invite(ctx, &d.DialogMedia, opts)
@@ -172,7 +231,7 @@ func (d *DialogClientSession) invite(ctx context.Context, med *DialogMedia, opts
 		// Avoid transcoding if originator present
 		// Check ContentType and body present
 		contType := origInvite.ContentType()
-		if body := origInvite.Body(); body != nil && (contType != nil && contType.Value() == "application/sdp") {
+		if body := origInvite.Body(); !opts.LateOffer && body != nil && (contType != nil && contType.Value() == "application/sdp") {
 			// apply remote SDP
 			if err := sess.RemoteSDP(body); err != nil {
 				return fmt.Errorf("failed to apply originator sdp: %w", err)
@@ -225,8 +284,29 @@ func (d *DialogClientSession) invite(ctx context.Context, med *DialogMedia, opts
 
 	dialogCli := d.UA
 	inviteReq.AppendHeader(&dialogCli.ContactHDR)
-	inviteReq.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
-	inviteReq.SetBody(sess.LocalSDP())
+	if !opts.LateOffer {
+		inviteReq.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+		inviteReq.SetBody(sess.LocalSDP())
+	}
+
+	supported := []string{}
+	switch d.rel100 {
+	case Rel100ModeSupported:
+		supported = append(supported, optionTag100rel)
+	case Rel100ModeRequired:
+		inviteReq.AppendHeader(sip.NewHeader("Require", optionTag100rel))
+	}
+
+	d.sessionTimerOpts = opts.SessionTimer
+	if st := opts.SessionTimer; st.enabled() {
+		supported = append(supported, optionTagTimer)
+		inviteReq.AppendHeader(sip.NewHeader("Session-Expires", sessionExpires{interval: st.interval()}.String()))
+		inviteReq.AppendHeader(sip.NewHeader("Min-SE", strconv.Itoa(int(st.minSE()/time.Second))))
+	}
+
+	if len(supported) > 0 {
+		inviteReq.AppendHeader(sip.NewHeader("Supported", strings.Join(supported, ", ")))
+	}
 
 	// We allow changing full from header, but we need to make sure it is correctly set
 	// If users specify 'tag' parameter it is assumed that they know what they do
@@ -250,33 +330,174 @@ func (d *DialogClientSession) invite(ctx context.Context, med *DialogMedia, opts
 	// via := inviteReq.Via()
 	// if via.Host == "" {
 	// }
-	err := d.DialogClientSession.Invite(ctx, func(c *sipgo.Client, req *sip.Request) error {
-		// Do nothing
-		return nil
-	})
-	if err != nil {
-		// sess.Close()
+	authAttempts := 0
+	redirect := newInviteRedirect(opts.Redirect, inviteReq.Recipient)
+	for {
+		err := d.writeInvite(ctx)
+		if err != nil {
+			// sess.Close()
+			return err
+		}
+		// Digest auth is handled by digestAuthRetry
+		ansOpts := sipgo.AnswerOptions{
+			OnResponse: d.prackOnResponse(opts.OnResponse),
+		}
+
+		if opts.EarlyMediaDetect {
+			err = d.waitAnswerEarly(ctx, med, ansOpts)
+		} else {
+			err = d.waitAnswer(ctx, med, ansOpts)
+		}
+
+		if d.sessionTimerRetry(err) || d.digestAuthRetry(err, opts, &authAttempts) {
+			if err := sipgo.ClientRequestBuild(client, inviteReq); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if d.redirectRetry(err, redirect) {
+			// New target may challenge again
+			authAttempts = 0
+			if err := sipgo.ClientRequestBuild(client, inviteReq); err != nil {
+				return err
+			}
+			continue
+		}
 		return err
 	}
-	ansOpts := sipgo.AnswerOptions{
-		Username:   opts.Username,
-		Password:   opts.Password,
-		OnResponse: opts.OnResponse,
+}
+
+// digestAuthRetry updates INVITE with digest authorization when 401 or 407 is received.
+// Challenge is answered once, and once more if server responds with stale nonce
+func (d *DialogClientSession) digestAuthRetry(err error, opts InviteClientOptions, attempts *int) bool {
+	res := dialogErrorResponse(err)
+	if opts.Password == "" || res == nil {
+		return false
+	}
+	if res.StatusCode != sip.StatusUnauthorized && res.StatusCode != sip.StatusProxyAuthRequired {
+		return false
+	}
+	if *attempts >= 2 || (*attempts > 0 && !digestAuthStale(res)) {
+		return false
 	}
+	*attempts++
 
-	if opts.EarlyMediaDetect {
-		return d.waitAnswerEarly(ctx, med, ansOpts)
+	inviteReq := d.InviteRequest
+	if err := digestAuthApply(inviteReq, res, sipgo.DigestAuth{Username: opts.Username, Password: opts.Password}); err != nil {
+		return false
+	}
+	requestNewTransaction(inviteReq)
+	return true
+}
+
+// sessionTimerRetry updates INVITE for resending when 422 Session Interval Too Small is received
+// https://datatracker.ietf.org/doc/html/rfc4028#section-7.4
+func (d *DialogClientSession) sessionTimerRetry(err error) bool {
+	res := dialogErrorResponse(err)
+	if !d.sessionTimerOpts.enabled() || res == nil || res.StatusCode != sip.StatusIntervalToBrief {
+		return false
 	}
 
-	return d.waitAnswer(ctx, med, ansOpts)
+	h := res.GetHeader("Min-SE")
+	if h == nil {
+		return false
+	}
+	minSE, err := parseDeltaSeconds(h.Value())
+	if err != nil || minSE <= d.sessionTimerOpts.interval() {
+		return false
+	}
+
+	d.sessionTimerOpts.SessionExpires = minSE
+	d.sessionTimerOpts.MinSE = minSE
+
+	inviteReq := d.InviteRequest
+	inviteReq.ReplaceHeader(sip.NewHeader("Session-Expires", sessionExpires{interval: minSE}.String()))
+	inviteReq.ReplaceHeader(sip.NewHeader("Min-SE", strconv.Itoa(int(minSE/time.Second))))
+	requestNewTransaction(inviteReq)
+	return true
 }
 
 // WaitAnswer waits dialog on answer. It should only be used if you have error Invite but still want to continue
 // ex. ErrClientEarlyMedia was returned but you want to proceed with answering
 func (d *DialogClientSession) WaitAnswer(ctx context.Context, opts sipgo.AnswerOptions) error {
+	opts.OnResponse = d.prackOnResponse(opts.OnResponse)
 	return d.waitAnswer(ctx, &d.DialogMedia, opts)
 }
 
+// prackOnResponse wraps response handling with sending PRACK on reliable provisional responses.
+// Retransmissions of already acknowledged responses are not passed further.
+// It also reports provisional responses to dialog event tracking.
+func (d *DialogClientSession) prackOnResponse(onResponse func(res *sip.Response) error) func(res *sip.Response) error {
+	return func(res *sip.Response) error {
+		retransmission, err := d.prack(res)
+		if err != nil || retransmission {
+			return err
+		}
+
+		if res.IsProvisional() && d.onProvisional != nil {
+			d.onProvisional(res)
+		}
+
+		if onResponse != nil {
+			return onResponse(res)
+		}
+		return nil
+	}
+}
+
+// prack sends PRACK if response is reliable provisional response. RFC 3262
+func (d *DialogClientSession) prack(res *sip.Response) (retransmission bool, err error) {
+	if !res.IsProvisional() || res.StatusCode == sip.StatusTrying || !sipHeaderHasOption(res, "Require", optionTag100rel) {
+		return false, nil
+	}
+
+	h := res.GetHeader("RSeq")
+	if h == nil {
+		return false, fmt.Errorf("reliable provisional response without RSeq")
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(h.Value()), 10, 32)
+	if err != nil {
+		return false, fmt.Errorf("invalid RSeq: %w", err)
+	}
+	rseq := uint32(n)
+
+	tag, _ := res.To().Params.Get("tag")
+	d.mu.Lock()
+	retransmission = tag == d.prackTag && d.prackRSeq != 0 && rseq <= d.prackRSeq
+	d.mu.Unlock()
+	if retransmission {
+		// https://datatracker.ietf.org/doc/html/rfc3262#section-4
+		// If the UAC receives a retransmission, it SHOULD NOT retransmit PRACK
+		return true, nil
+	}
+
+	recipient := d.InviteRequest.Recipient
+	if cont := res.Contact(); cont != nil {
+		recipient = cont.Address
+	}
+
+	req := sip.NewRequest(sip.PRACK, recipient)
+	req.AppendHeader(sip.NewHeader("RAck", fmt.Sprintf("%d %d %s", rseq, d.InviteRequest.CSeq().SeqNo, sip.INVITE)))
+
+	ctx, cancel := context.WithTimeout(d.Context(), sip.Timer_F)
+	defer cancel()
+	prackRes, err := d.Do(ctx, req)
+	if err != nil {
+		return false, fmt.Errorf("sending PRACK failed: %w", err)
+	}
+
+	if !prackRes.IsSuccess() {
+		return false, sipgo.ErrDialogResponse{Res: prackRes}
+	}
+
+	d.mu.Lock()
+	d.prackRSeq = rseq
+	d.prackTag = tag
+	d.mu.Unlock()
+	return false, nil
+}
+
 func (d *DialogClientSession) waitAnswerEarly(ctx context.Context, med *DialogMedia, opts sipgo.AnswerOptions) error {
 	sess := med.mediaSession
 	onResps := opts.OnResponse
@@ -343,17 +564,54 @@ func (d *DialogClientSession) waitAnswer(ctx context.Context, med *DialogMedia,
 		return fmt.Errorf("no SDP in response")
 	}
 
-	if err := d.applyRemoteSDP(med, remoteSDP); err != nil {
+	lateOffer := false
+	if err := func() error {
+		med.mu.Lock()
+		lateOffer = med.mediaSession == nil
+		med.mu.Unlock()
+		if lateOffer {
+			// Late offer. Response SDP is offer which we answer in ACK
+			if err := med.initMediaSessionFromConf(d.mediaConfig); err != nil {
+				return err
+			}
+		}
+		return d.applyRemoteSDP(med, remoteSDP)
+	}(); err != nil {
 		// Terminate call. Call must be ACK before doing BYE
-		if err := d.Ack(ctx); err != nil {
-			return errors.Join(err, d.Ack(ctx))
+		if lateOffer {
+			// ACK must carry answer, so all offered streams are rejected
+			d.lateOfferAnswer = lateOfferReject(med, remoteSDP)
+		}
+		if ackErr := d.Ack(ctx); ackErr != nil {
+			return errors.Join(err, ackErr)
 		}
 		return errors.Join(err, d.Bye(ctx))
 	}
 
+	if lateOffer {
+		d.lateOfferAnswer = med.mediaSession.LocalSDP()
+	}
+
+	d.negotiateSessionTimer(d.InviteResponse)
 	return nil
 }
 
+// lateOfferReject returns answer rejecting all streams of offer. It is nil if offer can not be parsed
+func lateOfferReject(med *DialogMedia, offer []byte) []byte {
+	ip := net.IPv4zero
+	med.mu.Lock()
+	if med.mediaSession != nil && med.mediaSession.Laddr.IP != nil {
+		ip = med.mediaSession.Laddr.IP
+	}
+	med.mu.Unlock()
+
+	answer, err := sdp.GenerateRejectAnswer(ip, offer)
+	if err != nil {
+		return nil
+	}
+	return answer
+}
+
 func (d *DialogClientSession) applyRemoteSDP(med *DialogMedia, remoteSDP []byte) error {
 	sess := med.mediaSession
 
@@ -388,14 +646,19 @@ func (d *DialogClientSession) Ack(ctx context.Context) error {
 		recipient = contact.Address
 	}
 
-	if err := d.ack(ctx, recipient, nil); err != nil {
+	if err := d.ack(ctx, recipient, inviteRequest.CSeq().SeqNo, d.lateOfferAnswer); err != nil {
 		return err
 	}
+	d.startSessionTimer()
 
 	// NOTE it generally advisable todo this after successfull ACK:
 	// Server may not even listen yet as it is waiting for ACK
-	if d.mediaSession != nil {
-		if err := d.mediaSession.Finalize(); err != nil {
+	// Media session can be already updated by remote after ACK
+	d.mu.Lock()
+	msess := d.mediaSession
+	d.mu.Unlock()
+	if msess != nil {
+		if err := msess.Finalize(); err != nil {
 			return err
 		}
 	}
@@ -403,12 +666,7 @@ func (d *DialogClientSession) Ack(ctx context.Context) error {
 	return nil
 }
 
-// AckLate sends ACK with media. Use this in combination with late(delay) offer
-// func (d *DialogClientSession) AckLate(ctx context.Context) error {
-// 	return d.ack(ctx, d.mediaSession.LocalSDP())
-// }
-
-func (d *DialogClientSession) ack(ctx context.Context, remoteTarget sip.Uri, body []byte) error {
+func (d *DialogClientSession) ack(ctx context.Context, remoteTarget sip.Uri, cseq uint32, body []byte) error {
 	// inviteRequest := d.InviteRequest
 	// recipient := &inviteRequest.Recipient
 	// if contact := d.InviteResponse.Contact(); contact != nil {
@@ -425,6 +683,9 @@ func (d *DialogClientSession) ack(ctx context.Context, remoteTarget sip.Uri, bod
 		ackRequest.SetBody(body)
 	}
 
+	// ACK must have CSeq of INVITE it acknowledges, while dialog may have sent PRACK or UPDATE in between.
+	// Retransmitted ACKs are written through same requester
+	d.requester.ackCSeq.Store(cseq)
 	if err := d.DialogClientSession.WriteAck(ctx, ackRequest); err != nil {
 		return err
 	}
@@ -439,6 +700,77 @@ func (d *DialogClientSession) ack(ctx context.Context, remoteTarget sip.Uri, bod
 	return nil
 }
 
+// writeInvite sends INVITE with client directly instead dialog requester, so that sipgo sets Contact by connection
+func (d *DialogClientSession) writeInvite(ctx context.Context) error {
+	ua := d.UA
+	inviteUA := *ua
+	inviteUA.Client = d.requester.client
+	d.UA = &inviteUA
+	defer func() { d.UA = ua }()
+
+	return d.DialogClientSession.Invite(ctx, func(c *sipgo.Client, req *sip.Request) error {
+		// Do nothing
+		return nil
+	})
+}
+
+// dialogClientRequester is transaction requester of single client dialog.
+// sipgo builds ACK with last CSeq used within dialog, which after PRACK or early UPDATE does not match INVITE anymore,
+// so ACK CSeq is rewritten here. Other requests are passed to client TransactionRequest.
+type dialogClientRequester struct {
+	client  *sipgo.Client
+	ackCSeq atomic.Uint32
+	// restored is set when INVITE of restored dialog is passed only to create its transaction
+	restored atomic.Bool
+}
+
+// newDialogClientUA creates dialog UA with its own client, never shared with other dialogs
+func newDialogClientUA(client *sipgo.Client, rewriteContact bool) (*sipgo.DialogUA, *dialogClientRequester) {
+	requester := &dialogClientRequester{client: client}
+	dialogClient := *client
+	dialogClient.TxRequester = requester
+	return &sipgo.DialogUA{
+		Client:         &dialogClient,
+		RewriteContact: rewriteContact,
+	}, requester
+}
+
+func (r *dialogClientRequester) Request(ctx context.Context, req *sip.Request) (sip.ClientTransaction, error) {
+	noop := func(c *sipgo.Client, req *sip.Request) error { return nil }
+	if req.IsAck() {
+		if cseq := req.CSeq(); cseq != nil {
+			cseq.SeqNo = r.ackCSeq.Load()
+		}
+		// Request is already built by dialog
+		return nil, r.client.WriteRequest(req, noop)
+	}
+
+	if req.IsInvite() && r.restored.CompareAndSwap(true, false) {
+		// INVITE of restored dialog is not sent. Its transaction is long gone, so it is created terminated
+		tx, err := r.client.TransactionLayer().NewClientTransaction(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		tx.Terminate()
+		return tx, nil
+	}
+
+	return r.client.TransactionRequest(ctx, req, noop)
+}
+
+// ReadRequest validates CSeq of request within dialog. Remote CSeq is tracked here,
+// as sipgo starts it from our INVITE CSeq and it can not be restored
+func (d *DialogClientSession) ReadRequest(req *sip.Request, tx sip.ServerTransaction) error {
+	cseq := req.CSeq().SeqNo
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cseq < d.remoteCSeq {
+		return sipgo.ErrDialogInvalidCseq
+	}
+	d.remoteCSeq = cseq
+	return nil
+}
+
 // ReInvite sends new invite based on current media session
 func (d *DialogClientSession) ReInvite(ctx context.Context) error {
 	d.mu.Lock()
@@ -500,7 +832,7 @@ func (d *DialogClientSession) reInviteDo(ctx context.Context, req *sip.Request)
 		}
 
 		// Now do ACK on new Contact
-		if err := d.ack(ctx, res.Contact().Address, nil); err != nil {
+		if err := d.ack(ctx, res.Contact().Address, res.CSeq().SeqNo, nil); err != nil {
 			return res, err
 		}
 
@@ -529,7 +861,7 @@ func (d *DialogClientSession) reInviteMediaSession(ctx context.Context, ms *medi
 	}
 
 	// Save new remote target contact and update media
-	return func() error {
+	err = func() error {
 		d.mu.Lock()
 		defer d.mu.Unlock()
 		d.remoteContactTarget = res.Contact()
@@ -541,6 +873,34 @@ func (d *DialogClientSession) reInviteMediaSession(ctx context.Context, ms *medi
 
 		return d.mediaUpdateUnsafe(ms)
 	}()
+	if err != nil {
+		return err
+	}
+	d.holdUpdate()
+	return nil
+}
+
+// Update sends UPDATE (RFC 3311) with current media session as offer and applies answer.
+// Unlike ReInvite it can be used in early dialog, ex. after Invite returned ErrClientEarlyMedia
+// and before WaitAnswer.
+func (d *DialogClientSession) Update(ctx context.Context) error {
+	if d.InviteResponse == nil {
+		return fmt.Errorf("update: no dialog established")
+	}
+
+	d.mu.Lock()
+	if d.rtpSession == nil {
+		d.mu.Unlock()
+		return fmt.Errorf("update: media is not negotiated")
+	}
+	ms := d.mediaSession.Fork()
+	contact := d.remoteContactUnsafe()
+	d.mu.Unlock()
+
+	if contact == nil {
+		return fmt.Errorf("update: no remote contact present")
+	}
+	return dialogUpdateMedia(ctx, d, contact.Address, d.InviteRequest.Contact(), ms)
 }
 
 // reInvites withs empty SDP are way to keep alive or do some post media update after receiving offer on 2xx
@@ -577,6 +937,18 @@ func (d *DialogClientSession) Refer(ctx context.Context, referTo sip.Uri, header
 	})
 }
 
+// ReferReplaces does attended transfer. It refers remote side to target of replaceDialog
+// with embedded Replaces header, so that new call replaces replaceDialog (RFC 3891).
+//
+// NOTE: It is expected that after calling this you are hanguping call to send BYE
+func (d *DialogClientSession) ReferReplaces(ctx context.Context, replaceDialog DialogSession, headers ...sip.Header) error {
+	referTo, err := dialogReferReplacesTarget(replaceDialog)
+	if err != nil {
+		return fmt.Errorf("refer replaces: %w", err)
+	}
+	return d.Refer(ctx, referTo, headers...)
+}
+
 type ReferClientOptions struct {
 	Headers []sip.Header
 	// OnNotify sends notify status code.
@@ -615,32 +987,103 @@ func (d *DialogClientSession) handleReInvite(req *sip.Request, tx sip.ServerTran
 		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - "+err.Error(), nil))
 	}
 
-	return d.handleMediaUpdate(req, tx, d.InviteRequest.Contact())
+	d.mu.Lock()
+	st := d.sessionTimer
+	d.mu.Unlock()
+
+	var headers []sip.Header
+	if st != nil {
+		headers = st.handleRefresh(req)
+	}
+	return d.handleMediaUpdate(req, tx, d.InviteRequest.Contact(), headers...)
 }
 
-func (d *DialogClientSession) handleReInviteACK(req *sip.Request, tx sip.ServerTransaction) error {
-	// Check do we need to handle Late Offer from ACK and update media
-	body := req.Body()
-	if body != nil {
-		// Update media session state under lock, but invoke the app callback after unlock to avoid deadlocks.
-		d.mu.Lock()
-		err := d.sdpUpdateUnsafe(body)
-		onMediaUpdate := d.onMediaUpdate
-		d.mu.Unlock()
-		if err != nil {
-			return err
-		}
+func (d *DialogClientSession) handleUpdate(req *sip.Request, tx sip.ServerTransaction) error {
+	if err := d.ReadRequest(req, tx); err != nil {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - "+err.Error(), nil))
+	}
 
-		if onMediaUpdate != nil {
-			onMediaUpdate(d.Media())
-		}
+	d.mu.Lock()
+	st := d.sessionTimer
+	d.mu.Unlock()
+
+	var headers []sip.Header
+	if st != nil {
+		headers = st.handleRefresh(req)
+	}
+	return d.DialogMedia.handleUpdate(req, tx, d.InviteRequest.Contact(), headers...)
+}
+
+// negotiateSessionTimer applies session timer from 2xx response.
+// If remote does not support session timer we stay refresher with requested interval.
+func (d *DialogClientSession) negotiateSessionTimer(res *sip.Response) {
+	opts := d.sessionTimerOpts
+	if !opts.enabled() {
+		return
 	}
 
-	return d.mediaSession.Finalize()
+	interval, refresher := opts.interval(), true
+	if se, ok := sessionExpiresFromMessage(res); ok {
+		interval = se.interval
+		refresher = se.refresher != refresherUAS
+	}
+
+	d.mu.Lock()
+	d.sessionTimer = newSessionTimer(interval, refresher)
+	d.mu.Unlock()
+}
+
+func (d *DialogClientSession) startSessionTimer() {
+	d.mu.Lock()
+	st := d.sessionTimer
+	d.mu.Unlock()
+	if st == nil {
+		return
+	}
+	go st.run(d.Context(), d.refreshSession, d.sessionExpired)
+}
+
+// refreshSession sends session refresh with re-INVITE
+func (d *DialogClientSession) refreshSession(ctx context.Context, se sessionExpires) (*sip.Response, error) {
+	d.mu.Lock()
+	contact := d.remoteContactUnsafe()
+	sdp := d.mediaSession.LocalSDP()
+	d.mu.Unlock()
+
+	req := sip.NewRequest(sip.INVITE, contact.Address)
+	req.AppendHeader(d.InviteRequest.Contact())
+	req.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+	req.AppendHeader(sip.NewHeader("Supported", optionTagTimer))
+	req.AppendHeader(sip.NewHeader("Session-Expires", se.String()))
+	req.SetBody(sdp)
+	return d.reInviteDo(ctx, req)
+}
+
+// sessionExpired terminates dialog when session is not refreshed
+func (d *DialogClientSession) sessionExpired() {
+	ctx, cancel := context.WithTimeout(context.Background(), sip.Timer_F)
+	defer cancel()
+	if err := d.Hangup(ctx); err != nil {
+		media.DefaultLogger().Info("Hangup on session expiry failed", "error", err, "id", d.ID)
+	}
+	if d.cancel != nil {
+		d.cancel(ErrSessionTimerExpired)
+	}
 }
 
 func (d *DialogClientSession) readSIPInfoDTMF(req *sip.Request, tx sip.ServerTransaction) error {
-	return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusNotAcceptable, "Not Acceptable", nil))
+	return d.handleSIPInfoDTMF(req, tx)
+}
+
+// writeInfoDTMF sends DTMF as SIP INFO. Use DTMFWriter with DTMFModeSIPInfo
+func (d *DialogClientSession) writeInfoDTMF(dtmf rune, dur time.Duration) error {
+	d.mu.Lock()
+	contact := d.remoteContactUnsafe()
+	d.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(d.Context(), sip.Timer_F)
+	defer cancel()
+	return dialogWriteInfoDTMF(ctx, d, contact.Address, dtmf, dur)
 }
 
 func (d *DialogClientSession) Hold(ctx context.Context) error {
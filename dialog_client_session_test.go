@@ -475,6 +475,103 @@ func TestIntegrationDialogClientReinviteMedia(t *testing.T) {
 	assert.GreaterOrEqual(t, len(remoteAudio)/160, numPkts-1)
 }
 
+func TestIntegrationDialogClientUpdate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	earlyUpdated := make(chan struct{})
+	answered := make(chan error, 1)
+	serverUpdated := make(chan error, 1)
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("server"))
+		defer ua.Close()
+
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15104,
+			},
+		))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			if err := d.ProgressMedia(); err != nil {
+				answered <- err
+				return
+			}
+			earlyMedia := d.MediaSession()
+
+			select {
+			case <-earlyUpdated:
+			case <-d.Context().Done():
+				return
+			}
+			if d.MediaSession() == earlyMedia {
+				answered <- fmt.Errorf("early UPDATE did not update media")
+				return
+			}
+
+			if err := d.Answer(); err != nil {
+				answered <- err
+				return
+			}
+			answered <- nil
+
+			serverUpdated <- d.Update(d.Context())
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	dialog, err := dg.NewDialog(sip.Uri{User: "dialer", Host: "127.0.0.1", Port: 15104}, NewDialogOptions{})
+	require.NoError(t, err)
+	defer dialog.Close()
+
+	mediaUpdated := make(chan struct{}, 1)
+	err = dialog.Invite(ctx, InviteClientOptions{
+		EarlyMediaDetect: true,
+		OnMediaUpdate: func(d *DialogMedia) {
+			mediaUpdated <- struct{}{}
+		},
+	})
+	require.ErrorIs(t, err, ErrClientEarlyMedia)
+
+	// UPDATE in early dialog
+	require.NoError(t, dialog.Update(ctx))
+	close(earlyUpdated)
+
+	require.NoError(t, dialog.WaitAnswer(ctx, sipgo.AnswerOptions{}))
+	require.NoError(t, dialog.Ack(ctx))
+
+	// ACK must match INVITE after early UPDATE
+	select {
+	case err := <-answered:
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("ACK not accepted")
+	}
+
+	// UPDATE in confirmed dialog from server
+	select {
+	case err := <-serverUpdated:
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("UPDATE not answered")
+	}
+	select {
+	case <-mediaUpdated:
+	default:
+		t.Fatal("media update not called")
+	}
+
+	require.NoError(t, dialog.Hangup(ctx))
+}
+
 func TestDialogClientInviteFailed(t *testing.T) {
 	reqCh := make(chan *sip.Request)
 	dg := testDiagoClient(t, func(req *sip.Request) *sip.Response {
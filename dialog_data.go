@@ -0,0 +1,563 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/emiago/diago/media"
+	"github.com/emiago/diago/media/sdp"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+)
+
+// DialogData is serializable state of answered dialog.
+// It allows restarted or standby process to restore dialog with Diago.RestoreDialogs
+type DialogData struct {
+	ID string
+	// Server is true for dialog created by incoming INVITE
+	Server bool
+	State  sip.DialogState
+
+	// InviteRequest and InviteResponse are raw SIP messages
+	InviteRequest  []byte
+	InviteResponse []byte
+	Transport      string
+	RequestSource  string
+	ResponseSource string
+
+	CallID    string
+	LocalTag  string
+	RemoteTag string
+	// CSeq is last local CSeq number used within dialog
+	CSeq uint32
+	// RemoteCSeq is last CSeq number received within dialog
+	RemoteCSeq uint32
+	// RouteSet is Record-Route set of dialog
+	RouteSet []string
+	// RemoteTarget is current remote contact, which re-INVITE can change
+	RemoteTarget string
+
+	// Media is nil when dialog has no media session
+	Media *DialogMediaData
+}
+
+// DialogMediaData is negotiated media session of dialog
+type DialogMediaData struct {
+	LocalAddr  net.UDPAddr
+	RemoteAddr net.UDPAddr
+	ExternalIP net.IP
+	Codecs     []media.Codec
+	Mode       string
+	RTPNAT     int
+}
+
+// DialogDataStore persists dialogs. Diago saves dialog when it is confirmed and deletes it when it ends
+type DialogDataStore interface {
+	DialogDataSave(ctx context.Context, data DialogData) error
+	DialogDataDelete(ctx context.Context, id string) error
+	DialogDataRange(ctx context.Context, f func(data DialogData) bool) error
+}
+
+// WithDialogDataStore persists answered dialogs into store
+func WithDialogDataStore(s DialogDataStore) DiagoOption {
+	return func(dg *Diago) {
+		dg.dialogStore = s
+	}
+}
+
+// DialogData returns serializable state of dialog. Dialog must be answered
+func (d *DialogServerSession) DialogData() (DialogData, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	req, res := d.InviteRequest, d.InviteResponse
+	if res == nil || !res.IsSuccess() {
+		return DialogData{}, fmt.Errorf("dialog is not answered")
+	}
+	data := newDialogData(&d.Dialog, d.remoteContactUnsafe())
+	data.Server = true
+	data.RemoteCSeq = d.remoteCSeqUnsafe()
+	data.LocalTag, _ = req.To().Params.Get("tag")
+	data.RemoteTag, _ = req.From().Params.Get("tag")
+	data.RouteSet = dialogRouteSet(req.GetHeaders("Record-Route"))
+
+	var err error
+	data.Media, err = d.mediaDataUnsafe()
+	return data, err
+}
+
+// DialogData returns serializable state of dialog. Dialog must be answered
+func (d *DialogClientSession) DialogData() (DialogData, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	req, res := d.InviteRequest, d.InviteResponse
+	if res == nil || !res.IsSuccess() {
+		return DialogData{}, fmt.Errorf("dialog is not answered")
+	}
+	data := newDialogData(&d.Dialog, d.remoteContactUnsafe())
+	data.RemoteCSeq = d.remoteCSeq
+	data.LocalTag, _ = req.From().Params.Get("tag")
+	data.RemoteTag, _ = res.To().Params.Get("tag")
+	data.RouteSet = dialogRouteSet(res.GetHeaders("Record-Route"))
+
+	var err error
+	data.Media, err = d.mediaDataUnsafe()
+	return data, err
+}
+
+func newDialogData(d *sipgo.Dialog, remoteTarget *sip.ContactHeader) DialogData {
+	req, res := d.InviteRequest, d.InviteResponse
+	data := DialogData{
+		ID:             d.ID,
+		State:          d.LoadState(),
+		InviteRequest:  []byte(req.String()),
+		InviteResponse: []byte(res.String()),
+		Transport:      req.Transport(),
+		RequestSource:  req.Source(),
+		ResponseSource: res.Source(),
+		CallID:         req.CallID().Value(),
+		CSeq:           d.CSEQ(),
+	}
+	if remoteTarget != nil {
+		data.RemoteTarget = remoteTarget.Value()
+	}
+	return data
+}
+
+func dialogRouteSet(hdrs []sip.Header) []string {
+	routes := make([]string, 0, len(hdrs))
+	for _, h := range hdrs {
+		routes = append(routes, h.Value())
+	}
+	return routes
+}
+
+func (d *DialogMedia) mediaDataUnsafe() (*DialogMediaData, error) {
+	sess := d.mediaSession
+	if sess == nil {
+		return nil, nil
+	}
+	if sess.SecureRTP > 0 {
+		return nil, fmt.Errorf("secure RTP media session can not be persisted")
+	}
+
+	codecs := sess.CommonCodecs()
+	if len(codecs) == 0 {
+		codecs = sess.Codecs
+	}
+	return &DialogMediaData{
+		LocalAddr:  sess.Laddr,
+		RemoteAddr: sess.Raddr,
+		ExternalIP: sess.ExternalIP,
+		Codecs:     slices.Clone(codecs),
+		Mode:       sess.Mode,
+		RTPNAT:     sess.RTPNAT,
+	}, nil
+}
+
+// restoreMedia recreates media session on same local address.
+// Remote SDP is rebuilt from negotiated state so that codecs and remote address are applied same as on answer
+func (d *DialogMedia) restoreMedia(conf MediaConfig, data *DialogMediaData) error {
+	sess := &media.MediaSession{
+		Codecs:     data.Codecs,
+		Laddr:      data.LocalAddr,
+		ExternalIP: data.ExternalIP,
+		Mode:       data.Mode,
+		RTPNAT:     data.RTPNAT,
+	}
+	if sess.Mode == "" {
+		sess.Mode = sdp.ModeSendrecv
+	}
+
+	if !conf.sessions.acquire() {
+		return ErrMediaSessionLimit
+	}
+	if err := sess.Init(); err != nil {
+		conf.sessions.release()
+		return err
+	}
+	if conf.sessions != nil {
+		d.OnClose(func() error {
+			conf.sessions.release()
+			return nil
+		})
+	}
+
+	remote := &media.MediaSession{
+		Codecs: data.Codecs,
+		Laddr:  data.RemoteAddr,
+		Mode:   sdp.ModeSendrecv,
+	}
+	if err := sess.RemoteSDP(remote.LocalSDP()); err != nil {
+		return errors.Join(err, sess.Close())
+	}
+
+	rtpSess := media.NewRTPSession(sess)
+	d.mu.Lock()
+	d.initRTPSessionUnsafe(sess, rtpSess)
+	d.mu.Unlock()
+	return rtpSess.MonitorBackground()
+}
+
+// RestoreDialogs restores dialogs saved in dialog data store.
+// Restored dialogs handle in dialog requests like BYE, re-INVITE and INFO, and can be hanguped.
+// Dialogs that fail to restore are removed from store and returned as error
+func (dg *Diago) RestoreDialogs(ctx context.Context) ([]DialogSession, error) {
+	if dg.dialogStore == nil {
+		return nil, fmt.Errorf("dialog data store is not set")
+	}
+
+	var datas []DialogData
+	if err := dg.dialogStore.DialogDataRange(ctx, func(data DialogData) bool {
+		datas = append(datas, data)
+		return true
+	}); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	dialogs := make([]DialogSession, 0, len(datas))
+	for _, data := range datas {
+		var d DialogSession
+		var err error
+		if data.Server {
+			d, err = dg.restoreServerDialog(data)
+		} else {
+			d, err = dg.restoreClientDialog(data)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore dialog id=%s: %w", data.ID, err))
+			errs = append(errs, dg.dialogStore.DialogDataDelete(ctx, data.ID))
+			continue
+		}
+		dialogs = append(dialogs, d)
+	}
+	return dialogs, errors.Join(errs...)
+}
+
+func (dg *Diago) restoreServerDialog(data DialogData) (*DialogServerSession, error) {
+	req, res, tran, err := dg.parseDialogData(data)
+	if err != nil {
+		return nil, err
+	}
+	dialogUA := &sipgo.DialogUA{
+		Client:         dg.getClient(tran),
+		RewriteContact: tran.RewriteContact,
+	}
+	dg.contactHDRFromTransport(tran, &dialogUA.ContactHDR)
+
+	// Transaction of INVITE is long gone, but dialog is created same way as for new INVITE.
+	// Transaction is terminated once dialog is confirmed
+	key, err := sip.ServerTxKeyMake(req)
+	if err != nil {
+		return nil, err
+	}
+	tx := sip.NewServerTx(key, req, nil, dg.log)
+	dialog, err := dialogUA.ReadInvite(req, tx)
+	if err != nil {
+		return nil, err
+	}
+	dialog.ID = data.ID
+	dialog.InviteRequest.To().Params.Add("tag", data.LocalTag)
+	dialog.InviteResponse = res
+	dialogInitCSeq(&dialog.Dialog, data.CSeq)
+	tx.Terminate()
+
+	d := &DialogServerSession{
+		DialogServerSession: dialog,
+		mediaConf: MediaConfig{
+			Codecs:     dg.mediaConf.Codecs,
+			secureRTP:  tran.MediaSRTP,
+			bindIP:     tran.mediaBindIP,
+			externalIP: tran.MediaExternalIP,
+			dtlsConf:   tran.MediaDTLSConf,
+			sessions:   dg.mediaSessionLimit(),
+		},
+		rel100:     dg.rel100,
+		remoteCSeq: data.RemoteCSeq,
+	}
+	d.ctx, d.cancel = context.WithCancelCause(dialog.Context())
+	d.infoDTMFWriter = d.writeInfoDTMF
+	d.metrics = dg.metrics
+
+	if err := d.restoreDialogMedia(d.mediaConf, data); err != nil {
+		return nil, err
+	}
+
+	if err := dg.cache.server.DialogStore(context.Background(), d.ID, d); err != nil {
+		return nil, errors.Join(err, d.Close())
+	}
+	d.OnState(func(s sip.DialogState) {
+		if s != sip.DialogStateEnded {
+			return
+		}
+		if err := dg.cache.server.DialogDelete(context.Background(), d.ID); err != nil {
+			dg.log.Error("Failed to delete server dialog", "error", err)
+		}
+		closeAndLog(d, "closing restored dialog returned error")
+	})
+	dg.trackDialogData(d)
+	dg.dialogEvents.trackServer(d)
+	dg.trackServerMetrics(d)
+	return d, nil
+}
+
+func (dg *Diago) restoreClientDialog(data DialogData) (*DialogClientSession, error) {
+	req, res, tran, err := dg.parseDialogData(data)
+	if err != nil {
+		return nil, err
+	}
+	dialogUA, requester := newDialogClientUA(dg.getClient(tran), tran.RewriteContact)
+	dg.contactHDRFromTransport(tran, &dialogUA.ContactHDR)
+
+	d := &DialogClientSession{
+		DialogClientSession: &sipgo.DialogClientSession{
+			UA: dialogUA,
+			Dialog: sipgo.Dialog{
+				ID:            data.ID,
+				InviteRequest: req,
+			},
+		},
+		requester: requester,
+		mediaConfig: MediaConfig{
+			Codecs:     dg.mediaConf.Codecs,
+			secureRTP:  tran.MediaSRTP,
+			bindIP:     tran.mediaBindIP,
+			externalIP: tran.MediaExternalIP,
+			dtlsConf:   tran.MediaDTLSConf,
+			sessions:   dg.mediaSessionLimit(),
+		},
+		rel100:     dg.rel100,
+		remoteCSeq: data.RemoteCSeq,
+	}
+	// INVITE is passed to requester only to create its terminated transaction, which is needed for BYE
+	if cont := req.Contact(); cont == nil || cont.Address.Port == 0 {
+		return nil, fmt.Errorf("invalid contact in dialog INVITE")
+	}
+	d.Init()
+	requester.restored.Store(true)
+	if err := d.DialogClientSession.Invite(context.Background(), func(c *sipgo.Client, req *sip.Request) error { return nil }); err != nil {
+		return nil, err
+	}
+	d.InviteResponse = res
+	dialogInitCSeq(&d.Dialog, data.CSeq)
+	d.ctx, d.cancel = context.WithCancelCause(d.DialogClientSession.Context())
+	d.infoDTMFWriter = d.writeInfoDTMF
+	d.metrics = dg.metrics
+
+	if err := d.restoreDialogMedia(d.mediaConfig, data); err != nil {
+		return nil, err
+	}
+
+	if err := dg.cache.client.DialogStore(context.Background(), d.ID, d); err != nil {
+		return nil, errors.Join(err, d.Close())
+	}
+	d.OnClose(func() error {
+		return dg.cache.client.DialogDelete(context.Background(), d.ID)
+	})
+	d.OnState(func(s sip.DialogState) {
+		if s == sip.DialogStateEnded {
+			closeAndLog(d, "closing restored dialog returned error")
+		}
+	})
+	dg.trackDialogData(d)
+	dg.dialogEvents.trackClient(d)
+	dg.trackClientMetrics(d, tran.Transport)
+	return d, nil
+}
+
+func (dg *Diago) parseDialogData(data DialogData) (*sip.Request, *sip.Response, *Transport, error) {
+	msg, err := sip.ParseMessage(data.InviteRequest)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse INVITE request: %w", err)
+	}
+	req, ok := msg.(*sip.Request)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("INVITE request is not request")
+	}
+
+	msg, err = sip.ParseMessage(data.InviteResponse)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse INVITE response: %w", err)
+	}
+	res, ok := msg.(*sip.Response)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("INVITE response is not response")
+	}
+
+	tran, exists := dg.getTransport(data.Transport)
+	if !exists {
+		return nil, nil, nil, fmt.Errorf("transport %s does not exists", data.Transport)
+	}
+	req.SetTransport(data.Transport)
+	req.SetSource(data.RequestSource)
+	res.SetSource(data.ResponseSource)
+	return req, res, tran, nil
+}
+
+// restoreDialogMedia restores remote target and media session of dialog
+func (d *DialogMedia) restoreDialogMedia(conf MediaConfig, data DialogData) error {
+	if data.RemoteTarget != "" {
+		cont := &sip.ContactHeader{}
+		if _, err := sip.ParseAddressValue(data.RemoteTarget, &cont.Address, &cont.Params); err != nil {
+			return fmt.Errorf("failed to parse remote target: %w", err)
+		}
+		d.mu.Lock()
+		d.remoteContactTarget = cont
+		d.mu.Unlock()
+	}
+
+	if data.Media == nil {
+		return nil
+	}
+	return d.restoreMedia(conf, data.Media)
+}
+
+// SaveDialog saves current state of dialog into dialog data store.
+// Dialog is saved when confirmed and after received re-INVITE. Call this after other changes,
+// like sending requests within dialog, to keep stored CSeq up to date
+func (dg *Diago) SaveDialog(ctx context.Context, d DialogSession) error {
+	if dg.dialogStore == nil {
+		return fmt.Errorf("dialog data store is not set")
+	}
+
+	var data DialogData
+	var err error
+	switch d := d.(type) {
+	case *DialogServerSession:
+		data, err = d.DialogData()
+	case *DialogClientSession:
+		data, err = d.DialogData()
+	default:
+		return fmt.Errorf("unsupported dialog session %T", d)
+	}
+	if err != nil {
+		return err
+	}
+	return dg.dialogStore.DialogDataSave(ctx, data)
+}
+
+func (dg *Diago) saveDialogData(d DialogSession) {
+	if dg.dialogStore == nil {
+		return
+	}
+	if err := dg.SaveDialog(context.Background(), d); err != nil {
+		dg.log.Error("Failed to save dialog data", "error", err, "id", d.Id())
+	}
+}
+
+// trackDialogData saves dialog in dialog data store once confirmed and deletes it when ended
+func (dg *Diago) trackDialogData(d DialogSession) {
+	if dg.dialogStore == nil {
+		return
+	}
+
+	if d.DialogSIP().LoadState() == sip.DialogStateConfirmed {
+		dg.saveDialogData(d)
+	}
+	d.DialogSIP().OnState(func(s sip.DialogState) {
+		switch s {
+		case sip.DialogStateConfirmed:
+			dg.saveDialogData(d)
+		case sip.DialogStateEnded:
+			if err := dg.dialogStore.DialogDataDelete(context.Background(), d.Id()); err != nil {
+				dg.log.Error("Failed to delete dialog data", "error", err, "id", d.Id())
+			}
+		}
+	})
+}
+
+// dialogInitCSeq initializes restored dialog in confirmed state with local CSeq.
+// sipgo initializes local CSeq from INVITE, so Init runs with cloned INVITE carrying restored CSeq
+func dialogInitCSeq(d *sipgo.Dialog, cseq uint32) {
+	invite := d.InviteRequest
+	d.InviteRequest = invite.Clone()
+	d.InviteRequest.CSeq().SeqNo = max(cseq, invite.CSeq().SeqNo)
+	d.InitWithState(sip.DialogStateConfirmed)
+	d.InviteRequest = invite
+}
+
+// DialogFileStore is DialogDataStore keeping each dialog as JSON file in directory
+type DialogFileStore struct {
+	dir string
+}
+
+// NewDialogFileStore creates file store in dir. Directory is created if it does not exist
+func NewDialogFileStore(dir string) (*DialogFileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &DialogFileStore{dir: dir}, nil
+}
+
+func (s *DialogFileStore) path(id string) string {
+	return filepath.Join(s.dir, base64.RawURLEncoding.EncodeToString([]byte(id))+".json")
+}
+
+// DialogDataSave writes dialog atomically, so that crash never leaves partial file
+func (s *DialogFileStore) DialogDataSave(ctx context.Context, data DialogData) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(s.dir, ".dialog-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), s.path(data.ID))
+}
+
+func (s *DialogFileStore) DialogDataDelete(ctx context.Context, id string) error {
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *DialogFileStore) DialogDataRange(ctx context.Context, f func(data DialogData) bool) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		var data DialogData
+		if err := json.Unmarshal(b, &data); err != nil {
+			return fmt.Errorf("failed to read dialog file %s: %w", e.Name(), err)
+		}
+		if !f(data) {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emiago/diago/media"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func dialogFileStoreLen(t *testing.T, s *DialogFileStore) int {
+	n := 0
+	require.NoError(t, s.DialogDataRange(context.Background(), func(data DialogData) bool {
+		n++
+		return true
+	}))
+	return n
+}
+
+func TestDialogFileStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewDialogFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	data := DialogData{
+		ID:        "callid__from/tag__to/tag",
+		Server:    true,
+		State:     sip.DialogStateConfirmed,
+		CSeq:      3,
+		RouteSet:  []string{"<sip:proxy.example.com;lr>"},
+		Transport: "UDP",
+		Media: &DialogMediaData{
+			LocalAddr:  net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10000},
+			RemoteAddr: net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 20000},
+			Codecs:     []media.Codec{media.CodecAudioAlaw},
+		},
+	}
+	require.NoError(t, store.DialogDataSave(ctx, data))
+	data.CSeq = 4
+	require.NoError(t, store.DialogDataSave(ctx, data))
+
+	var loaded []DialogData
+	require.NoError(t, store.DialogDataRange(ctx, func(data DialogData) bool {
+		loaded = append(loaded, data)
+		return true
+	}))
+	require.Len(t, loaded, 1)
+	assert.Equal(t, uint32(4), loaded[0].CSeq)
+	assert.Equal(t, data.RouteSet, loaded[0].RouteSet)
+	assert.Equal(t, data.Media.Codecs, loaded[0].Media.Codecs)
+	assert.True(t, data.Media.RemoteAddr.IP.Equal(loaded[0].Media.RemoteAddr.IP))
+
+	require.NoError(t, store.DialogDataDelete(ctx, data.ID))
+	require.NoError(t, store.DialogDataDelete(ctx, data.ID))
+	assert.Equal(t, 0, dialogFileStoreLen(t, store))
+}
+
+func TestIntegrationDialogRestore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := NewDialogFileStore(t.TempDir())
+	require.NoError(t, err)
+	transport := Transport{
+		Transport: "udp",
+		BindHost:  "127.0.0.1",
+		BindPort:  15124,
+	}
+
+	// Peer answers calls and keeps them until hangup
+	peerUA, _ := sipgo.NewUA()
+	defer peerUA.Close()
+	peer := NewDiago(peerUA, WithTransport(
+		Transport{
+			Transport: "udp",
+			BindHost:  "127.0.0.1",
+			BindPort:  15125,
+		},
+	))
+	peer.HandleMessage(func(m *MessageRequest) {})
+	peerDialogs := make(chan *DialogServerSession, 1)
+	err = peer.ServeBackground(ctx, func(d *DialogServerSession) {
+		if err := d.Answer(); err != nil {
+			return
+		}
+		peerDialogs <- d
+		<-d.Context().Done()
+	})
+	require.NoError(t, err)
+
+	// First process has incoming and outgoing call, and stops without hanguping them
+	ua, _ := sipgo.NewUA()
+	dg := NewDiago(ua, WithTransport(transport), WithDialogDataStore(store))
+	dg.HandleMessage(func(m *MessageRequest) {})
+	serverDialogs := make(chan *DialogServerSession, 1)
+	dgCtx, dgCancel := context.WithCancel(ctx)
+	err = dg.ServeBackground(dgCtx, func(d *DialogServerSession) {
+		if err := d.Answer(); err != nil {
+			return
+		}
+		serverDialogs <- d
+		<-ctx.Done()
+	})
+	require.NoError(t, err)
+
+	inDialog, err := peer.Invite(ctx, sip.Uri{User: "bob", Host: "127.0.0.1", Port: 15124}, InviteOptions{})
+	require.NoError(t, err)
+	defer inDialog.Close()
+	serverDialog := <-serverDialogs
+
+	outDialog, err := dg.Invite(ctx, sip.Uri{User: "alice", Host: "127.0.0.1", Port: 15125}, InviteOptions{})
+	require.NoError(t, err)
+	peerDialog := <-peerDialogs
+
+	// Requests within dialog advance CSeq, which is saved explicitly
+	require.NoError(t, outDialog.Message(ctx, "text/plain", []byte("hello")))
+	require.NoError(t, peerDialog.Message(ctx, "text/plain", []byte("hello")))
+	require.NoError(t, dg.SaveDialog(ctx, outDialog))
+	// Local CSeq of incoming call gets ahead of remote CSeq
+	for range 2 {
+		require.NoError(t, serverDialog.Message(ctx, "text/plain", []byte("hello")))
+	}
+	require.NoError(t, dg.SaveDialog(ctx, serverDialog))
+
+	require.Eventually(t, func() bool {
+		return dialogFileStoreLen(t, store) == 2
+	}, time.Second, 50*time.Millisecond)
+
+	require.NoError(t, serverDialog.DialogMedia.Close())
+	require.NoError(t, outDialog.DialogMedia.Close())
+	dgCancel()
+	ua.Close()
+
+	// Second process restores dialogs on same transport
+	ua2, _ := sipgo.NewUA()
+	defer ua2.Close()
+	dg2 := NewDiago(ua2, WithTransport(transport), WithDialogDataStore(store))
+	dg2.HandleMessage(func(m *MessageRequest) {})
+	// Wait listener of first process to be closed
+	require.Eventually(t, func() bool {
+		return dg2.ServeBackground(ctx, func(d *DialogServerSession) {}) == nil
+	}, time.Second, 50*time.Millisecond)
+
+	dialogs, err := dg2.RestoreDialogs(ctx)
+	require.NoError(t, err)
+	require.Len(t, dialogs, 2)
+
+	var restoredServer *DialogServerSession
+	var restoredClient *DialogClientSession
+	for _, d := range dialogs {
+		switch d := d.(type) {
+		case *DialogServerSession:
+			restoredServer = d
+		case *DialogClientSession:
+			restoredClient = d
+		}
+	}
+	require.NotNil(t, restoredServer)
+	require.NotNil(t, restoredClient)
+	assert.Equal(t, serverDialog.ID, restoredServer.ID)
+	assert.Equal(t, outDialog.ID, restoredClient.ID)
+	assert.Equal(t, outDialog.CSEQ(), restoredClient.CSEQ())
+	assert.Equal(t, serverDialog.CSEQ(), restoredServer.CSEQ())
+	assert.Equal(t, peerDialog.CSEQ(), restoredClient.remoteCSeq)
+	assert.Equal(t, outDialog.mediaSession.Laddr.Port, restoredClient.mediaSession.Laddr.Port)
+	assert.Equal(t, outDialog.mediaSession.Raddr.Port, restoredClient.mediaSession.Raddr.Port)
+
+	// Restored dialogs handle requests within dialog
+	require.NoError(t, inDialog.Message(ctx, "text/plain", []byte("hello")))
+	require.NoError(t, inDialog.Hangup(ctx))
+	select {
+	case <-restoredServer.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("restored dialog not terminated with BYE")
+	}
+
+	// Restored dialogs can be hanguped
+	require.NoError(t, restoredClient.Hangup(ctx))
+	select {
+	case <-peerDialog.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("peer dialog not terminated with BYE")
+	}
+
+	require.Eventually(t, func() bool {
+		return dialogFileStoreLen(t, store) == 0
+	}, time.Second, 50*time.Millisecond)
+}
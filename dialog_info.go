@@ -0,0 +1,325 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"encoding/xml"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+const (
+	dialogInfoContentType = "application/dialog-info+xml"
+
+	// Dialog states https://datatracker.ietf.org/doc/html/rfc4235#section-3.7.1
+	dialogInfoStateTrying     = "trying"
+	dialogInfoStateEarly      = "early"
+	dialogInfoStateConfirmed  = "confirmed"
+	dialogInfoStateTerminated = "terminated"
+)
+
+// dialogInfo is application/dialog-info+xml document (RFC 4235)
+type dialogInfo struct {
+	XMLName xml.Name           `xml:"urn:ietf:params:xml:ns:dialog-info dialog-info"`
+	Version int                `xml:"version,attr"`
+	State   string             `xml:"state,attr"`
+	Entity  string             `xml:"entity,attr"`
+	Dialogs []dialogInfoDialog `xml:"dialog"`
+}
+
+type dialogInfoDialog struct {
+	ID        string                 `xml:"id,attr"`
+	CallID    string                 `xml:"call-id,attr,omitempty"`
+	LocalTag  string                 `xml:"local-tag,attr,omitempty"`
+	RemoteTag string                 `xml:"remote-tag,attr,omitempty"`
+	Direction string                 `xml:"direction,attr,omitempty"`
+	State     string                 `xml:"state"`
+	Local     *dialogInfoParticipant `xml:"local,omitempty"`
+	Remote    *dialogInfoParticipant `xml:"remote,omitempty"`
+}
+
+type dialogInfoParticipant struct {
+	Identity string `xml:"identity"`
+}
+
+// dialogInfoEntry is tracked dialog state
+type dialogInfoEntry struct {
+	id        string
+	callID    string
+	localTag  string
+	remoteTag string
+	from      sip.Uri
+	to        sip.Uri
+	state     string
+}
+
+// dialogInfo returns dialog as seen by user. User that is in From header initiated dialog
+func (e dialogInfoEntry) dialogInfo(user string) dialogInfoDialog {
+	local, remote, direction := e.to, e.from, "recipient"
+	if e.from.User == user {
+		local, remote, direction = e.from, e.to, "initiator"
+	}
+	return dialogInfoDialog{
+		ID:        e.id,
+		CallID:    e.callID,
+		LocalTag:  e.localTag,
+		RemoteTag: e.remoteTag,
+		Direction: direction,
+		State:     e.state,
+		Local:     &dialogInfoParticipant{Identity: local.String()},
+		Remote:    &dialogInfoParticipant{Identity: remote.String()},
+	}
+}
+
+func newDialogInfoEntry(req *sip.Request, localTag string, remoteTag string, state string) dialogInfoEntry {
+	callID := req.CallID().Value()
+	return dialogInfoEntry{
+		id:        callID + ";" + localTag,
+		callID:    callID,
+		localTag:  localTag,
+		remoteTag: remoteTag,
+		from:      sip.Uri{Scheme: req.From().Address.Scheme, User: req.From().Address.User, Host: req.From().Address.Host},
+		to:        sip.Uri{Scheme: req.To().Address.Scheme, User: req.To().Address.User, Host: req.To().Address.Host},
+		state:     state,
+	}
+}
+
+// dialogEventPackage implements dialog event package (RFC 4235).
+// It tracks state of dialogs and notifies subscribers of users that are part of dialog.
+type dialogEventPackage struct {
+	enabled   atomic.Bool
+	authorize func(s *SubscriptionServer, user string) bool
+
+	mu      sync.Mutex
+	dialogs map[string]dialogInfoEntry
+	subs    map[*dialogInfoSubscription]struct{}
+}
+
+type dialogInfoSubscription struct {
+	user    string
+	changed chan struct{}
+}
+
+// DialogEventsOptions are options for HandleDialogEvents
+type DialogEventsOptions struct {
+	// Authorize decides can subscriber watch dialogs of user. Unauthorized subscription is rejected with 403.
+	// Dialogs reveal who is calling whom, so without Authorize every subscription is rejected.
+	Authorize func(s *SubscriptionServer, user string) bool
+}
+
+// HandleDialogEvents enables dialog event package (RFC 4235), used by phones for busy lamp field (BLF).
+// Subscriber of user, which is user part of SUBSCRIBE Request-URI, is notified with application/dialog-info+xml
+// every time dialog with this user in From or To header goes trying, early, confirmed or terminated.
+//
+// Only dialogs created after calling this are tracked, so it should be called before serving.
+func (dg *Diago) HandleDialogEvents(opts DialogEventsOptions) {
+	dg.dialogEvents.enabled.Store(true)
+	dg.dialogEvents.authorize = opts.Authorize
+	dg.HandleSubscribe("dialog", dg.dialogEvents.serveSubscription)
+}
+
+func (p *dialogEventPackage) serveSubscription(s *SubscriptionServer) {
+	user := s.SubscribeRequest.Recipient.User
+	if user == "" {
+		s.Reject(sip.StatusNotFound, "Not Found")
+		return
+	}
+
+	if p.authorize == nil || !p.authorize(s, user) {
+		s.Reject(sip.StatusForbidden, "Forbidden")
+		return
+	}
+
+	if err := s.Accept(0); err != nil {
+		return
+	}
+
+	sub := &dialogInfoSubscription{user: user, changed: make(chan struct{}, 1)}
+	p.mu.Lock()
+	if p.subs == nil {
+		p.subs = make(map[*dialogInfoSubscription]struct{})
+	}
+	p.subs[sub] = struct{}{}
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.subs, sub)
+		p.mu.Unlock()
+	}()
+
+	recipient := s.SubscribeRequest.Recipient
+	entity := sip.Uri{Scheme: recipient.Scheme, User: user, Host: recipient.Host}
+	// Every NOTIFY has full state. Changes happening in between are coalesced
+	for version := 0; ; version++ {
+		body, err := xml.Marshal(p.dialogInfo(user, entity.String(), version))
+		if err != nil {
+			return
+		}
+
+		if err := s.Notify(s.Context(), dialogInfoContentType, append([]byte(xml.Header), body...)); err != nil {
+			return
+		}
+
+		select {
+		case <-s.Context().Done():
+			return
+		case <-sub.changed:
+		}
+	}
+}
+
+func (p *dialogEventPackage) dialogInfo(user string, entity string, version int) dialogInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info := dialogInfo{
+		Version: version,
+		State:   "full",
+		Entity:  entity,
+		Dialogs: []dialogInfoDialog{},
+	}
+	for _, e := range p.dialogs {
+		if e.from.User == user || e.to.User == user {
+			info.Dialogs = append(info.Dialogs, e.dialogInfo(user))
+		}
+	}
+	slices.SortFunc(info.Dialogs, func(a, b dialogInfoDialog) int {
+		if a.ID < b.ID {
+			return -1
+		}
+		if a.ID > b.ID {
+			return 1
+		}
+		return 0
+	})
+	return info
+}
+
+// update stores new dialog state and notifies subscribers. Terminated dialog is removed.
+func (p *dialogEventPackage) update(e dialogInfoEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old, exists := p.dialogs[e.id]
+	switch {
+	case !exists && e.state == dialogInfoStateTerminated:
+		return
+	case exists && old.state == e.state:
+		return
+	case exists && old.state == dialogInfoStateConfirmed && e.state != dialogInfoStateTerminated:
+		// Late provisional response
+		return
+	}
+
+	if e.remoteTag == "" {
+		e.remoteTag = old.remoteTag
+	}
+
+	if e.state == dialogInfoStateTerminated {
+		delete(p.dialogs, e.id)
+	} else {
+		if p.dialogs == nil {
+			p.dialogs = make(map[string]dialogInfoEntry)
+		}
+		p.dialogs[e.id] = e
+	}
+
+	for sub := range p.subs {
+		if sub.user != e.from.User && sub.user != e.to.User {
+			continue
+		}
+		select {
+		case sub.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// trackServer tracks state of incoming dialog
+func (p *dialogEventPackage) trackServer(d *DialogServerSession) {
+	if !p.enabled.Load() {
+		return
+	}
+
+	req := d.InviteRequest
+	localTag, _ := req.To().Params.Get("tag")
+	remoteTag, _ := req.From().Params.Get("tag")
+	update := func(state string) {
+		p.update(newDialogInfoEntry(req, localTag, remoteTag, state))
+	}
+
+	update(dialogInfoStateTrying)
+	d.onProvisional = func(res *sip.Response) {
+		if res.StatusCode > sip.StatusTrying {
+			update(dialogInfoStateEarly)
+		}
+	}
+	d.OnState(func(s sip.DialogState) {
+		switch s {
+		case sip.DialogStateEstablished:
+			update(dialogInfoStateConfirmed)
+		case sip.DialogStateEnded:
+			update(dialogInfoStateTerminated)
+		}
+	})
+	d.OnClose(func() error {
+		update(dialogInfoStateTerminated)
+		return nil
+	})
+}
+
+// trackClient tracks state of outgoing dialog. It is tracked from first response
+func (p *dialogEventPackage) trackClient(d *DialogClientSession) {
+	if !p.enabled.Load() {
+		return
+	}
+
+	// INVITE is built on sending, so entry is created with first response
+	var mu sync.Mutex
+	var entry *dialogInfoEntry
+	update := func(res *sip.Response, state string) {
+		mu.Lock()
+		if entry == nil {
+			if res == nil {
+				mu.Unlock()
+				return
+			}
+			localTag, _ := d.InviteRequest.From().Params.Get("tag")
+			e := newDialogInfoEntry(d.InviteRequest, localTag, "", state)
+			entry = &e
+		}
+		e := *entry
+		mu.Unlock()
+
+		if res != nil {
+			e.remoteTag, _ = res.To().Params.Get("tag")
+		}
+		e.state = state
+		p.update(e)
+	}
+
+	d.onProvisional = func(res *sip.Response) {
+		state := dialogInfoStateEarly
+		if res.StatusCode == sip.StatusTrying {
+			state = dialogInfoStateTrying
+		}
+		update(res, state)
+	}
+	d.OnState(func(s sip.DialogState) {
+		switch s {
+		case sip.DialogStateEstablished:
+			update(d.InviteResponse, dialogInfoStateConfirmed)
+		case sip.DialogStateEnded:
+			update(nil, dialogInfoStateTerminated)
+		}
+	})
+	d.OnClose(func() error {
+		update(nil, dialogInfoStateTerminated)
+		return nil
+	})
+}
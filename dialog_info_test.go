@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialogInfoMarshal(t *testing.T) {
+	e := dialogInfoEntry{
+		id:        "abc;123",
+		callID:    "abc",
+		localTag:  "123",
+		remoteTag: "456",
+		from:      sip.Uri{Scheme: "sip", User: "alice", Host: "example.com"},
+		to:        sip.Uri{Scheme: "sip", User: "bob", Host: "example.com"},
+		state:     dialogInfoStateEarly,
+	}
+	info := dialogInfo{Version: 1, State: "full", Entity: "sip:bob@example.com", Dialogs: []dialogInfoDialog{e.dialogInfo("bob")}}
+	data, err := xml.Marshal(info)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`<dialog-info xmlns="urn:ietf:params:xml:ns:dialog-info" version="1" state="full" entity="sip:bob@example.com">`+
+			`<dialog id="abc;123" call-id="abc" local-tag="123" remote-tag="456" direction="recipient"><state>early</state>`+
+			`<local><identity>sip:bob@example.com</identity></local><remote><identity>sip:alice@example.com</identity></remote>`+
+			`</dialog></dialog-info>`,
+		string(data),
+	)
+
+	assert.Equal(t, "initiator", e.dialogInfo("alice").Direction)
+}
+
+func TestIntegrationDialogEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	answer := make(chan struct{})
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("pbx"))
+		defer ua.Close()
+
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15113,
+			},
+		))
+		dg.HandleDialogEvents(DialogEventsOptions{
+			Authorize: func(s *SubscriptionServer, user string) bool {
+				return user == "bob"
+			},
+		})
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			if err := d.Ringing(); err != nil {
+				return
+			}
+			<-answer
+			if err := d.Answer(); err != nil {
+				return
+			}
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	// Phone subscribing to bob
+	infos := make(chan dialogInfo, 20)
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("phone"))
+		defer ua.Close()
+
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15114,
+			},
+		))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+		require.NoError(t, err)
+
+		_, err = dg.Subscribe(ctx, sip.Uri{User: "carol", Host: "127.0.0.1", Port: 15113}, SubscribeOptions{Event: "dialog"})
+		res := dialogErrorResponse(err)
+		require.NotNil(t, res, err)
+		assert.Equal(t, sip.StatusForbidden, res.StatusCode)
+
+		sub, err := dg.Subscribe(ctx, sip.Uri{User: "bob", Host: "127.0.0.1", Port: 15113}, SubscribeOptions{
+			Event:  "dialog",
+			Accept: dialogInfoContentType,
+			OnNotify: func(n SubscriptionNotify) {
+				info := dialogInfo{}
+				if err := xml.Unmarshal(n.Body(), &info); err != nil {
+					t.Log("Failed to parse dialog info", err)
+					return
+				}
+				infos <- info
+			},
+		})
+		require.NoError(t, err)
+		defer sub.Close()
+	}
+
+	lastVersion := -1
+	waitState := func(state string) dialogInfo {
+		timeout := time.After(3 * time.Second)
+		for {
+			select {
+			case info := <-infos:
+				assert.Greater(t, info.Version, lastVersion)
+				lastVersion = info.Version
+				assert.Equal(t, "full", info.State)
+				if state == "" && len(info.Dialogs) == 0 {
+					return info
+				}
+				if len(info.Dialogs) > 0 && info.Dialogs[0].State == state {
+					return info
+				}
+			case <-timeout:
+				t.Fatalf("dialog state %q not notified", state)
+			}
+		}
+	}
+
+	// Initial state is without dialogs
+	info := waitState("")
+	assert.Equal(t, "sip:bob@127.0.0.1", info.Entity)
+
+	ua, _ := sipgo.NewUA(sipgo.WithUserAgent("alice"))
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	dialogCh := make(chan *DialogClientSession, 1)
+	go func() {
+		d, err := dg.Invite(ctx, sip.Uri{User: "bob", Host: "127.0.0.1", Port: 15113}, InviteOptions{})
+		if err != nil {
+			t.Log("Invite failed", err)
+			return
+		}
+		dialogCh <- d
+	}()
+
+	info = waitState(dialogInfoStateEarly)
+	require.Len(t, info.Dialogs, 1)
+	assert.Equal(t, "recipient", info.Dialogs[0].Direction)
+	assert.Contains(t, info.Dialogs[0].Remote.Identity, "sip:alice@")
+	close(answer)
+
+	waitState(dialogInfoStateConfirmed)
+
+	d := <-dialogCh
+	defer d.Close()
+	require.NoError(t, d.Hangup(ctx))
+	waitState("")
+}
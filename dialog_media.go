@@ -8,10 +8,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net"
 	"net/http"
 	"os"
 	"slices"
+	"strconv"
 	"sync"
 	"time"
 
@@ -74,9 +76,26 @@ type DialogMedia struct {
 
 	onReferNotify func(statusCode int)
 
+	// dtmfInfo queues DTMF received as SIP INFO until DTMFReader consumes it
+	dtmfInfo chan rune
+	// infoDTMFWriter sends DTMF as SIP INFO within dialog. It is set by dialog session
+	infoDTMFWriter func(dtmf rune, dur time.Duration) error
+
 	onClose       func() error
 	onMediaUpdate func(*DialogMedia)
 
+	// remoteReason is Reason received from remote. carriedReason is Reason of bridged dialog used on hangup
+	remoteReason   *Reason
+	carriedReason  *Reason
+	onRemoteReason func(r Reason)
+
+	holdState HoldState
+	onHold    func(ev HoldEvent)
+
+	// metrics is set when Diago has metrics and rtpMetrics aggregates RTP statistics into it
+	metrics    *Metrics
+	rtpMetrics *rtpMetrics
+
 	closed bool
 }
 
@@ -146,6 +165,7 @@ func (d *DialogMedia) initRTPSessionUnsafe(m *media.MediaSession, rtpSess *media
 	d.rtpSession = rtpSess
 	d.RTPPacketReader = media.NewRTPPacketReaderSession(rtpSess)
 	d.RTPPacketWriter = media.NewRTPPacketWriterSession(rtpSess)
+	d.trackRTPSessionUnsafe(rtpSess)
 }
 
 func (d *DialogMedia) initMediaSessionFromConf(conf MediaConfig) error {
@@ -176,10 +196,20 @@ func (d *DialogMedia) initMediaSessionFromConf(conf MediaConfig) error {
 		DTLSConf:   conf.dtlsConf,
 	}
 
+	if !conf.sessions.acquire() {
+		return ErrMediaSessionLimit
+	}
 	if err := sess.Init(); err != nil {
+		conf.sessions.release()
 		return err
 	}
 	d.mediaSession = sess
+	if conf.sessions != nil {
+		d.OnClose(func() error {
+			conf.sessions.release()
+			return nil
+		})
+	}
 	return nil
 }
 
@@ -197,31 +227,141 @@ func (d *DialogMedia) MediaSession() *media.MediaSession {
 	return d.mediaSession
 }
 
-func (d *DialogMedia) handleMediaUpdate(req *sip.Request, tx sip.ServerTransaction, contactHDR sip.Header) error {
+func (d *DialogMedia) handleMediaUpdate(req *sip.Request, tx sip.ServerTransaction, contactHDR sip.Header, headers ...sip.Header) error {
 	d.mu.Lock()
-	defer d.mu.Unlock()
-	d.remoteContactTarget = req.Contact().Clone()
+	if cont := req.Contact(); cont != nil {
+		d.remoteContactTarget = cont.Clone()
+	}
 
 	// When body is not present this can mean client is doing keep alive
 	// Still offer needs to be responded
-	if req.Body() != nil {
+	updated := req.Body() != nil
+	if updated {
 		if err := d.sdpReInviteUnsafe(req.Body()); err != nil {
-			return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusRequestTerminated, "Request Terminated - "+err.Error(), nil))
-		}
-
-		if d.onMediaUpdate != nil {
 			d.mu.Unlock()
-			d.onMediaUpdate(d)
-			d.mu.Lock()
+			return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusRequestTerminated, "Request Terminated - "+err.Error(), nil))
 		}
 	}
+	onMediaUpdate := d.onMediaUpdate
 
 	// Reply with updated SDP
 	sd := d.mediaSession.LocalSDP()
+	d.mu.Unlock()
+
 	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", sd)
 	res.AppendHeader(contactHDR)
 	res.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
-	return tx.Respond(res)
+	for _, h := range headers {
+		res.AppendHeader(h)
+	}
+	if err := tx.Respond(res); err != nil {
+		return err
+	}
+
+	// Hooks are called after response, as they can block on media changes, ex. music on hold in bridge
+	if updated {
+		d.holdUpdate()
+		if onMediaUpdate != nil {
+			onMediaUpdate(d)
+		}
+	}
+	return nil
+}
+
+// handleReInviteACK handles ACK of re-INVITE. It carries answer when re-INVITE had no SDP and we offered in 200 OK
+func (d *DialogMedia) handleReInviteACK(req *sip.Request, tx sip.ServerTransaction) error {
+	// Check do we need to handle Late Offer from ACK and update media
+	body := req.Body()
+	if body != nil {
+		// Update media session state under lock, but invoke the app callback after unlock to avoid deadlocks.
+		d.mu.Lock()
+		err := d.sdpUpdateUnsafe(body)
+		onMediaUpdate := d.onMediaUpdate
+		d.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		d.holdUpdate()
+		if onMediaUpdate != nil {
+			onMediaUpdate(d.Media())
+		}
+	}
+
+	d.mu.Lock()
+	sess := d.mediaSession
+	d.mu.Unlock()
+	if sess == nil {
+		return nil
+	}
+	return sess.Finalize()
+}
+
+// handleUpdate handles UPDATE request (RFC 3311).
+// UPDATE without SDP only refreshes dialog, ex. session timer refresh.
+func (d *DialogMedia) handleUpdate(req *sip.Request, tx sip.ServerTransaction, contactHDR sip.Header, headers ...sip.Header) error {
+	if req.Body() == nil {
+		d.mu.Lock()
+		if cont := req.Contact(); cont != nil {
+			d.remoteContactTarget = cont.Clone()
+		}
+		d.mu.Unlock()
+
+		res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+		res.AppendHeader(contactHDR)
+		for _, h := range headers {
+			res.AppendHeader(h)
+		}
+		return tx.Respond(res)
+	}
+
+	d.mu.Lock()
+	negotiated := d.rtpSession != nil
+	d.mu.Unlock()
+	if !negotiated {
+		// https://datatracker.ietf.org/doc/html/rfc3311#section-5.2
+		// If UAS receives UPDATE with offer while it has not yet answered previous offer,
+		// it MUST return 500 with Retry-After header of randomly chosen value between 0 and 10 seconds
+		res := sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error", nil)
+		res.AppendHeader(sip.NewHeader("Retry-After", strconv.Itoa(rand.IntN(10))))
+		return tx.Respond(res)
+	}
+	return d.handleMediaUpdate(req, tx, contactHDR, headers...)
+}
+
+// handleSIPInfoDTMF parses DTMF from SIP INFO and queues it for DTMFReader
+func (d *DialogMedia) handleSIPInfoDTMF(req *sip.Request, tx sip.ServerTransaction) error {
+	contentType := ""
+	if h := req.ContentType(); h != nil {
+		contentType = h.Value()
+	}
+
+	dtmf, _, err := sipInfoDTMFParse(contentType, req.Body())
+	if err != nil {
+		return errors.Join(err, tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil)))
+	}
+
+	d.mu.Lock()
+	ch := d.dtmfInfoChanUnsafe()
+	d.mu.Unlock()
+
+	select {
+	case ch <- dtmf:
+	default:
+		// Sender is informed that digit is not accepted, instead of silently dropping it
+		media.DefaultLogger().Warn("SIP INFO DTMF queue is full, rejecting digit", "dtmf", string(dtmf))
+		res := sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error - DTMF queue full", nil)
+		res.AppendHeader(sip.NewHeader("Retry-After", "1"))
+		return tx.Respond(res)
+	}
+	return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+}
+
+func (d *DialogMedia) dtmfInfoChanUnsafe() chan rune {
+	if d.dtmfInfo == nil {
+		d.dtmfInfo = make(chan rune, 16)
+	}
+	return d.dtmfInfo
 }
 
 // Must be protected with lock
@@ -336,6 +476,9 @@ func WithAudioReaderJitterBuffer(opts media.RTPJitterBufferOptions) AudioReaderO
 		}
 
 		d.onCloseUnsafe(jitter.Close)
+		if d.metrics != nil {
+			d.onCloseUnsafe(d.metrics.trackJitterBuffer(jitter))
+		}
 		return nil
 	}
 }
@@ -358,6 +501,7 @@ func WithAudioReaderDTMF(r *DTMFReader) AudioReaderOption {
 	return func(d *DialogMedia) error {
 		r.dtmfReader = media.NewRTPDTMFReader(media.CodecTelephoneEvent8000, d.RTPPacketReader, d.getAudioReader())
 		r.mediaSession = d.mediaSession
+		r.dtmfInfo = d.dtmfInfoChanUnsafe()
 
 		d.audioReader = r
 		return nil
@@ -447,6 +591,7 @@ func WithAudioWriterDTMF(r *DTMFWriter) AudioWriterOption {
 	return func(d *DialogMedia) error {
 		r.dtmfWriter = media.NewRTPDTMFWriter(media.CodecTelephoneEvent8000, d.RTPPacketWriter, d.getAudioWriter())
 		r.mediaSession = d.mediaSession
+		r.infoDTMFWriter = d.infoDTMFWriter
 		d.audioWriter = r
 		return nil
 	}
@@ -717,27 +862,64 @@ func (d *DialogMedia) StartRTP(rw int8, dur time.Duration) error {
 type DTMFReader struct {
 	mediaSession *media.MediaSession
 	dtmfReader   *media.RTPDtmfReader
+	dtmfInfo     <-chan rune
 	onDTMF       func(dtmf rune) error
+	mu           sync.Mutex
+	// hookMu serializes onDTMF calls of RTP and SIP INFO digits
+	hookMu sync.Mutex
 }
 
 // AudioReaderDTMF is DTMF over RTP. It reads audio and provides hook for dtmf while listening for audio
+// DTMF received as SIP INFO is delivered on same hook.
 // Use Listen or OnDTMF after this call
 func (m *DialogMedia) AudioReaderDTMF() (*DTMFReader, error) {
 	ar, err := m.AudioReader()
 	if err != nil {
 		return nil, err
 	}
+
+	m.mu.Lock()
+	dtmfInfo := m.dtmfInfoChanUnsafe()
+	m.mu.Unlock()
 	return &DTMFReader{
 		dtmfReader:   media.NewRTPDTMFReader(media.CodecTelephoneEvent8000, m.RTPPacketReader, ar),
 		mediaSession: m.mediaSession,
+		dtmfInfo:     dtmfInfo,
 	}, nil
 }
 
+// Listen reads audio and calls onDTMF for each digit until error or no audio is received for dur.
+// DTMF received as SIP INFO is delivered even when no RTP is received, ex. on hold.
+// Error of onDTMF for SIP INFO digit is returned after current audio read
 func (d *DTMFReader) Listen(onDTMF func(dtmf rune) error, dur time.Duration) error {
-	d.onDTMF = onDTMF
+	d.OnDTMF(onDTMF)
+
+	done := make(chan struct{})
+	defer close(done)
+	infoErr := make(chan error, 1)
+	go func() {
+		for {
+			select {
+			case dtmf := <-d.dtmfInfo:
+				if err := d.handleDTMF(dtmf); err != nil {
+					infoErr <- err
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
 	buf := make([]byte, media.RTPBufSize)
 	for {
-		if _, err := d.readDeadline(buf, dur); err != nil {
+		_, err := d.readDeadline(buf, dur)
+		select {
+		case err := <-infoErr:
+			return err
+		default:
+		}
+		if err != nil {
 			if errors.Is(err, os.ErrDeadlineExceeded) {
 				return nil
 			}
@@ -759,9 +941,24 @@ func (d *DTMFReader) readDeadline(buf []byte, dur time.Duration) (n int, err err
 
 // OnDTMF must be called before audio reading
 func (d *DTMFReader) OnDTMF(onDTMF func(dtmf rune) error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	d.onDTMF = onDTMF
 }
 
+func (d *DTMFReader) handleDTMF(dtmf rune) error {
+	d.mu.Lock()
+	onDTMF := d.onDTMF
+	d.mu.Unlock()
+	if onDTMF == nil {
+		return nil
+	}
+
+	d.hookMu.Lock()
+	defer d.hookMu.Unlock()
+	return onDTMF(dtmf)
+}
+
 // Read exposes io.Reader that can be used as AudioReader
 func (d *DTMFReader) Read(buf []byte) (n int, err error) {
 	// This is optimal way of reading audio and DTMF
@@ -772,16 +969,36 @@ func (d *DTMFReader) Read(buf []byte) (n int, err error) {
 	}
 
 	if dtmf, ok := dtmfReader.ReadDTMF(); ok {
-		if err := d.onDTMF(dtmf); err != nil {
+		if err := d.handleDTMF(dtmf); err != nil {
 			return n, err
 		}
 	}
+
+	// DTMF received as SIP INFO is passed while audio is read.
+	// Without hook digits stay queued
+	d.mu.Lock()
+	hasHook := d.onDTMF != nil
+	d.mu.Unlock()
+	if !hasHook {
+		return n, nil
+	}
+	select {
+	case dtmf := <-d.dtmfInfo:
+		if err := d.handleDTMF(dtmf); err != nil {
+			return n, err
+		}
+	default:
+	}
 	return n, nil
 }
 
 type DTMFWriter struct {
-	mediaSession *media.MediaSession
-	dtmfWriter   *media.RTPDtmfWriter
+	// Mode defines how DTMF is sent. Default is RTP telephone-event
+	Mode DTMFMode
+
+	mediaSession   *media.MediaSession
+	dtmfWriter     *media.RTPDtmfWriter
+	infoDTMFWriter func(dtmf rune, dur time.Duration) error
 }
 
 func (m *DialogMedia) AudioWriterDTMF() (*DTMFWriter, error) {
@@ -790,16 +1007,44 @@ func (m *DialogMedia) AudioWriterDTMF() (*DTMFWriter, error) {
 		return nil, err
 	}
 
+	m.mu.Lock()
+	infoDTMFWriter := m.infoDTMFWriter
+	m.mu.Unlock()
 	return &DTMFWriter{
-		dtmfWriter:   media.NewRTPDTMFWriter(media.CodecTelephoneEvent8000, m.RTPPacketWriter, aw),
-		mediaSession: m.mediaSession,
+		dtmfWriter:     media.NewRTPDTMFWriter(media.CodecTelephoneEvent8000, m.RTPPacketWriter, aw),
+		mediaSession:   m.mediaSession,
+		infoDTMFWriter: infoDTMFWriter,
 	}, nil
 }
 
 func (w *DTMFWriter) WriteDTMF(dtmf rune) error {
+	if w.sipInfoMode() {
+		if w.infoDTMFWriter == nil {
+			return fmt.Errorf("dialog does not support SIP INFO DTMF")
+		}
+		return w.infoDTMFWriter(dtmf, sipInfoDTMFDuration)
+	}
 	return w.dtmfWriter.WriteDTMF(dtmf)
 }
 
+func (w *DTMFWriter) sipInfoMode() bool {
+	switch w.Mode {
+	case DTMFModeSIPInfo:
+		return true
+	case DTMFModeAuto:
+		if w.mediaSession == nil {
+			return true
+		}
+		for _, c := range w.mediaSession.CommonCodecs() {
+			if c.Name == media.CodecTelephoneEvent8000.Name {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
 // AudioReader exposes DTMF audio writer. You should use this for parallel audio processing
 func (w *DTMFWriter) AudioWriter() *media.RTPDtmfWriter {
 	return w.dtmfWriter
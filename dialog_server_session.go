@@ -32,12 +32,50 @@ type DialogServerSession struct {
 
 	mediaConf MediaConfig
 	closed    atomic.Uint32
+
+	rel100 Rel100Mode
+	// rseq is last RSeq sent on reliable provisional response
+	rseq uint32
+	// reliable is pending reliable provisional response waiting for PRACK
+	reliable *reliableResponse
+	// earlyCSeq is highest CSeq of request received before ACK
+	earlyCSeq uint32
+	// remoteCSeq is last CSeq of request received after ACK. Protected by mu
+	remoteCSeq uint32
+
+	// ctx is canceled when diago terminates dialog, ex. on session timer expiry
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	sessionTimer *sessionTimer
+	// answerHeaders are added on 2xx response
+	answerHeaders []sip.Header
+
+	// replaced is dialog replaced by this call with Replaces header
+	replaced DialogSession
+
+	// onProvisional is called after provisional response is sent
+	onProvisional func(res *sip.Response)
+
+	// authIdentity is set when call is authenticated with WithServerAuth
+	authIdentity AuthIdentity
+
+	// cdr is set when CDR sink is configured
+	cdr *cdrRecord
 }
 
 func (d *DialogServerSession) Id() string {
 	return d.ID
 }
 
+// Context returns dialog context. It is done when dialog is terminated
+func (d *DialogServerSession) Context() context.Context {
+	if d.ctx != nil {
+		return d.ctx
+	}
+	return d.DialogServerSession.Context()
+}
+
 func (d *DialogServerSession) Close() error {
 	if !d.closed.CompareAndSwap(0, 1) {
 		return nil
@@ -98,12 +136,229 @@ func (d *DialogServerSession) ProgressMediaOptions(opt ProgressMediaOptions) err
 
 	headers := []sip.Header{sip.NewHeader("Content-Type", "application/sdp")}
 	body := rtpSess.Sess.LocalSDP()
-	if err := d.DialogServerSession.Respond(183, "Session Progress", body, headers...); err != nil {
+	if err := d.Respond(183, "Session Progress", body, headers...); err != nil {
 		return err
 	}
 	return rtpSess.MonitorBackground()
 }
 
+// Respond sends response on INVITE.
+// Provisional responses other than 100 are sent reliably (RFC 3262) when caller requires 100rel
+// or both sides support it. They are retransmitted in background until PRACK is received,
+// and next reliable provisional response is not sent before previous one is acknowledged.
+func (d *DialogServerSession) Respond(statusCode int, reason string, body []byte, headers ...sip.Header) error {
+	var err error
+	if statusCode > sip.StatusTrying && statusCode < sip.StatusOK && d.reliableProvisional() {
+		err = d.respondReliable(statusCode, reason, body, headers...)
+	} else {
+		if statusCode >= sip.StatusOK {
+			if err := d.reliableFinalize(statusCode); err != nil {
+				return err
+			}
+		}
+		err = d.DialogServerSession.Respond(statusCode, reason, body, headers...)
+	}
+
+	if err == nil && statusCode < sip.StatusOK && d.onProvisional != nil {
+		d.onProvisional(d.InviteResponse)
+	}
+	return err
+}
+
+func (d *DialogServerSession) remoteSupports100rel() bool {
+	return sipHeaderHasOption(d.InviteRequest, "Supported", optionTag100rel) || sipHeaderHasOption(d.InviteRequest, "Require", optionTag100rel)
+}
+
+func (d *DialogServerSession) reliableProvisional() bool {
+	if sipHeaderHasOption(d.InviteRequest, "Require", optionTag100rel) {
+		return true
+	}
+	return d.rel100 != Rel100ModeDisabled && d.remoteSupports100rel()
+}
+
+// reliableResponse is reliable provisional response retransmitted until PRACK is received
+type reliableResponse struct {
+	rseq uint32
+	sdp  bool
+	// acked is closed on PRACK, stop on final response
+	acked chan struct{}
+	stop  chan struct{}
+	// done is closed when retransmission is over. err is set before if PRACK was not received
+	done chan struct{}
+	err  error
+}
+
+// reliableWait waits until pending reliable provisional response is acknowledged.
+// With sdp set it waits only if response contained session description
+func (d *DialogServerSession) reliableWait(sdp bool) error {
+	d.mu.Lock()
+	r := d.reliable
+	d.mu.Unlock()
+	if r == nil || (sdp && !r.sdp) {
+		return nil
+	}
+
+	select {
+	case <-r.done:
+		return r.err
+	case <-d.Context().Done():
+		return d.Context().Err()
+	}
+}
+
+// reliableFinalize must be called before final response.
+// https://datatracker.ietf.org/doc/html/rfc3262#section-3
+// The UAS MAY send a final response before having received PRACKs for all unacknowledged reliable provisional responses,
+// unless the final response is 2xx and any of the unacknowledged reliable provisional responses contained a session description.
+// After final response unacknowledged responses are not retransmitted.
+func (d *DialogServerSession) reliableFinalize(statusCode int) error {
+	if statusCode < 300 {
+		if err := d.reliableWait(true); err != nil {
+			return err
+		}
+	}
+
+	d.mu.Lock()
+	if r := d.reliable; r != nil {
+		close(r.stop)
+		d.reliable = nil
+	}
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *DialogServerSession) respondReliable(statusCode int, reason string, body []byte, headers ...sip.Header) error {
+	// https://datatracker.ietf.org/doc/html/rfc3262#section-3
+	// UAS MUST NOT send a second reliable provisional response until the first is acknowledged.
+	if err := d.reliableWait(false); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	if d.reliable != nil {
+		d.mu.Unlock()
+		return fmt.Errorf("reliable provisional response is still not acknowledged")
+	}
+	if d.rseq == 0 {
+		// The value of the RSeq in each response MUST be initialized to a value between 1 and 2**31 - 1.
+		d.rseq = mrand.Uint32N(1<<31-1) + 1
+	} else {
+		d.rseq++
+	}
+	r := &reliableResponse{
+		rseq:  d.rseq,
+		sdp:   len(body) > 0,
+		acked: make(chan struct{}),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	d.reliable = r
+	d.mu.Unlock()
+
+	res := sip.NewResponseFromRequest(d.InviteRequest, statusCode, reason, body)
+	for _, h := range headers {
+		res.AppendHeader(h)
+	}
+	res.AppendHeader(sip.NewHeader("Require", optionTag100rel))
+	res.AppendHeader(sip.NewHeader("RSeq", strconv.FormatUint(uint64(r.rseq), 10)))
+
+	if err := d.WriteResponse(res); err != nil {
+		d.mu.Lock()
+		d.reliable = nil
+		d.mu.Unlock()
+		close(r.done)
+		return err
+	}
+
+	go d.reliableRetransmit(r, res)
+	return nil
+}
+
+func (d *DialogServerSession) reliableRetransmit(r *reliableResponse, res *sip.Response) {
+	defer close(r.done)
+
+	// The reliable provisional response is passed to the transaction layer periodically
+	// with an interval that starts at T1 seconds and doubles for each retransmission.
+	interval := sip.T1
+	retransmit := time.NewTimer(interval)
+	defer retransmit.Stop()
+	timeout := time.NewTimer(64 * sip.T1)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-r.acked:
+			return
+		case <-r.stop:
+			return
+		case <-retransmit.C:
+			interval *= 2
+			retransmit.Reset(interval)
+			if err := d.WriteResponse(res); err != nil {
+				media.DefaultLogger().Info("Reliable provisional response retransmission failed", "error", err, "id", d.ID)
+			}
+			continue
+		case <-timeout.C:
+		case <-d.Context().Done():
+		}
+		break
+	}
+
+	d.mu.Lock()
+	pending := d.reliable == r
+	if pending {
+		d.reliable = nil
+	}
+	d.mu.Unlock()
+	if !pending {
+		// Acknowledged or finalized meanwhile
+		return
+	}
+
+	if err := d.Context().Err(); err != nil {
+		r.err = err
+		return
+	}
+	// If a reliable provisional response is retransmitted for 64*T1 seconds
+	// without reception of a corresponding PRACK, the UAS SHOULD reject the
+	// original request with a 5xx response.
+	err := d.DialogServerSession.Respond(sip.StatusGatewayTimeout, "Server Time-out", nil)
+	r.err = errors.Join(fmt.Errorf("no PRACK received"), err)
+}
+
+func (d *DialogServerSession) readPrack(req *sip.Request, tx sip.ServerTransaction) error {
+	if err := d.ReadRequest(req, tx); err != nil {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - "+err.Error(), nil))
+	}
+
+	h := req.GetHeader("RAck")
+	if h == nil {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - missing RAck", nil))
+	}
+
+	rseq, cseq, method, err := parseRAck(h.Value())
+	if err != nil {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - "+err.Error(), nil))
+	}
+
+	d.mu.Lock()
+	r := d.reliable
+	matched := r != nil && rseq == r.rseq && cseq == d.InviteRequest.CSeq().SeqNo && method == sip.INVITE
+	if matched {
+		close(r.acked)
+		d.reliable = nil
+	}
+	d.mu.Unlock()
+
+	if !matched {
+		// https://datatracker.ietf.org/doc/html/rfc3262#section-3
+		// If the PRACK does not match any unacknowledged reliable provisional response,
+		// the UAS MUST respond to the PRACK with a 481 response.
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil))
+	}
+	return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+}
+
 func (d *DialogServerSession) Ringing() error {
 	return d.Respond(sip.StatusRinging, "Ringing", nil)
 }
@@ -124,7 +379,15 @@ func (d *DialogServerSession) RemoteContact() *sip.ContactHeader {
 
 func (d *DialogServerSession) RespondSDP(body []byte) error {
 	headers := []sip.Header{sip.NewHeader("Content-Type", "application/sdp")}
-	return d.DialogServerSession.Respond(200, "OK", body, headers...)
+	headers = append(headers, d.answerHeaders...)
+	if err := d.reliableFinalize(sip.StatusOK); err != nil {
+		return err
+	}
+	if err := d.DialogServerSession.Respond(200, "OK", body, headers...); err != nil {
+		return err
+	}
+	d.startSessionTimer()
+	return nil
 }
 
 // Answer creates media session and answers
@@ -165,6 +428,9 @@ type AnswerOptions struct {
 	// RTPNAT is media.MediaSession.RTPNAT
 	// Check media.RTPNAT... options
 	RTPNAT int
+
+	// SessionTimer enables session timer (RFC 4028) negotiation
+	SessionTimer SessionTimerOptions
 }
 
 // AnswerOptions allows to answer dialog with options
@@ -177,6 +443,12 @@ func (d *DialogServerSession) AnswerOptions(opt AnswerOptions) error {
 	d.onMediaUpdate = opt.OnMediaUpdate
 	d.mu.Unlock()
 
+	if opt.SessionTimer.enabled() {
+		if err := d.negotiateSessionTimer(opt.SessionTimer); err != nil {
+			return err
+		}
+	}
+
 	// If media exists as early, only respond 200
 	if d.mediaSession != nil {
 		// Check do codecs match
@@ -288,6 +560,15 @@ func (d *DialogServerSession) AnswerLate() error {
 }
 
 func (d *DialogServerSession) ReadAck(req *sip.Request, tx sip.ServerTransaction) error {
+	if d.LoadState() == sip.DialogStateConfirmed {
+		if req.CSeq().SeqNo == d.InviteRequest.CSeq().SeqNo {
+			// Retransmission of ACK
+			return nil
+		}
+		// This is from REINVITE
+		return d.handleReInviteACK(req, tx)
+	}
+
 	// Check do we have some session
 	err := func() error {
 		d.mu.Lock()
@@ -322,12 +603,37 @@ func (d *DialogServerSession) ReadAck(req *sip.Request, tx sip.ServerTransaction
 	return d.DialogServerSession.ReadAck(req, tx)
 }
 
-func (d *DialogServerSession) Hangup(ctx context.Context) error {
-	state := d.LoadState()
-	if state >= sip.DialogStateConfirmed {
-		return d.Bye(ctx)
+// ReadRequest validates CSeq of request within dialog.
+// Requests received before ACK do not update remote CSeq, as ACK must match CSeq of INVITE.
+// Remote CSeq is tracked here, so that it can be restored with dialog
+func (d *DialogServerSession) ReadRequest(req *sip.Request, tx sip.ServerTransaction) error {
+	cseq := req.CSeq().SeqNo
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cseq <= d.earlyCSeq {
+		return sipgo.ErrDialogInvalidCseq
 	}
-	return d.Respond(sip.StatusTemporarilyUnavailable, "Temporarly unavailable", nil)
+	if d.LoadState() < sip.DialogStateConfirmed {
+		if cseq <= d.InviteRequest.CSeq().SeqNo {
+			return sipgo.ErrDialogInvalidCseq
+		}
+		d.earlyCSeq = cseq
+		return nil
+	}
+	if cseq < d.remoteCSeqUnsafe() {
+		return sipgo.ErrDialogInvalidCseq
+	}
+	d.remoteCSeq = cseq
+	return nil
+}
+
+// remoteCSeqUnsafe returns last CSeq received within dialog
+func (d *DialogServerSession) remoteCSeqUnsafe() uint32 {
+	return max(d.remoteCSeq, d.earlyCSeq, d.InviteRequest.CSeq().SeqNo)
+}
+
+func (d *DialogServerSession) Hangup(ctx context.Context) error {
+	return d.HangupOptions(ctx, HangupOptions{})
 }
 
 func (d *DialogServerSession) ReInvite(ctx context.Context) error {
@@ -377,7 +683,7 @@ func (d *DialogServerSession) reInviteMediaSession(ctx context.Context, ms *medi
 	}
 
 	// Save new remote target contact and update media
-	return func() error {
+	err = func() error {
 		d.mu.Lock()
 		defer d.mu.Unlock()
 		d.remoteContactTarget = res.Contact()
@@ -388,6 +694,11 @@ func (d *DialogServerSession) reInviteMediaSession(ctx context.Context, ms *medi
 		}
 		return d.mediaUpdateUnsafe(ms)
 	}()
+	if err != nil {
+		return err
+	}
+	d.holdUpdate()
+	return nil
 }
 
 func (d *DialogServerSession) reInviteDo(ctx context.Context, req *sip.Request) (*sip.Response, error) {
@@ -433,6 +744,21 @@ func (d *DialogServerSession) reInviteDo(ctx context.Context, req *sip.Request)
 	}
 }
 
+// Update sends UPDATE (RFC 3311) with current media session as offer and applies answer.
+// Unlike ReInvite it can be used in early dialog, once early media is setup with ProgressMedia.
+func (d *DialogServerSession) Update(ctx context.Context) error {
+	d.mu.Lock()
+	if d.rtpSession == nil {
+		d.mu.Unlock()
+		return fmt.Errorf("update: media is not negotiated")
+	}
+	ms := d.mediaSession.Fork()
+	contact := d.remoteContactUnsafe()
+	d.mu.Unlock()
+
+	return dialogUpdateMedia(ctx, d, contact.Address, nil, ms)
+}
+
 func (d *DialogServerSession) ack(ctx context.Context, remoteTarget sip.Uri, body []byte) error {
 	// inviteRequest := d.InviteRequest
 	// recipient := &inviteRequest.Recipient
@@ -487,6 +813,24 @@ func (d *DialogServerSession) Refer(ctx context.Context, referTo sip.Uri, header
 	})
 }
 
+// ReferReplaces does attended transfer. It refers remote side to target of replaceDialog
+// with embedded Replaces header, so that new call replaces replaceDialog (RFC 3891).
+//
+// NOTE: It is expected that after calling this you are hanguping call to send BYE
+func (d *DialogServerSession) ReferReplaces(ctx context.Context, replaceDialog DialogSession, headers ...sip.Header) error {
+	referTo, err := dialogReferReplacesTarget(replaceDialog)
+	if err != nil {
+		return fmt.Errorf("refer replaces: %w", err)
+	}
+	return d.Refer(ctx, referTo, headers...)
+}
+
+// ReplacedDialog returns dialog which this call replaces, when INVITE has Replaces header (RFC 3891).
+// Replaced dialog is hanguped once this dialog is answered and confirmed.
+func (d *DialogServerSession) ReplacedDialog() DialogSession {
+	return d.replaced
+}
+
 type ReferServerOptions struct {
 	Headers  []sip.Header
 	OnNotify func(statusCode int)
@@ -545,24 +889,137 @@ func (d *DialogServerSession) handleReInvite(req *sip.Request, tx sip.ServerTran
 		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, err.Error(), nil))
 	}
 
-	return d.handleMediaUpdate(req, tx, d.InviteResponse.Contact())
+	d.mu.Lock()
+	st := d.sessionTimer
+	d.mu.Unlock()
+
+	var headers []sip.Header
+	if st != nil {
+		headers = st.handleRefresh(req)
+	}
+	return d.handleMediaUpdate(req, tx, d.InviteResponse.Contact(), headers...)
+}
+
+func (d *DialogServerSession) handleUpdate(req *sip.Request, tx sip.ServerTransaction) error {
+	// NOTE: UPDATE can be received in early dialog or before ACK, which ReadRequest handles
+	if err := d.ReadRequest(req, tx); err != nil {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - "+err.Error(), nil))
+	}
+
+	d.mu.Lock()
+	st := d.sessionTimer
+	d.mu.Unlock()
+
+	var headers []sip.Header
+	if st != nil {
+		headers = st.handleRefresh(req)
+	}
+	return d.DialogMedia.handleUpdate(req, tx, d.InviteResponse.Contact(), headers...)
+}
+
+// negotiateSessionTimer checks session timer of INVITE and prepares 2xx headers.
+// https://datatracker.ietf.org/doc/html/rfc4028#section-9
+func (d *DialogServerSession) negotiateSessionTimer(opts SessionTimerOptions) error {
+	req := d.InviteRequest
+	minSE := opts.minSE()
+	interval := opts.interval()
+	remoteSupported := sipHeaderHasOption(req, "Supported", optionTagTimer) || sipHeaderHasOption(req, "Require", optionTagTimer)
+
+	refresher := refresherUAS
+	if remoteSupported {
+		refresher = refresherUAC
+	}
+
+	if se, ok := sessionExpiresFromMessage(req); ok {
+		if se.interval < minSE {
+			minSEHdr := sip.NewHeader("Min-SE", strconv.Itoa(int(minSE/time.Second)))
+			err := d.Respond(sip.StatusIntervalToBrief, "Session Interval Too Small", nil, minSEHdr)
+			return errors.Join(fmt.Errorf("session interval %s too small", se.interval), err)
+		}
+
+		// UAS can reduce session interval, but not lower than Min-SE of request
+		reqMinSE := sessionTimerMinSE
+		if h := req.GetHeader("Min-SE"); h != nil {
+			if v, err := parseDeltaSeconds(h.Value()); err == nil {
+				reqMinSE = max(v, reqMinSE)
+			}
+		}
+		interval = min(se.interval, max(interval, reqMinSE))
+
+		if remoteSupported && se.refresher != "" {
+			refresher = se.refresher
+		}
+	}
+
+	se := sessionExpires{interval: interval, refresher: refresher}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.answerHeaders = append(d.answerHeaders, sip.NewHeader("Session-Expires", se.String()))
+	if remoteSupported {
+		d.answerHeaders = append(d.answerHeaders, sip.NewHeader("Require", optionTagTimer))
+	}
+	d.sessionTimer = newSessionTimer(interval, refresher == refresherUAS)
+	return nil
+}
+
+func (d *DialogServerSession) startSessionTimer() {
+	d.mu.Lock()
+	st := d.sessionTimer
+	d.mu.Unlock()
+	if st == nil {
+		return
+	}
+	go st.run(d.Context(), d.refreshSession, d.sessionExpired)
+}
+
+// refreshSession sends session refresh with re-INVITE
+func (d *DialogServerSession) refreshSession(ctx context.Context, se sessionExpires) (*sip.Response, error) {
+	d.mu.Lock()
+	contact := d.remoteContactUnsafe()
+	sdp := d.mediaSession.LocalSDP()
+	d.mu.Unlock()
+
+	req := sip.NewRequest(sip.INVITE, contact.Address)
+	req.AppendHeader(d.InviteResponse.Contact())
+	req.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+	req.AppendHeader(sip.NewHeader("Supported", optionTagTimer))
+	req.AppendHeader(sip.NewHeader("Session-Expires", se.String()))
+	req.SetBody(sdp)
+	return d.reInviteDo(ctx, req)
+}
+
+// sessionExpired terminates dialog when session is not refreshed
+func (d *DialogServerSession) sessionExpired() {
+	ctx, cancel := context.WithTimeout(context.Background(), sip.Timer_F)
+	defer cancel()
+	if err := d.Hangup(ctx); err != nil {
+		media.DefaultLogger().Info("Hangup on session expiry failed", "error", err, "id", d.ID)
+	}
+	if d.cancel != nil {
+		d.cancel(ErrSessionTimerExpired)
+	}
 }
 
 func (d *DialogServerSession) readSIPInfoDTMF(req *sip.Request, tx sip.ServerTransaction) error {
-	return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusNotAcceptable, "Not Acceptable", nil))
-	// if err := d.ReadRequest(req, tx); err != nil {
-	// 	tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil))
-	// 	return
-	// }
+	if err := d.ReadRequest(req, tx); err != nil {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil))
+	}
 
 	// Parse this
-	//Signal=1
+	// Signal=1
 	// Duration=160
-	// reader := bytes.NewReader(req.Body())
+	return d.handleSIPInfoDTMF(req, tx)
+}
 
-	// for {
+// writeInfoDTMF sends DTMF as SIP INFO. Use DTMFWriter with DTMFModeSIPInfo
+func (d *DialogServerSession) writeInfoDTMF(dtmf rune, dur time.Duration) error {
+	d.mu.Lock()
+	contact := d.remoteContactUnsafe()
+	d.mu.Unlock()
 
-	// }
+	ctx, cancel := context.WithTimeout(d.Context(), sip.Timer_F)
+	defer cancel()
+	return dialogWriteInfoDTMF(ctx, d, contact.Address, dtmf, dur)
 }
 
 func (d *DialogServerSession) Hold(ctx context.Context) error {
@@ -436,3 +436,32 @@ func TestIntegrationDialogServerPlayback(t *testing.T) {
 	// Timestamp should be offset more than previous diff by Sleep
 	assert.Greater(t, diffTS2, diffTS+5*media.CodecAudioUlaw.SampleTimestamp())
 }
+
+func TestDialogServerReadRequestEarly(t *testing.T) {
+	invite := sip.NewRequest(sip.INVITE, sip.Uri{User: "test", Host: "localhost"})
+	invite.AppendHeader(&sip.CSeqHeader{SeqNo: 10, MethodName: sip.INVITE})
+	d := &DialogServerSession{
+		DialogServerSession: &sipgo.DialogServerSession{
+			Dialog: sipgo.Dialog{InviteRequest: invite},
+		},
+	}
+	d.Init()
+
+	request := func(method sip.RequestMethod, cseq uint32) *sip.Request {
+		req := sip.NewRequest(method, sip.Uri{User: "test", Host: "localhost"})
+		req.AppendHeader(&sip.CSeqHeader{SeqNo: cseq, MethodName: method})
+		return req
+	}
+
+	// Early UPDATE must have higher CSeq, but it does not change remote CSeq
+	require.ErrorIs(t, d.ReadRequest(request(sip.UPDATE, 10), nil), sipgo.ErrDialogInvalidCseq)
+	require.NoError(t, d.ReadRequest(request(sip.UPDATE, 11), nil))
+	require.ErrorIs(t, d.ReadRequest(request(sip.UPDATE, 11), nil), sipgo.ErrDialogInvalidCseq)
+
+	// ACK still matches INVITE
+	require.NoError(t, d.ReadAck(request(sip.ACK, 10), nil))
+	require.Equal(t, sip.DialogStateConfirmed, d.LoadState())
+
+	require.ErrorIs(t, d.ReadRequest(request(sip.INFO, 11), nil), sipgo.ErrDialogInvalidCseq)
+	require.NoError(t, d.ReadRequest(request(sip.INFO, 12), nil))
+}
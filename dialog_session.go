@@ -7,9 +7,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	mrand "math/rand/v2"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/emiago/diago/media"
 	"github.com/emiago/sipgo"
 	"github.com/emiago/sipgo/sip"
 )
@@ -65,6 +68,94 @@ func dialogRefer(ctx context.Context, d DialogSession, recipient sip.Uri, referT
 	return nil
 }
 
+// dialogWriteInfoDTMF sends DTMF as SIP INFO application/dtmf-relay
+func dialogWriteInfoDTMF(ctx context.Context, d DialogSession, recipient sip.Uri, dtmf rune, dur time.Duration) error {
+	if d.DialogSIP().LoadState() != sip.DialogStateConfirmed {
+		return fmt.Errorf("can only be called on answered dialog")
+	}
+
+	req := sip.NewRequest(sip.INFO, recipient)
+	req.AppendHeader(sip.NewHeader("Content-Type", contentTypeDTMFRelay))
+	req.SetBody(sipInfoDTMFBody(dtmf, dur))
+
+	res, err := d.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if !res.IsSuccess() {
+		return sipgo.ErrDialogResponse{
+			Res: res,
+		}
+	}
+	return nil
+}
+
+// dialogUpdateMedia sends UPDATE with media session offer and applies answer on success.
+// https://datatracker.ietf.org/doc/html/rfc3311
+// media MUST BE Forked
+func dialogUpdateMedia(ctx context.Context, d DialogSession, recipient sip.Uri, contact sip.Header, ms *media.MediaSession) error {
+	if d.DialogSIP().LoadState() == sip.DialogStateEnded {
+		return fmt.Errorf("dialog already ended")
+	}
+
+	req := sip.NewRequest(sip.UPDATE, recipient)
+	if contact != nil {
+		req.AppendHeader(contact)
+	}
+	req.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+	req.SetBody(ms.LocalSDP())
+
+	for {
+		res, err := d.Do(ctx, req.Clone())
+		if err != nil {
+			return err
+		}
+
+		if !res.IsSuccess() {
+			// Same as re-INVITE, 491 means offer collision and request can be retried
+			// https://datatracker.ietf.org/doc/html/rfc3311#section-5.1
+			if res.StatusCode == sip.StatusRequestPending {
+				select {
+				case <-time.After(time.Duration(2000+mrand.IntN(200)*10) * time.Millisecond):
+					continue
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+
+			return sipgo.ErrDialogResponse{
+				Res: res,
+			}
+		}
+
+		remoteSDP := res.Body()
+		if remoteSDP == nil {
+			return fmt.Errorf("update: no SDP in response")
+		}
+
+		// Save new remote target contact and update media
+		med := d.Media()
+		err = func() error {
+			med.mu.Lock()
+			defer med.mu.Unlock()
+			if cont := res.Contact(); cont != nil {
+				med.remoteContactTarget = cont
+			}
+
+			if err := ms.RemoteSDP(remoteSDP); err != nil {
+				return fmt.Errorf("sdp update media remote SDP applying failed: %w", err)
+			}
+			return med.mediaUpdateUnsafe(ms)
+		}()
+		if err != nil {
+			return err
+		}
+		med.holdUpdate()
+		return nil
+	}
+}
+
 func dialogHandleReferNotify(d DialogSession, req *sip.Request, tx sip.ServerTransaction) {
 	// TODO how to know this is refer
 	contentType := req.ContentType().Value()
@@ -224,12 +315,19 @@ func dialogReferInvite(d DialogSession, dg *Diago, referToUri sip.Uri, remoteTar
 	// 	opts.Headers = append(opts.Headers, sip.HeaderClone(referredBy))
 	// }
 
+	// Attended transfer has Replaces embedded in Refer-To uri
+	replacesHDR := referToReplaces(&referToUri)
+
 	referDialog, err := dg.NewDialog(referToUri, NewDialogOptions{})
 	if err != nil {
 		return err
 	}
 	defer referDialog.Close()
 
+	if replacesHDR != nil {
+		referDialog.InviteRequest.AppendHeader(replacesHDR)
+	}
+
 	if h := referReq.GetHeader("referred-by"); h != nil {
 		referDialog.InviteRequest.AppendHeader(sip.HeaderClone(h))
 	}
@@ -306,6 +404,9 @@ func (r *ReferTransaction) Accept(ctx context.Context, opts InviteClientOptions)
 		return nil, fmt.Errorf("failed to send 202 Accepted")
 	}
 
+	// Attended transfer has Replaces embedded in Refer-To uri
+	replacesHDR := referToReplaces(&referToUri)
+
 	referDialog, err := dg.NewDialog(referToUri, NewDialogOptions{})
 	if err != nil {
 		return nil, err
@@ -321,7 +422,9 @@ func (r *ReferTransaction) Accept(ctx context.Context, opts InviteClientOptions)
 		if h := req.GetHeader("refered-by"); h != nil {
 			opts.Headers = append(opts.Headers, sip.HeaderClone(h))
 		}
-		if h := req.GetHeader("replaces"); h != nil {
+		if replacesHDR != nil {
+			opts.Headers = append(opts.Headers, replacesHDR)
+		} else if h := req.GetHeader("replaces"); h != nil {
 			opts.Headers = append(opts.Headers, sip.HeaderClone(h))
 		}
 
@@ -4,17 +4,37 @@
 package diago
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/emiago/sipgo"
 	"github.com/emiago/sipgo/sip"
 	"github.com/icholy/digest"
 )
 
+// Digest algorithms https://datatracker.ietf.org/doc/html/rfc8760
+const (
+	DigestAlgorithmMD5       = "MD5"
+	DigestAlgorithmSHA256    = "SHA-256"
+	DigestAlgorithmSHA512256 = "SHA-512-256"
+)
+
 type DigestAuth struct {
 	Username string
 	Password string
@@ -30,18 +50,142 @@ func (a *DigestAuth) expire() time.Duration {
 }
 
 type digestChallengeEntry struct {
-	digest.Challenge
+	algorithms []string
+	qop        []string
+	// nc is last nonce count received. Without qop nonce can be used only once
+	nc          int
+	used        bool
 	expireTimer *time.Timer
 }
 
+// DigestCredentials are stored credentials of user.
+// HA1 is precomputed hash of username:realm:password and it is used instead of Password when set.
+// This avoids storing cleartext passwords. HA1 is MD5 hash, and for other algorithms matching HA1 field is used
+type DigestCredentials struct {
+	Password     string
+	HA1          string
+	HA1SHA256    string
+	HA1SHA512256 string
+}
+
+func (c DigestCredentials) ha1(algorithm string) (string, error) {
+	var ha1 string
+	switch algorithm {
+	case "", DigestAlgorithmMD5:
+		ha1 = c.HA1
+	case DigestAlgorithmSHA256:
+		ha1 = c.HA1SHA256
+	case DigestAlgorithmSHA512256:
+		ha1 = c.HA1SHA512256
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+	if ha1 == "" && c.Password == "" {
+		return "", fmt.Errorf("no credentials for digest algorithm %q", algorithm)
+	}
+	return ha1, nil
+}
+
+// DigestCredentialProvider looks up credentials of user authenticating in realm.
+// It should return ErrDigestAuthUnknownUser if user does not exist
+type DigestCredentialProvider interface {
+	DigestCredentials(ctx context.Context, username string, realm string) (DigestCredentials, error)
+}
+
+// DigestCredentialProviderFunc is function implementing DigestCredentialProvider
+type DigestCredentialProviderFunc func(ctx context.Context, username string, realm string) (DigestCredentials, error)
+
+func (f DigestCredentialProviderFunc) DigestCredentials(ctx context.Context, username string, realm string) (DigestCredentials, error) {
+	return f(ctx, username, realm)
+}
+
+// DigestHA1 computes HA1 MD5(username:realm:password) that can be stored instead of password
+func DigestHA1(username string, realm string, password string) string {
+	ha1, _ := DigestHA1Algorithm(DigestAlgorithmMD5, username, realm, password)
+	return ha1
+}
+
+// DigestHA1Algorithm computes HA1 of username:realm:password with digest algorithm
+func DigestHA1Algorithm(algorithm string, username string, realm string, password string) (string, error) {
+	h, err := digestHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	h.Write([]byte(username + ":" + realm + ":" + password))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func digestHash(algorithm string) (hash.Hash, error) {
+	switch strings.ToUpper(algorithm) {
+	case "", DigestAlgorithmMD5:
+		return md5.New(), nil
+	case DigestAlgorithmSHA256:
+		return sha256.New(), nil
+	case DigestAlgorithmSHA512256:
+		return sha512.New512_256(), nil
+	}
+	return nil, fmt.Errorf("unsupported digest algorithm %q", algorithm)
+}
+
 type DigestAuthServer struct {
 	mu    sync.Mutex
 	cache map[string]*digestChallengeEntry
+
+	credentials DigestCredentialProvider
+	algorithms  []string
+	qop         []string
+	proxy       bool
+	expire      time.Duration
+}
+
+type DigestServerOption func(s *DigestAuthServer)
+
+// WithDigestCredentialProvider sets provider used by Authorize for looking up credentials
+func WithDigestCredentialProvider(p DigestCredentialProvider) DigestServerOption {
+	return func(s *DigestAuthServer) {
+		s.credentials = p
+	}
+}
+
+// WithDigestAlgorithms sets algorithms offered with challenge in order of preference.
+// Each algorithm is sent as separate WWW-Authenticate header (RFC 8760). Default is MD5
+func WithDigestAlgorithms(algorithms ...string) DigestServerOption {
+	return func(s *DigestAuthServer) {
+		s.algorithms = algorithms
+	}
+}
+
+// WithDigestQOP sets offered quality of protection, auth and/or auth-int.
+// With qop nonce can be reused until it expires, but nonce count must be increasing.
+// Without qop nonce can be used only once
+func WithDigestQOP(qop ...string) DigestServerOption {
+	return func(s *DigestAuthServer) {
+		s.qop = qop
+	}
+}
+
+// WithDigestProxyAuth challenges with 407 Proxy-Authenticate and reads Proxy-Authorization header
+func WithDigestProxyAuth() DigestServerOption {
+	return func(s *DigestAuthServer) {
+		s.proxy = true
+	}
+}
+
+// WithDigestNonceExpire sets lifetime of challenge nonce. It is used when DigestAuth Expire is not set.
+// Default is 5s
+func WithDigestNonceExpire(expire time.Duration) DigestServerOption {
+	return func(s *DigestAuthServer) {
+		s.expire = expire
+	}
 }
 
-func NewDigestServer() *DigestAuthServer {
+func NewDigestServer(opts ...DigestServerOption) *DigestAuthServer {
 	t := &DigestAuthServer{
-		cache: make(map[string]*digestChallengeEntry),
+		cache:      make(map[string]*digestChallengeEntry),
+		algorithms: []string{DigestAlgorithmMD5},
+	}
+	for _, o := range opts {
+		o(t)
 	}
 	return t
 }
@@ -57,76 +201,219 @@ func (s *DigestAuthServer) Close() {
 var (
 	ErrDigestAuthNoChallenge = errors.New("no challenge")
 	ErrDigestAuthBadCreds    = errors.New("bad credentials")
+	ErrDigestAuthUnknownUser = errors.New("unknown user")
+	ErrDigestAuthNoProvider  = errors.New("no credential provider")
 )
 
+// AuthIdentity is identity authenticated with digest authentication
+type AuthIdentity struct {
+	Username string
+	Realm    string
+}
+
 // AuthorizeRequest authorizes request. Returns SIP response that can be passed with error
 func (s *DigestAuthServer) AuthorizeRequest(req *sip.Request, auth DigestAuth) (res *sip.Response, err error) {
-	h := req.GetHeader("Authorization")
-	// https://www.rfc-editor.org/rfc/rfc2617#page-6
-
-	if h == nil {
-		nonce, err := generateNonce()
-		if err != nil {
-			return sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error", nil), err
+	if auth.Expire <= 0 {
+		auth.Expire = s.expire
+	}
+	_, res, err = s.authorize(req, auth, func(username string, realm string) (DigestCredentials, error) {
+		if username != auth.Username {
+			return DigestCredentials{}, ErrDigestAuthUnknownUser
 		}
+		return DigestCredentials{Password: auth.Password}, nil
+	})
+	return res, err
+}
 
-		e := &digestChallengeEntry{
-			Challenge: digest.Challenge{
-				Realm: auth.Realm,
-				Nonce: nonce,
-				// Opaque:    "sipgo",
-				Algorithm: "MD5",
-			},
-		}
+// Authorize authorizes request with credentials looked up from credential provider.
+// Username is read from Authorization header. Returns authenticated identity,
+// which is set only on 200 response, and SIP response that can be passed with error
+func (s *DigestAuthServer) Authorize(ctx context.Context, req *sip.Request, realm string) (identity AuthIdentity, res *sip.Response, err error) {
+	if s.credentials == nil {
+		return AuthIdentity{}, sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error", nil), ErrDigestAuthNoProvider
+	}
 
-		chal := &e.Challenge
+	return s.authorize(req, DigestAuth{Realm: realm, Expire: s.expire}, func(username string, realm string) (DigestCredentials, error) {
+		return s.credentials.DigestCredentials(ctx, username, realm)
+	})
+}
 
-		res := sip.NewResponseFromRequest(req, 401, "Unathorized", nil)
-		res.AppendHeader(sip.NewHeader("WWW-Authenticate", chal.String()))
+func (s *DigestAuthServer) authorize(req *sip.Request, auth DigestAuth, lookup func(username string, realm string) (DigestCredentials, error)) (AuthIdentity, *sip.Response, error) {
+	challenge := func(stale bool, err error) (AuthIdentity, *sip.Response, error) {
+		res, chalErr := s.challenge(req, auth, stale)
+		return AuthIdentity{}, res, errors.Join(err, chalErr)
+	}
 
-		s.mu.Lock()
-		s.cache[nonce] = e
-		s.mu.Unlock()
-		e.expireTimer = time.AfterFunc(auth.expire(), func() {
-			s.mu.Lock()
-			delete(s.cache, nonce)
-			s.mu.Unlock()
-		})
+	h := req.GetHeader(s.credentialsHeader())
+	// https://www.rfc-editor.org/rfc/rfc2617#page-6
 
-		return res, nil
+	if h == nil {
+		return challenge(false, nil)
 	}
 
 	cred, err := digest.ParseCredentials(h.Value())
 	if err != nil {
-		return sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil), err
+		return AuthIdentity{}, sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil), err
+	}
+	cred.Algorithm = sip.ASCIIToUpper(cred.Algorithm)
+	// Response must not be replayed against other target
+	if !digestURIMatch(cred.URI, req.Recipient) {
+		return AuthIdentity{}, sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - Digest URI mismatch", nil), ErrDigestAuthBadCreds
 	}
 
 	s.mu.Lock()
 	e, exists := s.cache[cred.Nonce]
 	s.mu.Unlock()
+
+	if cred.Realm != auth.Realm {
+		return challenge(false, ErrDigestAuthBadCreds)
+	}
+	if exists && (!slices.Contains(e.algorithms, cmp.Or(cred.Algorithm, DigestAlgorithmMD5)) || !digestQOPOffered(e.qop, cred.QOP)) {
+		return challenge(false, ErrDigestAuthBadCreds)
+	}
+
+	creds, err := lookup(cred.Username, cred.Realm)
+	if err != nil {
+		if errors.Is(err, ErrDigestAuthUnknownUser) {
+			return challenge(false, errors.Join(ErrDigestAuthBadCreds, err))
+		}
+		return AuthIdentity{}, sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error", nil), err
+	}
+
+	response, err := digestResponse(req, cred, creds)
+	if err != nil {
+		// Mostly due to unsupported digest alg
+		return AuthIdentity{}, sip.NewResponseFromRequest(req, sip.StatusForbidden, "Forbidden", nil), err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(cred.Response), []byte(response)) != 1 {
+		return challenge(false, ErrDigestAuthBadCreds)
+	}
+
+	// Credentials are valid, but nonce is expired or replayed. Client should retry with new nonce
 	if !exists {
-		return sip.NewResponseFromRequest(req, sip.StatusUnauthorized, "Unauthorized", nil), ErrDigestAuthNoChallenge
+		return challenge(true, nil)
+	}
+
+	s.mu.Lock()
+	replay := false
+	if len(e.qop) == 0 {
+		replay = e.used
+		e.used = true
+	} else {
+		replay = cred.Nc <= e.nc
+		e.nc = max(e.nc, cred.Nc)
+	}
+	s.mu.Unlock()
+	if replay {
+		return challenge(true, nil)
+	}
+
+	return AuthIdentity{Username: cred.Username, Realm: cred.Realm}, sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil), nil
+}
+
+// digestURIMatch checks is digest uri same as Request-URI. Parameters are ignored
+func digestURIMatch(uri string, recipient sip.Uri) bool {
+	u := sip.Uri{}
+	if err := sip.ParseUri(uri, &u); err != nil {
+		return false
+	}
+	return u.User == recipient.User && strings.EqualFold(u.Addr(), recipient.Addr())
+}
+
+// challenge creates 401 response with challenge for each offered algorithm
+func (s *DigestAuthServer) challenge(req *sip.Request, auth DigestAuth, stale bool) (*sip.Response, error) {
+	nonce, err := generateNonce()
+	if err != nil {
+		return sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error", nil), err
+	}
+
+	e := &digestChallengeEntry{
+		algorithms: s.algorithms,
+		qop:        s.qop,
+	}
+
+	res := sip.NewResponseFromRequest(req, 401, "Unathorized", nil)
+	chalHeader := "WWW-Authenticate"
+	if s.proxy {
+		res = sip.NewResponseFromRequest(req, sip.StatusProxyAuthRequired, "Proxy Authentication Required", nil)
+		chalHeader = "Proxy-Authenticate"
+	}
+	for _, alg := range s.algorithms {
+		chal := digest.Challenge{
+			Realm: auth.Realm,
+			Nonce: nonce,
+			// Opaque:    "sipgo",
+			Algorithm: alg,
+			QOP:       s.qop,
+			Stale:     stale,
+		}
+		res.AppendHeader(sip.NewHeader(chalHeader, chal.String()))
+	}
+
+	s.mu.Lock()
+	s.cache[nonce] = e
+	e.expireTimer = time.AfterFunc(auth.expire(), func() {
+		s.mu.Lock()
+		delete(s.cache, nonce)
+		s.mu.Unlock()
+	})
+	s.mu.Unlock()
+
+	return res, nil
+}
+
+func (s *DigestAuthServer) credentialsHeader() string {
+	if s.proxy {
+		return "Proxy-Authorization"
+	}
+	return "Authorization"
+}
+
+func digestQOPOffered(offered []string, qop string) bool {
+	if len(offered) == 0 {
+		return qop == ""
+	}
+	return slices.Contains(offered, qop)
+}
+
+// digestResponse computes expected response of received credentials
+func digestResponse(req *sip.Request, cred *digest.Credentials, creds DigestCredentials) (string, error) {
+	a1, err := creds.ha1(cmp.Or(cred.Algorithm, DigestAlgorithmMD5))
+	if err != nil {
+		return "", err
+	}
+
+	chal := &digest.Challenge{
+		Realm:     cred.Realm,
+		Nonce:     cred.Nonce,
+		Algorithm: cred.Algorithm,
+		Opaque:    cred.Opaque,
+	}
+	if cred.QOP != "" {
+		chal.QOP = []string{cred.QOP}
 	}
-	chal := &e.Challenge
 
-	// Make digest and compare response
 	digCred, err := digest.Digest(chal, digest.Options{
 		Method:   req.Method.String(),
 		URI:      cred.URI,
-		Username: auth.Username,
-		Password: auth.Password,
+		GetBody:  digestGetBody(req),
+		Count:    cred.Nc,
+		Cnonce:   cred.Cnonce,
+		Username: cred.Username,
+		Password: creds.Password,
+		A1:       a1,
 	})
-
 	if err != nil {
-		// Mostly due to unsupported digest alg
-		return sip.NewResponseFromRequest(req, sip.StatusForbidden, "Forbidden", nil), err
+		return "", err
 	}
+	return digCred.Response, nil
+}
 
-	if cred.Response != digCred.Response {
-		return sip.NewResponseFromRequest(req, sip.StatusUnauthorized, "Unauthorized", nil), ErrDigestAuthBadCreds
+func digestGetBody(req *sip.Request) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(req.Body())), nil
 	}
-
-	return sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil), nil
 }
 
 func (s *DigestAuthServer) AuthorizeDialog(d *DialogServerSession, auth DigestAuth) error {
@@ -153,3 +440,81 @@ func generateNonce() (string, error) {
 
 	return base64.URLEncoding.EncodeToString(nonceBytes), nil
 }
+
+// digestAuthApply answers digest challenge of 401 or 407 response.
+// Topmost challenge with supported algorithm is used (RFC 8760)
+func digestAuthApply(req *sip.Request, res *sip.Response, auth sipgo.DigestAuth) error {
+	chalHeader, credHeader := "WWW-Authenticate", "Authorization"
+	if res.StatusCode == sip.StatusProxyAuthRequired {
+		chalHeader, credHeader = "Proxy-Authenticate", "Proxy-Authorization"
+	}
+
+	var chal *digest.Challenge
+	for _, h := range res.GetHeaders(chalHeader) {
+		c, err := digest.ParseChallenge(h.Value())
+		if err != nil {
+			continue
+		}
+		// Fix lower case algorithm although not supported by rfc
+		c.Algorithm = sip.ASCIIToUpper(c.Algorithm)
+		if digest.CanDigest(c) {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("no supported %s challenge present", chalHeader)
+	}
+
+	cred, err := digest.Digest(chal, digest.Options{
+		Method:   req.Method.String(),
+		URI:      req.Recipient.Addr(),
+		GetBody:  digestGetBody(req),
+		Username: auth.Username,
+		Password: auth.Password,
+	})
+	if err != nil {
+		return fmt.Errorf("fail to build digest: %w", err)
+	}
+
+	req.RemoveHeader(credHeader)
+	req.AppendHeader(sip.NewHeader(credHeader, cred.String()))
+	return nil
+}
+
+// digestAuthStale checks is response challenge with stale nonce, meaning credentials were valid
+func digestAuthStale(res *sip.Response) bool {
+	if res.StatusCode != sip.StatusUnauthorized && res.StatusCode != sip.StatusProxyAuthRequired {
+		return false
+	}
+	for _, name := range []string{"WWW-Authenticate", "Proxy-Authenticate"} {
+		for _, h := range res.GetHeaders(name) {
+			if c, err := digest.ParseChallenge(h.Value()); err == nil && c.Stale {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// digestAuthDo resends request with digest authorization after 401 or 407 response.
+// It is resent once more if server responds with stale nonce
+func digestAuthDo(ctx context.Context, client *sipgo.Client, req *sip.Request, res *sip.Response, auth sipgo.DigestAuth) (*sip.Response, error) {
+	for i := 0; i < 2; i++ {
+		if err := digestAuthApply(req, res, auth); err != nil {
+			return nil, err
+		}
+
+		requestNewTransaction(req)
+		var err error
+		res, err = client.Do(ctx, req, sipgo.ClientRequestAddVia)
+		if err != nil {
+			return nil, err
+		}
+
+		if !digestAuthStale(res) {
+			break
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/icholy/digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestAuthServerCredentialProvider(t *testing.T) {
+	ctx := context.Background()
+	provider := DigestCredentialProviderFunc(func(ctx context.Context, username string, realm string) (DigestCredentials, error) {
+		switch username {
+		case "1001":
+			return DigestCredentials{Password: "secret"}, nil
+		case "1002":
+			return DigestCredentials{HA1: DigestHA1("1002", realm, "secret2")}, nil
+		}
+		return DigestCredentials{}, ErrDigestAuthUnknownUser
+	})
+	server := NewDigestServer(WithDigestCredentialProvider(provider))
+	defer server.Close()
+
+	authorize := func(username string, password string) (AuthIdentity, *sip.Response, error) {
+		req := sip.NewRequest(sip.INVITE, sip.Uri{User: "bob", Host: "example.com"})
+		_, res, err := server.Authorize(ctx, req, "test")
+		require.NoError(t, err)
+		require.Equal(t, sip.StatusUnauthorized, res.StatusCode)
+
+		chal, err := digest.ParseChallenge(res.GetHeader("WWW-Authenticate").Value())
+		require.NoError(t, err)
+		assert.Equal(t, "test", chal.Realm)
+
+		cred, err := digest.Digest(chal, digest.Options{
+			Method:   req.Method.String(),
+			URI:      req.Recipient.Addr(),
+			Username: username,
+			Password: password,
+		})
+		require.NoError(t, err)
+		req.AppendHeader(sip.NewHeader("Authorization", cred.String()))
+		return server.Authorize(ctx, req, "test")
+	}
+
+	identity, res, err := authorize("1001", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, sip.StatusOK, res.StatusCode)
+	assert.Equal(t, AuthIdentity{Username: "1001", Realm: "test"}, identity)
+
+	identity, res, err = authorize("1002", "secret2")
+	require.NoError(t, err)
+	assert.Equal(t, sip.StatusOK, res.StatusCode)
+	assert.Equal(t, "1002", identity.Username)
+
+	identity, res, err = authorize("1001", "wrong")
+	assert.ErrorIs(t, err, ErrDigestAuthBadCreds)
+	assert.Equal(t, sip.StatusUnauthorized, res.StatusCode)
+	assert.Empty(t, identity)
+
+	_, res, err = authorize("1003", "secret")
+	assert.ErrorIs(t, err, ErrDigestAuthUnknownUser)
+	assert.Equal(t, sip.StatusUnauthorized, res.StatusCode)
+
+	_, _, err = NewDigestServer().Authorize(ctx, sip.NewRequest(sip.INVITE, sip.Uri{User: "bob", Host: "example.com"}), "test")
+	assert.ErrorIs(t, err, ErrDigestAuthNoProvider)
+}
+
+func TestDigestAuthServerAlgorithms(t *testing.T) {
+	ctx := context.Background()
+	ha1, err := DigestHA1Algorithm(DigestAlgorithmSHA256, "1001", "test", "secret")
+	require.NoError(t, err)
+	provider := DigestCredentialProviderFunc(func(ctx context.Context, username string, realm string) (DigestCredentials, error) {
+		return DigestCredentials{HA1SHA256: ha1}, nil
+	})
+	server := NewDigestServer(
+		WithDigestCredentialProvider(provider),
+		WithDigestAlgorithms(DigestAlgorithmSHA256, DigestAlgorithmMD5),
+		WithDigestQOP("auth-int"),
+	)
+	defer server.Close()
+
+	req := sip.NewRequest(sip.MESSAGE, sip.Uri{User: "bob", Host: "example.com"})
+	req.SetBody([]byte("hello"))
+	_, res, err := server.Authorize(ctx, req, "test")
+	require.NoError(t, err)
+	require.Equal(t, sip.StatusUnauthorized, res.StatusCode)
+	chals := res.GetHeaders("WWW-Authenticate")
+	require.Len(t, chals, 2)
+	assert.Contains(t, chals[0].Value(), "algorithm=SHA-256")
+	assert.Contains(t, chals[1].Value(), "algorithm=MD5")
+
+	// Topmost supported challenge is answered
+	require.NoError(t, digestAuthApply(req, res, sipgo.DigestAuth{Username: "1001", Password: "secret"}))
+	cred, err := digest.ParseCredentials(req.GetHeader("Authorization").Value())
+	require.NoError(t, err)
+	assert.Equal(t, DigestAlgorithmSHA256, cred.Algorithm)
+	assert.Equal(t, "auth-int", cred.QOP)
+
+	t.Run("BodyIntegrity", func(t *testing.T) {
+		tampered := req.Clone()
+		tampered.SetBody([]byte("tampered"))
+		_, res, err := server.Authorize(ctx, tampered, "test")
+		assert.ErrorIs(t, err, ErrDigestAuthBadCreds)
+		assert.Equal(t, sip.StatusUnauthorized, res.StatusCode)
+	})
+
+	_, res, err = server.Authorize(ctx, req, "test")
+	require.NoError(t, err)
+	require.Equal(t, sip.StatusOK, res.StatusCode)
+
+	t.Run("ReplayStale", func(t *testing.T) {
+		_, res, err := server.Authorize(ctx, req, "test")
+		require.NoError(t, err)
+		require.Equal(t, sip.StatusUnauthorized, res.StatusCode)
+		assert.True(t, digestAuthStale(res))
+	})
+
+	t.Run("NonceCount", func(t *testing.T) {
+		chal := &digest.Challenge{Realm: cred.Realm, Nonce: cred.Nonce, Algorithm: cred.Algorithm, QOP: []string{cred.QOP}}
+		next, err := digest.Digest(chal, digest.Options{
+			Method:   req.Method.String(),
+			URI:      cred.URI,
+			GetBody:  digestGetBody(req),
+			Count:    cred.Nc + 1,
+			Username: "1001",
+			Password: "secret",
+		})
+		require.NoError(t, err)
+		req.ReplaceHeader(sip.NewHeader("Authorization", next.String()))
+		_, res, err := server.Authorize(ctx, req, "test")
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusOK, res.StatusCode)
+	})
+}
+
+func TestIntegrationDigestAuth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("server"))
+		defer ua.Close()
+
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15119,
+			},
+		))
+
+		authServer := NewDigestServer(
+			WithDigestAlgorithms(DigestAlgorithmSHA512256, DigestAlgorithmSHA256),
+			WithDigestQOP("auth"),
+		)
+		defer authServer.Close()
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			if err := authServer.AuthorizeDialog(d, DigestAuth{Username: "alice", Password: "secret"}); err != nil {
+				return
+			}
+			if err := d.Answer(); err != nil {
+				return
+			}
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	recipient := sip.Uri{User: "bob", Host: "127.0.0.1", Port: 15119}
+	d, err := dg.Invite(ctx, recipient, InviteOptions{Username: "alice", Password: "secret"})
+	require.NoError(t, err)
+	defer d.Close()
+
+	cred, err := digest.ParseCredentials(d.InviteRequest.GetHeader("Authorization").Value())
+	require.NoError(t, err)
+	assert.Equal(t, DigestAlgorithmSHA512256, cred.Algorithm)
+	assert.Equal(t, "auth", cred.QOP)
+	require.NoError(t, d.Hangup(ctx))
+
+	_, err = dg.Invite(ctx, recipient, InviteOptions{Username: "alice", Password: "wrong"})
+	require.Error(t, err)
+}
+
+func TestDigestAuthServerNonceExpire(t *testing.T) {
+	ctx := context.Background()
+	provider := DigestCredentialProviderFunc(func(ctx context.Context, username string, realm string) (DigestCredentials, error) {
+		return DigestCredentials{Password: "secret"}, nil
+	})
+	server := NewDigestServer(WithDigestCredentialProvider(provider), WithDigestNonceExpire(50*time.Millisecond))
+	defer server.Close()
+
+	req := sip.NewRequest(sip.INVITE, sip.Uri{User: "bob", Host: "example.com"})
+	_, res, err := server.Authorize(ctx, req, "test")
+	require.NoError(t, err)
+	require.NoError(t, digestAuthApply(req, res, sipgo.DigestAuth{Username: "1001", Password: "secret"}))
+
+	// Valid credentials with expired nonce are challenged as stale
+	time.Sleep(100 * time.Millisecond)
+	_, res, err = server.Authorize(ctx, req, "test")
+	require.NoError(t, err)
+	assert.Equal(t, sip.StatusUnauthorized, res.StatusCode)
+	assert.True(t, digestAuthStale(res))
+}
+
+func TestDigestAuthServerURIMismatch(t *testing.T) {
+	ctx := context.Background()
+	provider := DigestCredentialProviderFunc(func(ctx context.Context, username string, realm string) (DigestCredentials, error) {
+		return DigestCredentials{Password: "secret"}, nil
+	})
+	server := NewDigestServer(WithDigestCredentialProvider(provider))
+	defer server.Close()
+
+	req := sip.NewRequest(sip.INVITE, sip.Uri{User: "bob", Host: "example.com"})
+	_, res, err := server.Authorize(ctx, req, "test")
+	require.NoError(t, err)
+	require.NoError(t, digestAuthApply(req, res, sipgo.DigestAuth{Username: "1001", Password: "secret"}))
+
+	// Captured credentials are replayed against other target
+	other := sip.NewRequest(sip.INVITE, sip.Uri{User: "alice", Host: "example.com"})
+	other.AppendHeader(sip.NewHeader("Authorization", req.GetHeader("Authorization").Value()))
+	_, res, err = server.Authorize(ctx, other, "test")
+	require.ErrorIs(t, err, ErrDigestAuthBadCreds)
+	assert.Equal(t, sip.StatusBadRequest, res.StatusCode)
+
+	// Request-URI parameters are not part of comparison
+	req.Recipient.UriParams = sip.NewParams()
+	req.Recipient.UriParams.Add("transport", "udp")
+	identity, res, err := server.Authorize(ctx, req, "test")
+	require.NoError(t, err)
+	assert.Equal(t, sip.StatusOK, res.StatusCode)
+	assert.Equal(t, "1001", identity.Username)
+}
@@ -2,3 +2,117 @@
 // SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
 
 package diago
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emiago/diago/media"
+)
+
+// DTMFMode defines how DTMFWriter sends digits
+type DTMFMode int
+
+const (
+	// DTMFModeRFC2833 sends DTMF as RTP telephone-event (RFC 4733). This is default
+	DTMFModeRFC2833 DTMFMode = iota
+	// DTMFModeSIPInfo sends DTMF as SIP INFO application/dtmf-relay request within dialog
+	DTMFModeSIPInfo
+	// DTMFModeAuto sends RTP telephone-event if it is negotiated, otherwise it fallbacks to SIP INFO
+	DTMFModeAuto
+)
+
+const (
+	contentTypeDTMFRelay = "application/dtmf-relay"
+	contentTypeDTMF      = "application/dtmf"
+
+	sipInfoDTMFDuration = 160 * time.Millisecond
+)
+
+// sipInfoDTMFContentType checks is content type carrying DTMF and returns it without parameters
+func sipInfoDTMFContentType(contentType string) (string, bool) {
+	ct, _, _ := strings.Cut(contentType, ";")
+	ct = strings.ToLower(strings.TrimSpace(ct))
+	switch ct {
+	case contentTypeDTMFRelay, contentTypeDTMF:
+		return ct, true
+	}
+	return ct, false
+}
+
+// sipInfoDTMFParse parses SIP INFO body carrying DTMF.
+//
+// application/dtmf-relay:
+//
+//	Signal=8
+//	Duration=120
+//
+// application/dtmf body is only digit
+func sipInfoDTMFParse(contentType string, body []byte) (dtmf rune, dur time.Duration, err error) {
+	ct, ok := sipInfoDTMFContentType(contentType)
+	if !ok {
+		return 0, 0, fmt.Errorf("content type %q is not DTMF", contentType)
+	}
+
+	if ct == contentTypeDTMF {
+		dtmf, err := sipInfoDTMFSignal(string(bytes.TrimSpace(body)))
+		return dtmf, 0, err
+	}
+
+	signal := ""
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		key, val, found := strings.Cut(scanner.Text(), "=")
+		if !found {
+			continue
+		}
+		val = strings.TrimSpace(val)
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "signal":
+			signal = val
+		case "duration":
+			ms, err := strconv.Atoi(val)
+			if err != nil {
+				return 0, 0, fmt.Errorf("bad DTMF duration %q: %w", val, err)
+			}
+			dur = time.Duration(ms) * time.Millisecond
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	if signal == "" {
+		return 0, 0, fmt.Errorf("no Signal present in DTMF body")
+	}
+	dtmf, err = sipInfoDTMFSignal(signal)
+	return dtmf, dur, err
+}
+
+func sipInfoDTMFSignal(signal string) (rune, error) {
+	if len(signal) == 1 {
+		r := rune(strings.ToUpper(signal)[0])
+		switch {
+		case r >= '0' && r <= '9', r == '*', r == '#', r >= 'A' && r <= 'D':
+			return r, nil
+		}
+		return 0, fmt.Errorf("unsupported DTMF signal %q", signal)
+	}
+
+	// Some endpoints send events as RFC 4733 numbers. Ex 10 is *, 11 is #
+	ev, err := strconv.Atoi(signal)
+	if err != nil || ev < 10 || ev > 15 {
+		return 0, fmt.Errorf("unsupported DTMF signal %q", signal)
+	}
+	return media.DTMFToRune(uint8(ev)), nil
+}
+
+// sipInfoDTMFBody creates application/dtmf-relay body
+func sipInfoDTMFBody(dtmf rune, dur time.Duration) []byte {
+	return fmt.Appendf(nil, "Signal=%c\r\nDuration=%d\r\n", dtmf, dur.Milliseconds())
+}
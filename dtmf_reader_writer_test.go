@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emiago/diago/media"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSIPInfoDTMFParse(t *testing.T) {
+	tcs := []struct {
+		contentType string
+		body        string
+		dtmf        rune
+		dur         time.Duration
+	}{
+		{"application/dtmf-relay", "Signal=8\r\nDuration=120\r\n", '8', 120 * time.Millisecond},
+		{"application/dtmf-relay", "Signal= #\nDuration= 250", '#', 250 * time.Millisecond},
+		{"application/dtmf-relay", "Signal=10\r\n", '*', 0},
+		{"Application/DTMF-Relay; charset=utf-8", "signal=a", 'A', 0},
+		{"application/dtmf", "5\r\n", '5', 0},
+	}
+
+	for _, tc := range tcs {
+		dtmf, dur, err := sipInfoDTMFParse(tc.contentType, []byte(tc.body))
+		require.NoError(t, err, tc.body)
+		assert.Equal(t, string(tc.dtmf), string(dtmf))
+		assert.Equal(t, tc.dur, dur)
+	}
+
+	for _, body := range []string{"", "Duration=100", "Signal=X", "Signal=16", "Signal=1\r\nDuration=abc"} {
+		_, _, err := sipInfoDTMFParse("application/dtmf-relay", []byte(body))
+		assert.Error(t, err, body)
+	}
+
+	_, _, err := sipInfoDTMFParse("application/sdp", []byte("Signal=1"))
+	assert.Error(t, err)
+
+	dtmf, dur, err := sipInfoDTMFParse(contentTypeDTMFRelay, sipInfoDTMFBody('9', sipInfoDTMFDuration))
+	require.NoError(t, err)
+	assert.Equal(t, '9', dtmf)
+	assert.Equal(t, sipInfoDTMFDuration, dur)
+}
+
+func TestIntegrationSIPInfoDTMF(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dtmfCh := make(chan rune, 10)
+	listenErr := make(chan error, 1)
+	audioRead := make(chan error, 1)
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("server"))
+		defer ua.Close()
+
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15100,
+			},
+		))
+
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			if err := d.Answer(); err != nil {
+				t.Log("Answer failed", err)
+				return
+			}
+
+			reader, err := d.AudioReaderDTMF()
+			if err != nil {
+				t.Log("DTMF reader failed", err)
+				return
+			}
+			if d.ToUser() == "error" {
+				// Hook error stops listening, but audio can still be read
+				listenErr <- reader.Listen(func(dtmf rune) error {
+					return errors.New("hook failed")
+				}, 5*time.Second)
+				r, err := d.AudioReader()
+				if err == nil {
+					_, err = r.Read(make([]byte, media.RTPBufSize))
+				}
+				audioRead <- err
+				<-d.Context().Done()
+				return
+			}
+
+			reader.Listen(func(dtmf rune) error {
+				dtmfCh <- dtmf
+				return nil
+			}, 5*time.Second)
+		})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	dialog, err := dg.Invite(ctx, sip.Uri{User: "dtmf", Host: "127.0.0.1", Port: 15100}, InviteOptions{})
+	require.NoError(t, err)
+	defer dialog.Close()
+
+	writer, err := dialog.AudioWriterDTMF()
+	require.NoError(t, err)
+	writer.Mode = DTMFModeSIPInfo
+
+	// No audio is sent. SIP INFO DTMF must be delivered without RTP
+	for _, dtmf := range "12#" {
+		require.NoError(t, writer.WriteDTMF(dtmf))
+	}
+
+	for _, expected := range "12#" {
+		select {
+		case dtmf := <-dtmfCh:
+			assert.Equal(t, string(expected), string(dtmf))
+		case <-time.After(3 * time.Second):
+			t.Fatal("DTMF not received")
+		}
+	}
+	dialog.Hangup(ctx)
+
+	t.Run("HookError", func(t *testing.T) {
+		dialog, err := dg.Invite(ctx, sip.Uri{User: "error", Host: "127.0.0.1", Port: 15100}, InviteOptions{})
+		require.NoError(t, err)
+		defer dialog.Close()
+
+		writer, err := dialog.AudioWriterDTMF()
+		require.NoError(t, err)
+		writer.Mode = DTMFModeSIPInfo
+		require.NoError(t, writer.WriteDTMF('1'))
+
+		// Audio is sent so that listening returns after current read
+		w, err := dialog.AudioWriter()
+		require.NoError(t, err)
+		writeCtx, stopWrite := context.WithCancel(ctx)
+		defer stopWrite()
+		go func() {
+			payload := make([]byte, media.CodecAudioUlaw.SampleTimestamp())
+			for writeCtx.Err() == nil {
+				if _, err := w.Write(payload); err != nil {
+					return
+				}
+			}
+		}()
+
+		select {
+		case err := <-listenErr:
+			require.Error(t, err)
+		case <-time.After(3 * time.Second):
+			t.Fatal("listen did not return hook error")
+		}
+		select {
+		case err := <-audioRead:
+			require.NoError(t, err)
+		case <-time.After(3 * time.Second):
+			t.Fatal("audio not read after listen")
+		}
+		stopWrite()
+		dialog.Hangup(ctx)
+	})
+}
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// Redirect responds with 302 Moved Temporarily with contacts as redirect targets.
+// When multiple contacts are passed, q-values are decreasing in listed order.
+// Dialog is ended and hangup after handler does nothing.
+func (d *DialogServerSession) Redirect(contacts ...sip.Uri) error {
+	if len(contacts) == 0 {
+		return fmt.Errorf("redirect: no contacts")
+	}
+
+	headers := make([]sip.Header, len(contacts))
+	for i, c := range contacts {
+		h := &sip.ContactHeader{
+			Address: *c.Clone(),
+			Params:  sip.NewParams(),
+		}
+		if len(contacts) > 1 {
+			h.Params.Add("q", redirectQValue(i))
+		}
+		headers[i] = h
+	}
+	return d.Reject(sip.StatusMovedTemporarily, "Moved Temporarily", headers...)
+}
+
+// redirectQValue returns q-value for contact at index, from 1.0 down to 0.1
+func redirectQValue(i int) string {
+	q := max(10-i, 1)
+	if q == 10 {
+		return "1.0"
+	}
+	return "0." + strconv.Itoa(q)
+}
+
+// Reject responds with final non 2xx response, ex. 486 Busy Here, 480 Temporarily Unavailable or 603 Decline.
+// Headers like Reason, Retry-After or Warning are added to response.
+// Dialog is ended and hangup after handler does nothing.
+func (d *DialogServerSession) Reject(code int, reason string, headers ...sip.Header) error {
+	if code < 300 || code > 699 {
+		return fmt.Errorf("reject: invalid final response code %d", code)
+	}
+	if d.LoadState() >= sip.DialogStateEstablished {
+		return fmt.Errorf("reject: dialog is already answered or ended")
+	}
+	return d.Respond(code, reason, nil, headers...)
+}
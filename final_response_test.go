@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationRejectRedirect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handlerErrs := make(chan error, 1)
+	{
+		ua, _ := sipgo.NewUA()
+		defer ua.Close()
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15134,
+			},
+		))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			var err error
+			switch d.ToUser() {
+			case "redirect":
+				err = d.Redirect(
+					sip.Uri{User: "alice", Host: "127.0.0.1", Port: 5060},
+					sip.Uri{User: "bob", Host: "127.0.0.1", Port: 5060},
+				)
+			default:
+				err = d.Reject(sip.StatusBusyHere, "Busy Here",
+					ReasonQ850(Q850UserBusy, "User busy").Header(),
+					sip.NewHeader("Retry-After", "30"),
+				)
+			}
+			if err != nil {
+				handlerErrs <- err
+				return
+			}
+
+			// Dialog can not be answered anymore and hangup does nothing
+			if err := d.Reject(603, "Decline"); err == nil {
+				handlerErrs <- assert.AnError
+				return
+			}
+			handlerErrs <- d.Hangup(ctx)
+		})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	waitHandler := func(t *testing.T) {
+		select {
+		case err := <-handlerErrs:
+			require.NoError(t, err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("handler did not finish")
+		}
+	}
+
+	t.Run("Reject", func(t *testing.T) {
+		_, err := dg.Invite(ctx, sip.Uri{User: "busy", Host: "127.0.0.1", Port: 15134}, InviteOptions{})
+		require.Error(t, err)
+		res := dialogErrorResponse(err)
+		require.NotNil(t, res)
+		assert.Equal(t, sip.StatusBusyHere, res.StatusCode)
+		assert.Equal(t, "30", res.GetHeader("Retry-After").Value())
+
+		r, ok := ReasonFromError(err)
+		require.True(t, ok)
+		assert.Equal(t, Q850UserBusy, r.Cause)
+		waitHandler(t)
+	})
+
+	t.Run("Redirect", func(t *testing.T) {
+		_, err := dg.Invite(ctx, sip.Uri{User: "redirect", Host: "127.0.0.1", Port: 15134}, InviteOptions{})
+		require.Error(t, err)
+		res := dialogErrorResponse(err)
+		require.NotNil(t, res)
+		assert.Equal(t, sip.StatusMovedTemporarily, res.StatusCode)
+
+		contacts := res.GetHeaders("Contact")
+		require.Len(t, contacts, 2)
+		alice := contacts[0].(*sip.ContactHeader)
+		assert.Equal(t, "alice", alice.Address.User)
+		q, _ := alice.Params.Get("q")
+		assert.Equal(t, "1.0", q)
+		bob := contacts[1].(*sip.ContactHeader)
+		assert.Equal(t, "bob", bob.Address.User)
+		q, _ = bob.Params.Get("q")
+		assert.Equal(t, "0.9", q)
+		waitHandler(t)
+	})
+}
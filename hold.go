@@ -0,0 +1,387 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/emiago/diago/audio"
+	"github.com/emiago/diago/media"
+	"github.com/emiago/diago/media/sdp"
+)
+
+// HoldState is hold state of dialog derived from negotiated media direction
+type HoldState int
+
+const (
+	HoldStateNone HoldState = iota
+	// HoldStateLocal is when we hold remote, ex. calling Hold
+	HoldStateLocal
+	// HoldStateRemote is when remote holds us with sendonly or inactive offer
+	HoldStateRemote
+)
+
+func (s HoldState) String() string {
+	switch s {
+	case HoldStateLocal:
+		return "local"
+	case HoldStateRemote:
+		return "remote"
+	}
+	return "none"
+}
+
+// HoldEvent is emitted when hold state of dialog changes
+type HoldEvent int
+
+const (
+	HoldEventLocalHold HoldEvent = iota + 1
+	HoldEventRemoteHold
+	HoldEventResumed
+)
+
+func (e HoldEvent) String() string {
+	switch e {
+	case HoldEventLocalHold:
+		return "local_hold"
+	case HoldEventRemoteHold:
+		return "remote_hold"
+	case HoldEventResumed:
+		return "resumed"
+	}
+	return ""
+}
+
+// mediaHoldState checks our preferred mode for local hold and negotiated mode for remote hold
+func mediaHoldState(m *media.MediaSession) HoldState {
+	switch m.Mode {
+	case sdp.ModeSendonly, sdp.ModeInactive:
+		return HoldStateLocal
+	}
+
+	switch m.NegotiatedMode() {
+	case sdp.ModeRecvonly, sdp.ModeInactive:
+		return HoldStateRemote
+	}
+	return HoldStateNone
+}
+
+// OnHold registers hook called when hold state changes with re-INVITE or UPDATE.
+// It is called from SIP handling after response is sent, so it should not block
+func (d *DialogMedia) OnHold(f func(ev HoldEvent)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.onHold != nil {
+		prev := d.onHold
+		d.onHold = func(ev HoldEvent) {
+			prev(ev)
+			f(ev)
+		}
+		return
+	}
+	d.onHold = f
+}
+
+// HoldState returns current hold state of dialog
+func (d *DialogMedia) HoldState() HoldState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.holdState
+}
+
+// holdUpdate must be called after media session update. It emits hold event if state changed
+func (d *DialogMedia) holdUpdate() {
+	d.mu.Lock()
+	if d.mediaSession == nil {
+		d.mu.Unlock()
+		return
+	}
+	state := mediaHoldState(d.mediaSession)
+	prev := d.holdState
+	d.holdState = state
+	onHold := d.onHold
+	d.mu.Unlock()
+
+	if state == prev || onHold == nil {
+		return
+	}
+
+	switch state {
+	case HoldStateLocal:
+		onHold(HoldEventLocalHold)
+	case HoldStateRemote:
+		onHold(HoldEventRemoteHold)
+	default:
+		onHold(HoldEventResumed)
+	}
+}
+
+// MusicOnHold is audio played by bridge into other parties while participant holds call.
+// Audio must match codec sample rate and channels
+type MusicOnHold struct {
+	// Files is playlist of WAV files played in loop
+	Files []string
+	// Reader is 16 bit PCM audio used when no files are set. It is looped if it implements io.Seeker.
+	// As reader can not be shared, use it only with single bridge
+	Reader io.Reader
+}
+
+// NewMusicOnHold creates music on hold from WAV playlist
+func NewMusicOnHold(files ...string) *MusicOnHold {
+	return &MusicOnHold{Files: files}
+}
+
+// pcmReader returns reader producing single 16 bit PCM frame of codec per read
+func (m *MusicOnHold) pcmReader(codec media.Codec) *musicOnHoldReader {
+	return &musicOnHoldReader{
+		moh:       m,
+		codec:     codec,
+		frameSize: codec.SamplesPCM(16),
+	}
+}
+
+type musicOnHoldReader struct {
+	moh       *MusicOnHold
+	codec     media.Codec
+	frameSize int
+
+	cur     io.Reader
+	file    *os.File
+	next    int
+	started bool
+	// empty counts sources opened in row without any audio, to stop looping
+	empty int
+	read  bool
+}
+
+func (r *musicOnHoldReader) Read(b []byte) (int, error) {
+	frame := b[:min(len(b), r.frameSize)]
+	n := 0
+	for n < len(frame) {
+		if r.cur == nil {
+			if err := r.open(); err != nil {
+				if n > 0 {
+					return n, nil
+				}
+				return 0, err
+			}
+		}
+
+		m, err := r.cur.Read(frame[n:])
+		n += m
+		if m > 0 {
+			r.read = true
+		}
+		if errors.Is(err, io.EOF) {
+			r.closeCurrent()
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// open opens next source of playlist
+func (r *musicOnHoldReader) open() error {
+	if r.started {
+		if r.read {
+			r.empty = 0
+		} else {
+			r.empty++
+		}
+	}
+	r.started, r.read = true, false
+
+	if len(r.moh.Files) == 0 {
+		if r.moh.Reader == nil || r.empty > 0 {
+			return io.EOF
+		}
+		if r.next > 0 {
+			s, ok := r.moh.Reader.(io.Seeker)
+			if !ok {
+				return io.EOF
+			}
+			if _, err := s.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		r.next++
+		r.cur = r.moh.Reader
+		return nil
+	}
+
+	if r.empty >= len(r.moh.Files) {
+		return io.EOF
+	}
+	filename := r.moh.Files[r.next%len(r.moh.Files)]
+	r.next++
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	wavReader := audio.NewWavReader(bufio.NewReaderSize(file, 64*1024))
+	if err := wavReader.ReadHeaders(); err != nil {
+		file.Close()
+		return err
+	}
+	if wavReader.BitsPerSample != 16 || wavReader.SampleRate != r.codec.SampleRate || wavReader.NumChannels != uint16(r.codec.NumChannels) {
+		file.Close()
+		return fmt.Errorf("music on hold file %q does not match codec %s", filename, r.codec.Name)
+	}
+	r.file = file
+	r.cur = wavReader
+	return nil
+}
+
+func (r *musicOnHoldReader) closeCurrent() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	r.cur = nil
+}
+
+func (r *musicOnHoldReader) Close() error {
+	r.closeCurrent()
+	return nil
+}
+
+// musicOnHoldPlayer plays music on hold into dialog while other bridged dialog holds.
+// Audio proxied to dialog is dropped while music is playing
+type musicOnHoldPlayer struct {
+	moh *MusicOnHold
+	m   *DialogMedia
+	log *slog.Logger
+
+	mu      sync.Mutex
+	playing bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func newMusicOnHoldPlayer(moh *MusicOnHold, m *DialogMedia, log *slog.Logger) *musicOnHoldPlayer {
+	return &musicOnHoldPlayer{moh: moh, m: m, log: log}
+}
+
+// onHold handles hold event of other dialog
+func (p *musicOnHoldPlayer) onHold(ev HoldEvent) {
+	switch ev {
+	case HoldEventRemoteHold:
+		p.start()
+	case HoldEventResumed:
+		p.stop()
+	}
+}
+
+func (p *musicOnHoldPlayer) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.playing {
+		return
+	}
+
+	props := MediaProps{}
+	w := p.m.audioWriterProps(&props)
+	enc := &audio.PCMEncoderWriter{}
+	if err := enc.Init(props.Codec, w); err != nil {
+		p.log.Error("Failed to start music on hold", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	p.playing, p.cancel, p.done = true, cancel, done
+	go func() {
+		defer close(done)
+		r := p.moh.pcmReader(props.Codec)
+		defer r.Close()
+
+		buf := make([]byte, r.frameSize)
+		for ctx.Err() == nil {
+			n, err := r.Read(buf)
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					p.log.Error("Music on hold stopped", "error", err)
+				}
+				return
+			}
+			// Writer is pacing audio
+			if _, err := enc.Write(buf[:n]); err != nil {
+				p.log.Debug("Music on hold write stopped", "error", err)
+				return
+			}
+		}
+	}()
+}
+
+func (p *musicOnHoldPlayer) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.playing {
+		return
+	}
+	p.cancel()
+	<-p.done
+	p.playing = false
+}
+
+// gate returns writer used by bridge proxy, which drops audio while music is playing
+func (p *musicOnHoldPlayer) gate(w io.Writer) io.Writer {
+	return &musicOnHoldGate{p: p, w: w}
+}
+
+type musicOnHoldGate struct {
+	p *musicOnHoldPlayer
+	w io.Writer
+}
+
+func (g *musicOnHoldGate) Write(b []byte) (int, error) {
+	g.p.mu.Lock()
+	defer g.p.mu.Unlock()
+	if g.p.playing {
+		return len(b), nil
+	}
+	return g.w.Write(b)
+}
+
+// holdDialog replaces dialog audio in mix with music on hold while dialog holds
+func (b *BridgeMix) holdDialog(d DialogSession, held bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := d.Id()
+	found := false
+	for _, d := range b.dialogs {
+		if d.Id() == id {
+			found = true
+			break
+		}
+	}
+	if !found || b.held[id] == held {
+		return nil
+	}
+
+	if err := b.mixStopWait(); err != nil {
+		return err
+	}
+	if held {
+		if b.held == nil {
+			b.held = make(map[string]bool)
+		}
+		b.held[id] = true
+	} else {
+		delete(b.held, id)
+	}
+	return b.mixStart()
+}
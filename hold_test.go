@@ -0,0 +1,280 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emiago/diago/media"
+	"github.com/emiago/diago/testdata"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMusicOnHoldReader(t *testing.T) {
+	codec := media.CodecAudioUlaw
+	frameSize := codec.SamplesPCM(16)
+
+	t.Run("Reader", func(t *testing.T) {
+		// One and half frame is looped
+		pcm := make([]byte, frameSize+frameSize/2)
+		for i := range pcm {
+			pcm[i] = byte(i)
+		}
+		r := (&MusicOnHold{Reader: bytes.NewReader(pcm)}).pcmReader(codec)
+
+		buf := make([]byte, media.RTPBufSize)
+		n, err := r.Read(buf)
+		require.NoError(t, err)
+		assert.Equal(t, pcm[:frameSize], buf[:n])
+
+		n, err = r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, frameSize, n)
+		assert.Equal(t, pcm[frameSize:], buf[:frameSize/2])
+		assert.Equal(t, pcm[:frameSize/2], buf[frameSize/2:n])
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		r := (&MusicOnHold{Reader: bytes.NewReader(nil)}).pcmReader(codec)
+		_, err := r.Read(make([]byte, frameSize))
+		require.ErrorIs(t, err, io.EOF)
+
+		r = (&MusicOnHold{}).pcmReader(codec)
+		_, err = r.Read(make([]byte, frameSize))
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Files", func(t *testing.T) {
+		data, err := testdata.ReadFile("demo-echodone.wav")
+		require.NoError(t, err)
+		filename := filepath.Join(t.TempDir(), "moh.wav")
+		require.NoError(t, os.WriteFile(filename, data, 0644))
+
+		r := NewMusicOnHold(filename).pcmReader(codec)
+		defer r.Close()
+		buf := make([]byte, media.RTPBufSize)
+		// Read more than file size to check looping
+		total := 0
+		for total < 2*len(data) {
+			n, err := r.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, frameSize, n)
+			total += n
+		}
+
+		r = NewMusicOnHold(filename).pcmReader(media.CodecAudioOpus)
+		_, err = r.Read(buf)
+		require.Error(t, err)
+	})
+}
+
+func TestIntegrationHold(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Callee reports when audio is received
+	calleeAudio := make(chan []byte, 1)
+	{
+		ua, _ := sipgo.NewUA()
+		defer ua.Close()
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15132,
+			},
+		))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			if err := d.Answer(); err != nil {
+				return
+			}
+			r, err := d.AudioReader()
+			if err != nil {
+				return
+			}
+			// Mixing bridge can send silence before music on hold
+			buf := make([]byte, media.RTPBufSize)
+			for {
+				n, err := r.Read(buf)
+				if err != nil {
+					return
+				}
+				if bytes.ContainsFunc(buf[:n], func(c rune) bool { return c != 0xFF && c != 0x7F }) {
+					calleeAudio <- buf[:n]
+					break
+				}
+			}
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	pcm := make([]byte, 10*media.CodecAudioUlaw.SamplesPCM(16))
+	for i := range pcm {
+		pcm[i] = byte(i)
+	}
+
+	serverEvents := make(chan HoldEvent, 10)
+	// Re-INVITE is sent only after server is established and bridged
+	serverReady := make(chan struct{}, 1)
+	{
+		ua, _ := sipgo.NewUA()
+		defer ua.Close()
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15131,
+			},
+		))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			if d.ToUser() == "events" {
+				d.OnHold(func(ev HoldEvent) {
+					serverEvents <- ev
+				})
+				if err := d.Answer(); err != nil {
+					return
+				}
+				serverReady <- struct{}{}
+				<-d.Context().Done()
+				return
+			}
+
+			if err := d.Answer(); err != nil {
+				return
+			}
+			if d.ToUser() == "mix" {
+				bridge := NewBridgeMix()
+				bridge.MusicOnHold = &MusicOnHold{Reader: bytes.NewReader(pcm)}
+				if err := bridge.AddDialogSession(d); err != nil {
+					return
+				}
+				out, err := dg.Invite(d.Context(), sip.Uri{User: "callee", Host: "127.0.0.1", Port: 15132}, InviteOptions{})
+				if err != nil {
+					return
+				}
+				defer out.Close()
+				if err := bridge.AddDialogSession(out); err != nil {
+					return
+				}
+				serverReady <- struct{}{}
+				select {
+				case <-out.Context().Done():
+				case <-d.Context().Done():
+				}
+				return
+			}
+
+			bridge := NewBridge()
+			bridge.MusicOnHold = &MusicOnHold{Reader: bytes.NewReader(pcm)}
+			if err := bridge.AddDialogSession(d); err != nil {
+				return
+			}
+			out, err := dg.InviteBridge(d.Context(), sip.Uri{User: "callee", Host: "127.0.0.1", Port: 15132}, &bridge, InviteOptions{})
+			if err != nil {
+				return
+			}
+			defer out.Close()
+			serverReady <- struct{}{}
+			select {
+			case <-out.Context().Done():
+			case <-d.Context().Done():
+			}
+		})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	waitReady := func(t *testing.T) {
+		select {
+		case <-serverReady:
+		case <-time.After(2 * time.Second):
+			t.Fatal("server not ready")
+		}
+	}
+
+	waitEvent := func(t *testing.T, ch chan HoldEvent) HoldEvent {
+		select {
+		case ev := <-ch:
+			return ev
+		case <-time.After(time.Second):
+			t.Fatal("no hold event")
+		}
+		return 0
+	}
+
+	t.Run("Events", func(t *testing.T) {
+		d, err := dg.Invite(ctx, sip.Uri{User: "events", Host: "127.0.0.1", Port: 15131}, InviteOptions{})
+		require.NoError(t, err)
+		defer d.Close()
+
+		clientEvents := make(chan HoldEvent, 10)
+		d.OnHold(func(ev HoldEvent) {
+			clientEvents <- ev
+		})
+		waitReady(t)
+
+		require.NoError(t, d.Hold(ctx))
+		assert.Equal(t, HoldEventLocalHold, waitEvent(t, clientEvents))
+		assert.Equal(t, HoldStateLocal, d.HoldState())
+		assert.Equal(t, HoldEventRemoteHold, waitEvent(t, serverEvents))
+
+		require.NoError(t, d.Unhold(ctx))
+		assert.Equal(t, HoldEventResumed, waitEvent(t, clientEvents))
+		assert.Equal(t, HoldStateNone, d.HoldState())
+		assert.Equal(t, HoldEventResumed, waitEvent(t, serverEvents))
+
+		require.NoError(t, d.Hangup(ctx))
+	})
+
+	t.Run("BridgeMusicOnHold", func(t *testing.T) {
+		d, err := dg.Invite(ctx, sip.Uri{User: "bob", Host: "127.0.0.1", Port: 15131}, InviteOptions{})
+		require.NoError(t, err)
+		defer d.Close()
+
+		waitReady(t)
+		// Caller is silent, so callee only receives music on hold
+		require.NoError(t, d.Hold(ctx))
+		select {
+		case payload := <-calleeAudio:
+			assert.NotEmpty(t, payload)
+		case <-time.After(2 * time.Second):
+			t.Fatal("no music on hold received")
+		}
+		require.NoError(t, d.Hangup(ctx))
+	})
+
+	t.Run("BridgeMixMusicOnHold", func(t *testing.T) {
+		d, err := dg.Invite(ctx, sip.Uri{User: "mix", Host: "127.0.0.1", Port: 15131}, InviteOptions{})
+		require.NoError(t, err)
+		defer d.Close()
+
+		waitReady(t)
+		// Bridge restarts mixing on hold, which must not delay response
+		start := time.Now()
+		require.NoError(t, d.Hold(ctx))
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		select {
+		case payload := <-calleeAudio:
+			assert.NotEmpty(t, payload)
+		case <-time.After(2 * time.Second):
+			t.Fatal("no music on hold received")
+		}
+		require.NoError(t, d.Hangup(ctx))
+	})
+}
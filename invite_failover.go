@@ -0,0 +1,209 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// InviteFailoverDestination is single destination, ex. gateway, tried by InviteFailover
+type InviteFailoverDestination struct {
+	Recipient sip.Uri
+	// Transport or protocol that should be used
+	Transport string
+	// TransportID matches diago transport by ID instead protocol
+	TransportID string
+	// For digest authentication
+	Username string
+	Password string
+	// Timeout limits waiting for answer on this destination. Zero means no limit
+	Timeout time.Duration
+	// Headers are added only for this destination together with InviteFailoverOptions Headers
+	Headers []sip.Header
+}
+
+type InviteFailoverOptions struct {
+	Originator DialogSession
+	OnResponse func(res *sip.Response) error
+	// Custom headers to pass on every attempt. DO NOT SET THIS to nil
+	Headers []sip.Header
+	// FailoverOn decides should next destination be tried for final status code of attempt.
+	// Timeout is reported as 408 and transport failure as 503.
+	// Default is InviteFailoverOnServerError
+	FailoverOn func(statusCode int) bool
+	// OnAttempt is called after each attempt, including skipped destinations
+	OnAttempt func(attempt InviteAttempt)
+}
+
+// InviteFailoverOnServerError fails over on 408 Request Timeout and any 5xx response
+func InviteFailoverOnServerError(statusCode int) bool {
+	return statusCode == sip.StatusRequestTimeout || (statusCode >= 500 && statusCode < 600)
+}
+
+// InviteFailoverOnClass returns FailoverOn func that fails over on status classes, ex. 5 for 5xx
+func InviteFailoverOnClass(classes ...int) func(statusCode int) bool {
+	return func(statusCode int) bool {
+		for _, c := range classes {
+			if statusCode/100 == c {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// InviteAttempt is result of single InviteFailover attempt
+type InviteAttempt struct {
+	Recipient sip.Uri
+	// StatusCode is final status code of attempt. Timeout is reported as 408 and transport failure as 503.
+	// It is 0 when destination is skipped
+	StatusCode int
+	// Skipped is true when destination is skipped due to previous Retry-After
+	Skipped  bool
+	Duration time.Duration
+	Err      error
+}
+
+// InviteFailoverError is returned when no destination answered. It has log of all attempts
+type InviteFailoverError struct {
+	Attempts []InviteAttempt
+}
+
+func (e *InviteFailoverError) Error() string {
+	attempts := make([]string, len(e.Attempts))
+	for i, a := range e.Attempts {
+		switch {
+		case a.Skipped:
+			attempts[i] = a.Recipient.String() + ": skipped"
+		case a.Err != nil:
+			attempts[i] = fmt.Sprintf("%s: %d %s", a.Recipient.String(), a.StatusCode, a.Err)
+		default:
+			attempts[i] = fmt.Sprintf("%s: %d", a.Recipient.String(), a.StatusCode)
+		}
+	}
+	return "invite failover failed: " + strings.Join(attempts, "; ")
+}
+
+func (e *InviteFailoverError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Attempts))
+	for _, a := range e.Attempts {
+		if a.Err != nil {
+			errs = append(errs, a.Err)
+		}
+	}
+	return errs
+}
+
+// InviteFailover makes call to destinations sequentially until one answers.
+// Next destination is tried only if final status code matches FailoverOn, otherwise it stops.
+// Destination responding with Retry-After is skipped for that long on future calls.
+//
+// If no destination answers, error is *InviteFailoverError with log of all attempts.
+func (dg *Diago) InviteFailover(ctx context.Context, destinations []InviteFailoverDestination, opts InviteFailoverOptions) (*DialogClientSession, error) {
+	if len(destinations) == 0 {
+		return nil, fmt.Errorf("invite failover: no destinations")
+	}
+
+	failoverOn := opts.FailoverOn
+	if failoverOn == nil {
+		failoverOn = InviteFailoverOnServerError
+	}
+
+	attempts := make([]InviteAttempt, 0, len(destinations))
+	addAttempt := func(a InviteAttempt) {
+		attempts = append(attempts, a)
+		if opts.OnAttempt != nil {
+			opts.OnAttempt(a)
+		}
+	}
+
+	for _, dest := range destinations {
+		key := inviteFailoverKey(dest)
+		if until, ok := dg.failoverBlocked.Load(key); ok {
+			if time.Now().Before(until.(time.Time)) {
+				addAttempt(InviteAttempt{Recipient: dest.Recipient, Skipped: true})
+				continue
+			}
+			dg.failoverBlocked.Delete(key)
+		}
+
+		start := time.Now()
+		d, err := dg.inviteFailoverAttempt(ctx, dest, opts)
+		if err == nil {
+			addAttempt(InviteAttempt{Recipient: dest.Recipient, StatusCode: d.InviteResponse.StatusCode, Duration: time.Since(start)})
+			return d, nil
+		}
+
+		res := dialogErrorResponse(err)
+		statusCode := sip.StatusServiceUnavailable
+		switch {
+		case res != nil:
+			statusCode = res.StatusCode
+		case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, sip.ErrTransactionTimeout):
+			statusCode = sip.StatusRequestTimeout
+		}
+		addAttempt(InviteAttempt{Recipient: dest.Recipient, StatusCode: statusCode, Duration: time.Since(start), Err: err})
+
+		if res != nil {
+			if h := res.GetHeader("Retry-After"); h != nil {
+				if retryAfter, err := parseDeltaSeconds(h.Value()); err == nil && retryAfter > 0 {
+					until := time.Now().Add(retryAfter)
+					dg.failoverBlocked.Store(key, until)
+					// Entry is removed once expired, unless replaced meanwhile
+					time.AfterFunc(retryAfter, func() {
+						dg.failoverBlocked.CompareAndDelete(key, until)
+					})
+				}
+			}
+		}
+
+		if ctx.Err() != nil || !failoverOn(statusCode) {
+			break
+		}
+	}
+
+	return nil, &InviteFailoverError{Attempts: attempts}
+}
+
+func (dg *Diago) inviteFailoverAttempt(ctx context.Context, dest InviteFailoverDestination, opts InviteFailoverOptions) (*DialogClientSession, error) {
+	d, err := dg.newDialog(ctx, dest.Recipient, NewDialogOptions{
+		Transport:   dest.Transport,
+		TransportID: dest.TransportID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	inviteCtx := ctx
+	if dest.Timeout > 0 {
+		var cancel context.CancelFunc
+		inviteCtx, cancel = context.WithTimeout(ctx, dest.Timeout)
+		defer cancel()
+	}
+
+	if err := d.Invite(inviteCtx, InviteClientOptions{
+		Originator: opts.Originator,
+		OnResponse: opts.OnResponse,
+		Headers:    mergeHeaders(opts.Headers, dest.Headers),
+		Username:   dest.Username,
+		Password:   dest.Password,
+	}); err != nil {
+		return nil, errors.Join(err, d.Close())
+	}
+
+	if err := d.Ack(ctx); err != nil {
+		return nil, errors.Join(err, d.Close())
+	}
+	return d, nil
+}
+
+func inviteFailoverKey(dest InviteFailoverDestination) string {
+	return dest.Transport + ":" + dest.TransportID + ":" + dest.Recipient.HostPort()
+}
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInviteFailoverOnClass(t *testing.T) {
+	f := InviteFailoverOnClass(4, 5)
+	assert.True(t, f(404))
+	assert.True(t, f(503))
+	assert.False(t, f(603))
+
+	assert.True(t, InviteFailoverOnServerError(408))
+	assert.True(t, InviteFailoverOnServerError(500))
+	assert.False(t, InviteFailoverOnServerError(486))
+}
+
+func TestIntegrationInviteFailover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("server"))
+		defer ua.Close()
+
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15109,
+			},
+		))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			switch d.ToUser() {
+			case "error":
+				d.Respond(sip.StatusInternalServerError, "Internal Server Error", nil)
+				return
+			case "busy":
+				d.Respond(sip.StatusBusyHere, "Busy Here", nil)
+				return
+			}
+
+			if err := d.Answer(); err != nil {
+				return
+			}
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	// Unavailable gateway
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("unavailable"))
+		defer ua.Close()
+
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15110,
+			},
+		))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			d.Respond(sip.StatusServiceUnavailable, "Service Unavailable", nil, sip.NewHeader("Retry-After", "60"))
+		})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	dest := func(user string) InviteFailoverDestination {
+		port := 15109
+		if user == "unavailable" {
+			port = 15110
+		}
+		return InviteFailoverDestination{Recipient: sip.Uri{User: user, Host: "127.0.0.1", Port: port}}
+	}
+
+	t.Run("Failover", func(t *testing.T) {
+		attempts := []InviteAttempt{}
+		dialog, err := dg.InviteFailover(ctx, []InviteFailoverDestination{dest("unavailable"), dest("error"), dest("answer")}, InviteFailoverOptions{
+			OnAttempt: func(a InviteAttempt) {
+				attempts = append(attempts, a)
+			},
+		})
+		require.NoError(t, err)
+		defer dialog.Close()
+
+		assert.Equal(t, "answer", dialog.ToUser())
+		require.Len(t, attempts, 3)
+		assert.Equal(t, sip.StatusServiceUnavailable, attempts[0].StatusCode)
+		assert.Equal(t, sip.StatusInternalServerError, attempts[1].StatusCode)
+		assert.Equal(t, sip.StatusOK, attempts[2].StatusCode)
+		assert.NoError(t, attempts[2].Err)
+		require.NoError(t, dialog.Hangup(ctx))
+	})
+
+	t.Run("RetryAfterSkipped", func(t *testing.T) {
+		_, err := dg.InviteFailover(ctx, []InviteFailoverDestination{dest("unavailable"), dest("busy")}, InviteFailoverOptions{})
+		var failoverErr *InviteFailoverError
+		require.True(t, errors.As(err, &failoverErr), err)
+		require.Len(t, failoverErr.Attempts, 2)
+		assert.True(t, failoverErr.Attempts[0].Skipped)
+		assert.Equal(t, 0, failoverErr.Attempts[0].StatusCode)
+		assert.Equal(t, sip.StatusBusyHere, failoverErr.Attempts[1].StatusCode)
+	})
+
+	t.Run("StopOnNonFailoverCode", func(t *testing.T) {
+		_, err := dg.InviteFailover(ctx, []InviteFailoverDestination{dest("error"), dest("busy"), dest("answer")}, InviteFailoverOptions{})
+		var failoverErr *InviteFailoverError
+		require.True(t, errors.As(err, &failoverErr), err)
+		require.Len(t, failoverErr.Attempts, 2)
+		assert.Equal(t, sip.StatusInternalServerError, failoverErr.Attempts[0].StatusCode)
+		assert.Equal(t, sip.StatusBusyHere, failoverErr.Attempts[1].StatusCode)
+	})
+}
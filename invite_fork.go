@@ -0,0 +1,214 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// InviteForkTarget is single call leg of forked INVITE
+type InviteForkTarget struct {
+	Recipient sip.Uri
+	// Transport or protocol that should be used for this leg
+	Transport string
+	// TransportID matches diago transport by ID instead protocol
+	TransportID string
+	// Headers are added only on this leg together with InviteForkOptions Headers
+	Headers []sip.Header
+
+	// resolved recipient is contact already resolved by registrar
+	resolved bool
+}
+
+type InviteForkOptions struct {
+	Originator DialogSession
+	// OnResponse is called for every response of every leg. Leg is index of target.
+	// It is called concurrently from different legs.
+	OnResponse func(leg int, res *sip.Response) error
+	// For digest authentication
+	Username string
+	Password string
+	// Custom headers to pass on all legs. DO NOT SET THIS to nil
+	Headers []sip.Header
+}
+
+// InviteForkLegError is final result of failed call leg
+type InviteForkLegError struct {
+	Recipient sip.Uri
+	// StatusCode is final response status code. It is 0 if no final response was received
+	StatusCode int
+	Err        error
+}
+
+func (e InviteForkLegError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Recipient.String(), e.Err)
+}
+
+func (e InviteForkLegError) Unwrap() error {
+	return e.Err
+}
+
+// InviteForkError is returned when no call leg is answered.
+// It carries final result of every leg in order of targets.
+type InviteForkError struct {
+	Legs []InviteForkLegError
+}
+
+func (e *InviteForkError) Error() string {
+	legs := make([]string, len(e.Legs))
+	for i, l := range e.Legs {
+		legs[i] = l.Error()
+	}
+	return "invite fork failed: " + strings.Join(legs, "; ")
+}
+
+func (e *InviteForkError) Unwrap() []error {
+	errs := make([]error, len(e.Legs))
+	for i, l := range e.Legs {
+		errs[i] = l
+	}
+	return errs
+}
+
+// InviteFork makes parallel call legs to all targets and waits for first answer.
+// First answered leg is acknowledged and returned, while all other legs are canceled,
+// or terminated with BYE if they answered as well.
+//
+// If no leg answers, error is *InviteForkError with final status code of each leg.
+func (dg *Diago) InviteFork(ctx context.Context, targets []InviteForkTarget, opts InviteForkOptions) (*DialogClientSession, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("invite fork: no targets")
+	}
+
+	type legResult struct {
+		leg    int
+		dialog *DialogClientSession
+		err    error
+	}
+
+	results := make(chan legResult, len(targets))
+	cancels := make([]context.CancelFunc, len(targets))
+	legErrors := make([]InviteForkLegError, len(targets))
+	for i, target := range targets {
+		legErrors[i].Recipient = target.Recipient
+
+		d, err := dg.newDialog(ctx, target.Recipient, NewDialogOptions{
+			Transport:   target.Transport,
+			TransportID: target.TransportID,
+			resolved:    target.resolved,
+		})
+		if err != nil {
+			results <- legResult{leg: i, err: err}
+			continue
+		}
+
+		legCtx, cancel := context.WithCancel(ctx)
+		cancels[i] = cancel
+
+		inviteOpts := InviteClientOptions{
+			Originator: opts.Originator,
+			Username:   opts.Username,
+			Password:   opts.Password,
+			Headers:    mergeHeaders(opts.Headers, target.Headers),
+		}
+		if opts.OnResponse != nil {
+			leg := i
+			inviteOpts.OnResponse = func(res *sip.Response) error {
+				return opts.OnResponse(leg, res)
+			}
+		}
+
+		go func(leg int) {
+			err := d.Invite(legCtx, inviteOpts)
+			results <- legResult{leg: leg, dialog: d, err: err}
+		}(i)
+	}
+
+	cancelLegs := func() {
+		for _, cancel := range cancels {
+			if cancel != nil {
+				cancel()
+			}
+		}
+	}
+
+	var answered *DialogClientSession
+	received := 0
+	for received < len(targets) {
+		r := <-results
+		received++
+		if r.err == nil {
+			answered = r.dialog
+			break
+		}
+
+		legErrors[r.leg].Err = r.err
+		legErrors[r.leg].StatusCode = inviteForkStatusCode(r.dialog, r.err)
+		if r.dialog != nil {
+			inviteForkTerminate(r.dialog, false)
+		}
+	}
+
+	if answered == nil {
+		cancelLegs()
+		return nil, &InviteForkError{Legs: legErrors}
+	}
+
+	// Cancel other legs and cleanup in background. Late answers are terminated with BYE
+	cancelLegs()
+	go func(pending int) {
+		for ; pending > 0; pending-- {
+			r := <-results
+			if r.dialog != nil {
+				inviteForkTerminate(r.dialog, r.err == nil)
+			}
+		}
+	}(len(targets) - received)
+
+	if err := answered.Ack(ctx); err != nil {
+		return nil, errors.Join(err, answered.Close())
+	}
+	return answered, nil
+}
+
+// inviteForkStatusCode returns final status code of failed leg
+func inviteForkStatusCode(d *DialogClientSession, err error) int {
+	if res := dialogErrorResponse(err); res != nil {
+		return res.StatusCode
+	}
+
+	// Canceled leg has 487 stored
+	if d != nil && d.InviteResponse != nil && !d.InviteResponse.IsProvisional() {
+		return d.InviteResponse.StatusCode
+	}
+	return 0
+}
+
+// inviteForkTerminate terminates and closes call leg that lost in fork
+func inviteForkTerminate(d *DialogClientSession, answered bool) {
+	defer d.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), sip.Timer_F)
+	defer cancel()
+	if answered {
+		if err := d.Ack(ctx); err != nil {
+			return
+		}
+		d.Hangup(ctx)
+		return
+	}
+
+	// 2xx can be received while canceling. It still must be acknowledged and terminated
+	if res := d.InviteResponse; res != nil && res.IsSuccess() {
+		if err := d.DialogClientSession.Ack(ctx); err != nil {
+			return
+		}
+		d.Hangup(ctx)
+	}
+}
@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationInviteFork(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	canceled := make(chan string, 10)
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("server"))
+		defer ua.Close()
+
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15108,
+			},
+		))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			switch d.ToUser() {
+			case "busy":
+				d.Respond(sip.StatusBusyHere, "Busy Here", nil)
+				return
+			case "decline":
+				d.Respond(sip.StatusGlobalDecline, "Decline", nil)
+				return
+			case "ring":
+				d.Ringing()
+				<-d.Context().Done()
+				canceled <- d.ToUser()
+				return
+			}
+
+			d.Ringing()
+			time.Sleep(100 * time.Millisecond)
+			if err := d.Answer(); err != nil {
+				return
+			}
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	target := func(user string) InviteForkTarget {
+		return InviteForkTarget{Recipient: sip.Uri{User: user, Host: "127.0.0.1", Port: 15108}}
+	}
+
+	t.Run("FirstAnswerWins", func(t *testing.T) {
+		mu := sync.Mutex{}
+		ringing := map[int]bool{}
+		dialog, err := dg.InviteFork(ctx, []InviteForkTarget{target("busy"), target("ring"), target("answer")}, InviteForkOptions{
+			OnResponse: func(leg int, res *sip.Response) error {
+				mu.Lock()
+				defer mu.Unlock()
+				if res.StatusCode == sip.StatusRinging {
+					ringing[leg] = true
+				}
+				return nil
+			},
+		})
+		require.NoError(t, err)
+		defer dialog.Close()
+
+		assert.Equal(t, "answer", dialog.ToUser())
+		mu.Lock()
+		assert.Equal(t, map[int]bool{1: true, 2: true}, ringing)
+		mu.Unlock()
+
+		select {
+		case user := <-canceled:
+			assert.Equal(t, "ring", user)
+		case <-time.After(3 * time.Second):
+			t.Fatal("ringing leg not canceled")
+		}
+		require.NoError(t, dialog.Hangup(ctx))
+	})
+
+	t.Run("AllFailed", func(t *testing.T) {
+		_, err := dg.InviteFork(ctx, []InviteForkTarget{target("busy"), target("decline")}, InviteForkOptions{})
+		var forkErr *InviteForkError
+		require.True(t, errors.As(err, &forkErr), err)
+		require.Len(t, forkErr.Legs, 2)
+		assert.Equal(t, sip.StatusBusyHere, forkErr.Legs[0].StatusCode)
+		assert.Equal(t, sip.StatusGlobalDecline, forkErr.Legs[1].StatusCode)
+		assert.Equal(t, "busy", forkErr.Legs[0].Recipient.User)
+	})
+}
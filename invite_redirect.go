@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// InviteRedirectOptions enables following Contact targets of 300, 301 and 302 responses.
+// Targets are tried in q-value order, and remaining targets are tried when redirected INVITE fails.
+// Final INVITE carries redirect chain in History-Info header (RFC 7044)
+type InviteRedirectOptions struct {
+	// MaxRedirects limits number of redirected INVITEs. Zero disables following redirects
+	MaxRedirects int
+	// OnRedirect is called before following target. It can rewrite target or return false to skip it
+	OnRedirect func(target sip.Uri, res *sip.Response) (sip.Uri, bool)
+}
+
+type inviteRedirectTarget struct {
+	uri sip.Uri
+	q   float64
+	res *sip.Response
+	// parent is History-Info entry that was redirected
+	parent int
+}
+
+type historyInfoEntry struct {
+	uri   sip.Uri
+	index string
+	// cause is status code of redirect and mp is index of redirected entry. RFC 4458 and RFC 7044
+	cause    int
+	mp       string
+	children int
+}
+
+// inviteRedirect keeps redirect state of single Invite
+type inviteRedirect struct {
+	opts    InviteRedirectOptions
+	targets []inviteRedirectTarget
+	tried   map[string]bool
+	count   int
+	history []historyInfoEntry
+	// current is History-Info entry of current INVITE
+	current int
+}
+
+func newInviteRedirect(opts InviteRedirectOptions, recipient sip.Uri) *inviteRedirect {
+	return &inviteRedirect{
+		opts:    opts,
+		tried:   map[string]bool{redirectTargetKey(recipient): true},
+		history: []historyInfoEntry{{uri: recipient, index: "1"}},
+	}
+}
+
+func redirectTargetKey(uri sip.Uri) string {
+	return strings.ToLower(uri.User + "@" + uri.HostPort())
+}
+
+// redirectRetry updates INVITE with next redirect target on 3xx response.
+// If redirected INVITE fails, next target of previous redirect is tried, unless it failed on authentication or session timer
+func (d *DialogClientSession) redirectRetry(err error, r *inviteRedirect) bool {
+	if r.opts.MaxRedirects <= 0 {
+		return false
+	}
+	res := dialogErrorResponse(err)
+	if res == nil {
+		return false
+	}
+
+	switch res.StatusCode {
+	case 300, sip.StatusMovedPermanently, sip.StatusMovedTemporarily: // 300 Multiple Choices
+		r.addTargets(res)
+	case sip.StatusUnauthorized, sip.StatusProxyAuthRequired, sip.StatusIntervalToBrief:
+		// Failed challenge or session timer negotiation of current target
+		return false
+	}
+
+	for len(r.targets) > 0 && r.count < r.opts.MaxRedirects {
+		t := r.targets[0]
+		r.targets = r.targets[1:]
+
+		uri := t.uri
+		if r.opts.OnRedirect != nil {
+			var ok bool
+			if uri, ok = r.opts.OnRedirect(uri, t.res); !ok {
+				continue
+			}
+		}
+
+		// Loop detection
+		key := redirectTargetKey(uri)
+		if r.tried[key] {
+			continue
+		}
+		r.tried[key] = true
+		r.count++
+
+		parent := &r.history[t.parent]
+		parent.children++
+		r.history = append(r.history, historyInfoEntry{
+			uri:   uri,
+			index: parent.index + "." + strconv.Itoa(parent.children),
+			cause: t.res.StatusCode,
+			mp:    parent.index,
+		})
+		r.current = len(r.history) - 1
+
+		inviteReq := d.InviteRequest
+		inviteReq.Recipient = *uri.Clone()
+		inviteReq.RemoveHeader("History-Info")
+		inviteReq.AppendHeader(sip.NewHeader("History-Info", r.historyInfo()))
+		// Credentials are for previous target
+		inviteReq.RemoveHeader("Authorization")
+		inviteReq.RemoveHeader("Proxy-Authorization")
+		requestNewTransaction(inviteReq)
+
+		d.redirects = append(d.redirects, uri)
+		return true
+	}
+	return false
+}
+
+// addTargets replaces pending targets with Contacts of redirect response sorted by q-value
+func (r *inviteRedirect) addTargets(res *sip.Response) {
+	targets := []inviteRedirectTarget{}
+	for _, h := range res.GetHeaders("Contact") {
+		cont, ok := h.(*sip.ContactHeader)
+		if !ok {
+			continue
+		}
+		switch cont.Address.Scheme {
+		case "", "sip", "sips":
+		default:
+			continue
+		}
+
+		q := 1.0
+		if v, ok := cont.Params.Get("q"); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		targets = append(targets, inviteRedirectTarget{
+			uri:    *cont.Address.Clone(),
+			q:      q,
+			res:    res,
+			parent: r.current,
+		})
+	}
+	slices.SortStableFunc(targets, func(a, b inviteRedirectTarget) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+	r.targets = targets
+}
+
+func (r *inviteRedirect) historyInfo() string {
+	entries := make([]string, len(r.history))
+	for i, e := range r.history {
+		uri := *e.uri.Clone()
+		if e.cause > 0 {
+			if uri.UriParams == nil {
+				uri.UriParams = sip.NewParams()
+			}
+			uri.UriParams.Add("cause", strconv.Itoa(e.cause))
+		}
+		entry := "<" + uri.String() + ">;index=" + e.index
+		if e.mp != "" {
+			entry += ";mp=" + e.mp
+		}
+		entries[i] = entry
+	}
+	return strings.Join(entries, ", ")
+}
+
+// Redirects returns targets followed on 3xx responses, in order
+func (d *DialogClientSession) Redirects() []sip.Uri {
+	return slices.Clone(d.redirects)
+}
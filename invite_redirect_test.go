@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationInviteRedirect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	contact := func(user string, q string) sip.Header {
+		h := &sip.ContactHeader{
+			Address: sip.Uri{Scheme: "sip", User: user, Host: "127.0.0.1", Port: 15133},
+			Params:  sip.NewParams(),
+		}
+		if q != "" {
+			h.Params.Add("q", q)
+		}
+		return h
+	}
+
+	historyInfo := make(chan string, 1)
+	{
+		ua, _ := sipgo.NewUA()
+		defer ua.Close()
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15133,
+			},
+		))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			// To header is not changed on redirect
+			switch d.InviteRequest.Recipient.User {
+			case "forward":
+				d.Respond(sip.StatusMovedTemporarily, "Moved Temporarily", nil, contact("second", "0.5"), contact("first", "0.9"))
+				return
+			case "loop":
+				d.Respond(sip.StatusMovedTemporarily, "Moved Temporarily", nil, contact("loop", ""))
+				return
+			case "first":
+				d.Respond(sip.StatusBusyHere, "Busy Here", nil)
+				return
+			case "forwardauth", "auth":
+				// Each target challenges with own nonce, and credentials of other target are rejected
+				nonce := d.InviteRequest.Recipient.User
+				auth := d.InviteRequest.GetHeader("Authorization")
+				if auth == nil {
+					d.Respond(sip.StatusUnauthorized, "Unauthorized", nil,
+						sip.NewHeader("WWW-Authenticate", `Digest realm="test", nonce="`+nonce+`", algorithm=MD5`))
+					return
+				}
+				if !strings.Contains(auth.Value(), `nonce="`+nonce+`"`) {
+					d.Respond(sip.StatusForbidden, "Forbidden", nil)
+					return
+				}
+				if nonce == "forwardauth" {
+					d.Respond(sip.StatusMovedTemporarily, "Moved Temporarily", nil, contact("auth", "0.9"), contact("second", "0.5"))
+					return
+				}
+			}
+
+			if h := d.InviteRequest.GetHeader("History-Info"); h != nil {
+				historyInfo <- h.Value()
+			}
+			if err := d.Answer(); err != nil {
+				return
+			}
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	t.Run("Disabled", func(t *testing.T) {
+		_, err := dg.Invite(ctx, sip.Uri{User: "forward", Host: "127.0.0.1", Port: 15133}, InviteOptions{})
+		require.Error(t, err)
+		assert.Equal(t, sip.StatusMovedTemporarily, dialogErrorResponse(err).StatusCode)
+	})
+
+	t.Run("QOrder", func(t *testing.T) {
+		targets := []string{}
+		d, err := dg.Invite(ctx, sip.Uri{User: "forward", Host: "127.0.0.1", Port: 15133}, InviteOptions{
+			Redirect: InviteRedirectOptions{
+				MaxRedirects: 3,
+				OnRedirect: func(target sip.Uri, res *sip.Response) (sip.Uri, bool) {
+					targets = append(targets, target.User)
+					return target, true
+				},
+			},
+		})
+		require.NoError(t, err)
+		defer d.Close()
+
+		// First target is busy, so second is tried
+		assert.Equal(t, []string{"first", "second"}, targets)
+		redirects := d.Redirects()
+		require.Len(t, redirects, 2)
+		assert.Equal(t, "second", redirects[1].User)
+		assert.Equal(t,
+			"<sip:forward@127.0.0.1:15133>;index=1, <sip:first@127.0.0.1:15133;cause=302>;index=1.1;mp=1, <sip:second@127.0.0.1:15133;cause=302>;index=1.2;mp=1",
+			<-historyInfo,
+		)
+		require.NoError(t, d.Hangup(ctx))
+	})
+
+	t.Run("Rewrite", func(t *testing.T) {
+		d, err := dg.Invite(ctx, sip.Uri{User: "forward", Host: "127.0.0.1", Port: 15133}, InviteOptions{
+			Redirect: InviteRedirectOptions{
+				MaxRedirects: 3,
+				OnRedirect: func(target sip.Uri, res *sip.Response) (sip.Uri, bool) {
+					target.User = "carol"
+					return target, true
+				},
+			},
+		})
+		require.NoError(t, err)
+		defer d.Close()
+
+		<-historyInfo
+		require.Len(t, d.Redirects(), 1)
+		assert.Equal(t, "carol", d.Redirects()[0].User)
+		require.NoError(t, d.Hangup(ctx))
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		_, err := dg.Invite(ctx, sip.Uri{User: "forward", Host: "127.0.0.1", Port: 15133}, InviteOptions{
+			Redirect: InviteRedirectOptions{
+				MaxRedirects: 3,
+				OnRedirect: func(target sip.Uri, res *sip.Response) (sip.Uri, bool) {
+					return target, target.User != "second"
+				},
+			},
+		})
+		require.Error(t, err)
+		assert.Equal(t, sip.StatusBusyHere, dialogErrorResponse(err).StatusCode)
+	})
+
+	t.Run("Loop", func(t *testing.T) {
+		_, err := dg.Invite(ctx, sip.Uri{User: "loop", Host: "127.0.0.1", Port: 15133}, InviteOptions{
+			Redirect: InviteRedirectOptions{MaxRedirects: 3},
+		})
+		require.Error(t, err)
+		assert.Equal(t, sip.StatusMovedTemporarily, dialogErrorResponse(err).StatusCode)
+	})
+
+	t.Run("MaxRedirects", func(t *testing.T) {
+		_, err := dg.Invite(ctx, sip.Uri{User: "forward", Host: "127.0.0.1", Port: 15133}, InviteOptions{
+			Redirect: InviteRedirectOptions{MaxRedirects: 1},
+		})
+		require.Error(t, err)
+		assert.Equal(t, sip.StatusBusyHere, dialogErrorResponse(err).StatusCode)
+	})
+
+	t.Run("Auth", func(t *testing.T) {
+		d, err := dg.Invite(ctx, sip.Uri{User: "forwardauth", Host: "127.0.0.1", Port: 15133}, InviteOptions{
+			Username: "alice",
+			Password: "secret",
+			Redirect: InviteRedirectOptions{MaxRedirects: 3},
+		})
+		require.NoError(t, err)
+		defer d.Close()
+
+		// Challenge of redirect target is answered instead of trying next target
+		<-historyInfo
+		require.Len(t, d.Redirects(), 1)
+		assert.Equal(t, "auth", d.Redirects()[0].User)
+		require.NoError(t, d.Hangup(ctx))
+	})
+}
@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emiago/diago/media"
+	"github.com/emiago/diago/media/sdp"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationLateOffer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverAudio := make(chan []byte, 1)
+	serverEvents := make(chan HoldEvent, 10)
+	serverReady := make(chan struct{}, 1)
+	{
+		ua, _ := sipgo.NewUA()
+		defer ua.Close()
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15135,
+			},
+		))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			if d.InviteRequest.Body() != nil {
+				d.Respond(sip.StatusBadRequest, "Expected Late Offer", nil)
+				return
+			}
+			d.OnHold(func(ev HoldEvent) {
+				serverEvents <- ev
+			})
+			if err := d.AnswerLate(); err != nil {
+				return
+			}
+			serverReady <- struct{}{}
+
+			r, err := d.AudioReader()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, media.RTPBufSize)
+			n, err := r.Read(buf)
+			if err != nil {
+				return
+			}
+			serverAudio <- buf[:n]
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	t.Run("EarlyMediaDetect", func(t *testing.T) {
+		d, err := dg.NewDialog(sip.Uri{User: "alice", Host: "127.0.0.1", Port: 15135}, NewDialogOptions{})
+		require.NoError(t, err)
+		defer d.Close()
+		err = d.Invite(ctx, InviteClientOptions{LateOffer: true, EarlyMediaDetect: true})
+		require.Error(t, err)
+	})
+
+	t.Run("AnswerInAck", func(t *testing.T) {
+		d, err := dg.Invite(ctx, sip.Uri{User: "alice", Host: "127.0.0.1", Port: 15135}, InviteOptions{LateOffer: true})
+		require.NoError(t, err)
+		defer d.Close()
+
+		select {
+		case <-serverReady:
+		case <-time.After(2 * time.Second):
+			t.Fatal("server not ready")
+		}
+		require.NotNil(t, d.MediaSession())
+		assert.NotEmpty(t, d.MediaSession().CommonCodecs())
+
+		w, err := d.AudioWriter()
+		require.NoError(t, err)
+		payload := make([]byte, media.CodecAudioUlaw.SampleTimestamp())
+		for i := range payload {
+			payload[i] = byte(i)
+		}
+		_, err = w.Write(payload)
+		require.NoError(t, err)
+
+		select {
+		case b := <-serverAudio:
+			assert.Equal(t, payload, b)
+		case <-time.After(2 * time.Second):
+			t.Fatal("no audio received")
+		}
+
+		// Re-INVITE without SDP. Server offers in 200 OK and we answer in ACK
+		req := sip.NewRequest(sip.INVITE, d.RemoteContact().Address)
+		req.AppendHeader(d.InviteRequest.Contact())
+		res, err := d.Do(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, sip.StatusOK, res.StatusCode)
+		require.NotEmpty(t, res.Body())
+
+		m := d.MediaSession().Fork()
+		m.Mode = sdp.ModeSendonly
+		require.NoError(t, m.RemoteSDP(res.Body()))
+		require.NoError(t, d.ack(ctx, res.Contact().Address, res.CSeq().SeqNo, m.LocalSDP()))
+
+		select {
+		case ev := <-serverEvents:
+			assert.Equal(t, HoldEventRemoteHold, ev)
+		case <-time.After(2 * time.Second):
+			t.Fatal("answer in ACK not applied")
+		}
+		require.NoError(t, d.Hangup(ctx))
+	})
+}
+
+func TestIntegrationLateOfferReject(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Server offers only unsupported streams in 200 OK
+	offer := "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nc=IN IP4 127.0.0.1\r\nt=0 0\r\n" +
+		"m=audio 15140 RTP/AVP 9\r\na=rtpmap:9 G722/8000\r\n"
+	ackBody := make(chan []byte, 1)
+	byeReceived := make(chan struct{}, 1)
+	{
+		ua, _ := sipgo.NewUA()
+		defer ua.Close()
+		srv, err := sipgo.NewServer(ua)
+		require.NoError(t, err)
+		srv.OnInvite(func(req *sip.Request, tx sip.ServerTransaction) {
+			res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", []byte(offer))
+			res.AppendHeader(&sip.ContactHeader{Address: sip.Uri{Host: "127.0.0.1", Port: 15139}})
+			res.AppendHeader(sip.NewHeader("Content-Type", "application/sdp"))
+			tx.Respond(res)
+		})
+		srv.OnAck(func(req *sip.Request, tx sip.ServerTransaction) {
+			ackBody <- req.Body()
+		})
+		srv.OnBye(func(req *sip.Request, tx sip.ServerTransaction) {
+			tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+			byeReceived <- struct{}{}
+		})
+
+		conn, err := net.ListenPacket("udp", "127.0.0.1:15139")
+		require.NoError(t, err)
+		go srv.ServeUDP(conn)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	_, err = dg.Invite(ctx, sip.Uri{User: "alice", Host: "127.0.0.1", Port: 15139}, InviteOptions{LateOffer: true})
+	require.Error(t, err)
+
+	// ACK carries answer rejecting offered stream before call is terminated
+	select {
+	case body := <-ackBody:
+		assert.True(t, strings.Contains(string(body), "m=audio 0 RTP/AVP 9\r\n"), string(body))
+	case <-time.After(2 * time.Second):
+		t.Fatal("no ACK received")
+	}
+	select {
+	case <-byeReceived:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no BYE received")
+	}
+}
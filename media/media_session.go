@@ -292,6 +292,14 @@ func (s *MediaSession) StartRTP(rw int8) error {
 	return s.rtpConn.SetDeadline(time.Time{})
 }
 
+// NegotiatedMode returns media direction after negotiation. Before negotiation it is Mode
+func (s *MediaSession) NegotiatedMode() string {
+	if s.mode == "" {
+		return s.Mode
+	}
+	return s.mode
+}
+
 // Fork is special call to be used in case when there is session update
 // It preserves pointer to same conneciton but rest is removed
 func (s *MediaSession) Fork() *MediaSession {
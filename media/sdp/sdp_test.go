@@ -58,3 +58,20 @@ a=rtcp-mux
 	require.Equal(t, net.ParseIP("192.168.100.11").String(), ci.IP.String())
 
 }
+
+func TestGenerateRejectAnswer(t *testing.T) {
+	offer := "v=0\r\no=- 1 1 IN IP4 10.0.0.1\r\ns=-\r\nc=IN IP4 10.0.0.1\r\nt=0 0\r\n" +
+		"m=audio 5004 RTP/AVP 9\r\na=rtpmap:9 G722/8000\r\n" +
+		"m=video 5006 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\n"
+
+	answer, err := GenerateRejectAnswer(net.IPv4(127, 0, 0, 1), []byte(offer))
+	require.NoError(t, err)
+
+	sd := SessionDescription{}
+	require.NoError(t, Unmarshal(answer, &sd))
+	require.Equal(t, []string{"audio 0 RTP/AVP 9", "video 0 RTP/AVP 96"}, sd.Values("m"))
+	require.Equal(t, "IN IP4 127.0.0.1", sd.Value("c"))
+
+	_, err = GenerateRejectAnswer(net.IPv4(127, 0, 0, 1), []byte("v=0\r\n"))
+	require.Error(t, err)
+}
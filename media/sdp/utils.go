@@ -90,3 +90,37 @@ func GenerateForAudio(originIP net.IP, connectionIP net.IP, rtpPort int, mode st
 	res := strings.Join(s, "\r\n") + "\r\n"
 	return []byte(res)
 }
+
+// GenerateRejectAnswer creates answer to offer rejecting all media streams with zero port
+// https://datatracker.ietf.org/doc/html/rfc3264#section-6
+func GenerateRejectAnswer(originIP net.IP, offer []byte) ([]byte, error) {
+	sd := SessionDescription{}
+	if err := Unmarshal(offer, &sd); err != nil {
+		return nil, err
+	}
+	mediaLines := sd.Values("m")
+	if len(mediaLines) == 0 {
+		return nil, fmt.Errorf("no media description in offer")
+	}
+
+	ntpTime := GetCurrentNTPTimestamp()
+	s := []string{
+		"v=0",
+		fmt.Sprintf("o=- %d %d IN IP4 %s", ntpTime, ntpTime, originIP),
+		"s=Sip Go Media",
+		fmt.Sprintf("c=IN IP4 %s", originIP),
+		"t=0 0",
+	}
+	for _, m := range mediaLines {
+		fields := strings.Fields(m)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("Not enough fields in media description")
+		}
+		// Answer must have same media lines, where rejected stream has zero port
+		fields[1] = "0"
+		s = append(s, "m="+strings.Join(fields, " "))
+	}
+
+	res := strings.Join(s, "\r\n") + "\r\n"
+	return []byte(res), nil
+}
@@ -0,0 +1,210 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+)
+
+// MessageOptions for sending pager mode instant message
+type MessageOptions struct {
+	// Transport or Transport ID to use. Transport can be also set with transport uri param on recipient
+	Transport   string
+	TransportID string
+
+	// Digest auth
+	Username string
+	Password string
+
+	// Custom headers to pass. DO NOT SET THIS to nil
+	Headers []sip.Header
+}
+
+// Message sends pager mode instant message (RFC 3428) outside of dialog.
+// Non 2xx final response is returned as sipgo.ErrDialogResponse
+func (dg *Diago) Message(ctx context.Context, recipient sip.Uri, contentType string, body []byte, opts MessageOptions) error {
+	tran, err := dg.recipientTransport(&recipient, opts.Transport, opts.TransportID)
+	if err != nil {
+		return err
+	}
+
+	scheme := recipient.Scheme
+	if scheme == "" {
+		scheme = "sip"
+	}
+
+	req := sip.NewRequest(sip.MESSAGE, recipient)
+	req.SetTransport(sip.NetworkToUpper(tran.Transport))
+	from := &sip.FromHeader{
+		Address: sip.Uri{Scheme: scheme, User: dg.ua.Name(), Host: tran.ExternalHost},
+		Params:  sip.NewParams(),
+	}
+	from.Params.Add("tag", sip.GenerateTagN(16))
+	req.AppendHeader(from)
+	for _, h := range opts.Headers {
+		req.AppendHeader(h)
+	}
+	req.AppendHeader(sip.NewHeader("Content-Type", contentType))
+	req.SetBody(body)
+
+	client := dg.getClient(tran)
+	res, err := client.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if (res.StatusCode == sip.StatusUnauthorized || res.StatusCode == sip.StatusProxyAuthRequired) && opts.Username != "" {
+		res, err = digestAuthDo(ctx, client, req, res, sipgo.DigestAuth{
+			Username: opts.Username,
+			Password: opts.Password,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if !res.IsSuccess() {
+		return sipgo.ErrDialogResponse{Res: res}
+	}
+	return nil
+}
+
+// MessageHandlerFunc handles incoming MESSAGE request. Unless handler responds, 200 OK is sent after it returns.
+type MessageHandlerFunc func(m *MessageRequest)
+
+// MessageRequest is received MESSAGE request
+type MessageRequest struct {
+	*sip.Request
+
+	// Dialog is set when MESSAGE is received within dialog, otherwise it is nil.
+	// It is *DialogServerSession or *DialogClientSession
+	Dialog DialogSession
+
+	tx        sip.ServerTransaction
+	mu        sync.Mutex
+	responded bool
+	// authIdentity is set when MESSAGE is authenticated with WithServerAuth
+	authIdentity AuthIdentity
+}
+
+// ContentType returns Content-Type header value
+func (m *MessageRequest) ContentType() string {
+	if h := m.Request.ContentType(); h != nil {
+		return h.Value()
+	}
+	return ""
+}
+
+// Respond sends final response. Response is sent only once
+func (m *MessageRequest) Respond(statusCode int, reason string, headers ...sip.Header) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.responded {
+		return fmt.Errorf("message already responded")
+	}
+	m.responded = true
+
+	res := sip.NewResponseFromRequest(m.Request, statusCode, reason, nil)
+	for _, h := range headers {
+		res.AppendHeader(h)
+	}
+	return m.tx.Respond(res)
+}
+
+// HandleMessage registers handler for incoming MESSAGE requests, both outside and within dialog.
+// Without handler, MESSAGE is rejected with 405 Method Not Allowed
+func (dg *Diago) HandleMessage(f MessageHandlerFunc) {
+	dg.messageHandler.Store(&f)
+}
+
+func (dg *Diago) handleMessage(req *sip.Request, tx sip.ServerTransaction) error {
+	f := dg.messageHandler.Load()
+	if f == nil {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusMethodNotAllowed, "Method Not Allowed", nil))
+	}
+
+	m := &MessageRequest{Request: req, tx: tx}
+	if dg.serverAuth != nil && dg.serverAuth.requires(req) {
+		identity, authorized, err := dg.serverAuth.authorize(req, tx)
+		if !authorized {
+			return err
+		}
+		m.authIdentity = identity
+	}
+
+	if _, ok := req.To().Params.Get("tag"); ok {
+		sd, cd, err := dg.cache.MatchDialog(req)
+		if err != nil {
+			return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil))
+		}
+
+		if cd != nil {
+			if err := cd.ReadRequest(req, tx); err != nil {
+				return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - "+err.Error(), nil))
+			}
+			m.Dialog = cd
+		} else {
+			if err := sd.ReadRequest(req, tx); err != nil {
+				return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - "+err.Error(), nil))
+			}
+			m.Dialog = sd
+		}
+	}
+
+	(*f)(m)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.responded {
+		return nil
+	}
+	m.responded = true
+	return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+}
+
+// dialogMessage sends MESSAGE within dialog
+func dialogMessage(ctx context.Context, d DialogSession, recipient sip.Uri, contentType string, body []byte) error {
+	if d.DialogSIP().LoadState() != sip.DialogStateConfirmed {
+		return fmt.Errorf("can only be called on answered dialog")
+	}
+
+	req := sip.NewRequest(sip.MESSAGE, recipient)
+	req.AppendHeader(sip.NewHeader("Content-Type", contentType))
+	req.SetBody(body)
+
+	res, err := d.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if !res.IsSuccess() {
+		return sipgo.ErrDialogResponse{
+			Res: res,
+		}
+	}
+	return nil
+}
+
+// Message sends MESSAGE within dialog
+func (d *DialogClientSession) Message(ctx context.Context, contentType string, body []byte) error {
+	d.mu.Lock()
+	contact := d.remoteContactUnsafe()
+	d.mu.Unlock()
+
+	return dialogMessage(ctx, d, contact.Address, contentType, body)
+}
+
+// Message sends MESSAGE within dialog
+func (d *DialogServerSession) Message(ctx context.Context, contentType string, body []byte) error {
+	d.mu.Lock()
+	contact := d.remoteContactUnsafe()
+	d.mu.Unlock()
+
+	return dialogMessage(ctx, d, contact.Address, contentType, body)
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationMessage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverMessages := make(chan *MessageRequest, 10)
+	serverDialog := make(chan *DialogServerSession, 1)
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("server"))
+		defer ua.Close()
+
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15115,
+			},
+		))
+		dg.HandleMessage(func(m *MessageRequest) {
+			if m.To().Address.User == "reject" {
+				m.Respond(sip.StatusForbidden, "Forbidden")
+				return
+			}
+			serverMessages <- m
+		})
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			if err := d.Answer(); err != nil {
+				return
+			}
+			serverDialog <- d
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	clientMessages := make(chan *MessageRequest, 10)
+	ua, _ := sipgo.NewUA(sipgo.WithUserAgent("alice"))
+	defer ua.Close()
+	dg := NewDiago(ua, WithTransport(
+		Transport{
+			Transport: "udp",
+			BindHost:  "127.0.0.1",
+			BindPort:  15116,
+		},
+	))
+	dg.HandleMessage(func(m *MessageRequest) {
+		clientMessages <- m
+	})
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	waitMessage := func(ch chan *MessageRequest) *MessageRequest {
+		select {
+		case m := <-ch:
+			return m
+		case <-time.After(3 * time.Second):
+			t.Fatal("no MESSAGE received")
+		}
+		return nil
+	}
+
+	t.Run("OutOfDialog", func(t *testing.T) {
+		err := dg.Message(ctx, sip.Uri{User: "bob", Host: "127.0.0.1", Port: 15115}, "text/plain", []byte("hello"), MessageOptions{})
+		require.NoError(t, err)
+
+		m := waitMessage(serverMessages)
+		assert.Nil(t, m.Dialog)
+		assert.Equal(t, "text/plain", m.ContentType())
+		assert.Equal(t, "hello", string(m.Body()))
+		assert.Equal(t, "alice", m.From().Address.User)
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		err := dg.Message(ctx, sip.Uri{User: "reject", Host: "127.0.0.1", Port: 15115}, "text/plain", []byte("hello"), MessageOptions{})
+		res := dialogErrorResponse(err)
+		require.NotNil(t, res, err)
+		assert.Equal(t, sip.StatusForbidden, res.StatusCode)
+	})
+
+	t.Run("UnknownTransport", func(t *testing.T) {
+		err := dg.Message(ctx, sip.Uri{User: "bob", Host: "127.0.0.1", Port: 15115}, "text/plain", []byte("hello"), MessageOptions{Transport: "tcp"})
+		require.Error(t, err)
+	})
+
+	t.Run("InDialog", func(t *testing.T) {
+		d, err := dg.Invite(ctx, sip.Uri{User: "bob", Host: "127.0.0.1", Port: 15115}, InviteOptions{})
+		require.NoError(t, err)
+		defer d.Close()
+
+		var sd *DialogServerSession
+		select {
+		case sd = <-serverDialog:
+		case <-time.After(3 * time.Second):
+			t.Fatal("dialog not answered")
+		}
+
+		require.NoError(t, d.Message(ctx, "text/plain", []byte("from client")))
+		m := waitMessage(serverMessages)
+		require.NotNil(t, m.Dialog)
+		assert.Equal(t, sd.Id(), m.Dialog.Id())
+		assert.Equal(t, "from client", string(m.Body()))
+
+		require.NoError(t, sd.Message(ctx, "text/plain", []byte("from server")))
+		m = waitMessage(clientMessages)
+		require.NotNil(t, m.Dialog)
+		assert.Equal(t, d.Id(), m.Dialog.Id())
+		assert.Equal(t, "from server", string(m.Body()))
+
+		require.NoError(t, d.Hangup(ctx))
+	})
+}
@@ -0,0 +1,431 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/emiago/diago/media"
+	"github.com/emiago/sipgo/sip"
+	"github.com/pion/rtcp"
+)
+
+const (
+	metricsDirectionServer = "server"
+	metricsDirectionClient = "client"
+)
+
+var (
+	metricsJitterBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.02, 0.04, 0.08, 0.16}
+	metricsRTTBuckets    = []float64{0.01, 0.025, 0.05, 0.1, 0.2, 0.4, 0.8, 1.6}
+)
+
+// Metrics collects call and media quality metrics of Diago.
+// It is http.Handler serving metrics in Prometheus text format.
+//
+// Use WithMetrics to attach it to Diago. Metrics is safe to share between multiple Diago instances.
+type Metrics struct {
+	mu sync.Mutex
+
+	dialogsActive   map[metricsDialogKey]int64
+	dialogsTotal    map[metricsDialogKey]uint64
+	inviteResponses map[metricsResponseKey]uint64
+	registrations   map[metricsRegistrationKey]bool
+
+	rtpPacketsReceived uint64
+	rtpPacketsSent     uint64
+	rtpPacketsLost     uint64
+	rtpJitter          metricsHistogram
+	rtpRTT             metricsHistogram
+
+	jitterBuffers map[*media.RTPJitterBuffer]struct{}
+	// jitterBuffersClosed keeps statistics of closed jitter buffers
+	jitterBuffersClosed media.RTPJitterBufferStatistics
+
+	bridges map[string]*BridgeMix
+}
+
+type metricsDialogKey struct {
+	direction string
+	transport string
+}
+
+// metricsRegistrationKey identifies single RegisterTransaction, as AOR can be registered with multiple contacts
+type metricsRegistrationKey struct {
+	aor     string
+	contact string
+}
+
+type metricsResponseKey struct {
+	direction string
+	class     string
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		dialogsActive:   make(map[metricsDialogKey]int64),
+		dialogsTotal:    make(map[metricsDialogKey]uint64),
+		inviteResponses: make(map[metricsResponseKey]uint64),
+		registrations:   make(map[metricsRegistrationKey]bool),
+		rtpJitter:       newMetricsHistogram(metricsJitterBuckets),
+		rtpRTT:          newMetricsHistogram(metricsRTTBuckets),
+		jitterBuffers:   make(map[*media.RTPJitterBuffer]struct{}),
+		bridges:         make(map[string]*BridgeMix),
+	}
+}
+
+// WithMetrics collects metrics of dialogs, registrations and media
+func WithMetrics(m *Metrics) DiagoOption {
+	return func(dg *Diago) {
+		dg.metrics = m
+	}
+}
+
+// RegisterBridgeMix exposes participant count of bridge under name
+func (m *Metrics) RegisterBridgeMix(name string, b *BridgeMix) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bridges[name] = b
+}
+
+// UnregisterBridgeMix removes bridge added with RegisterBridgeMix
+func (m *Metrics) UnregisterBridgeMix(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.bridges, name)
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := m.Write(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Write writes metrics in Prometheus text format
+func (m *Metrics) Write(w io.Writer) error {
+	m.mu.Lock()
+	dialogsActive := sortedMetrics(m.dialogsActive, func(k metricsDialogKey) string { return k.direction + k.transport })
+	dialogsTotal := sortedMetrics(m.dialogsTotal, func(k metricsDialogKey) string { return k.direction + k.transport })
+	inviteResponses := sortedMetrics(m.inviteResponses, func(k metricsResponseKey) string { return k.direction + k.class })
+	registrations := sortedMetrics(m.registrations, func(k metricsRegistrationKey) string { return k.aor + k.contact })
+	bridges := sortedMetrics(m.bridges, func(k string) string { return k })
+	rtpPacketsReceived, rtpPacketsSent, rtpPacketsLost := m.rtpPacketsReceived, m.rtpPacketsSent, m.rtpPacketsLost
+	rtpJitter, rtpRTT := m.rtpJitter.clone(), m.rtpRTT.clone()
+	jitterStats := m.jitterBuffersClosed
+	for j := range m.jitterBuffers {
+		addJitterBufferStatistics(&jitterStats, j.Statistics())
+	}
+	m.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	header := func(name string, typ string, help string) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	header("diago_dialogs_active", "gauge", "Number of active dialogs.")
+	for _, e := range dialogsActive {
+		fmt.Fprintf(bw, "diago_dialogs_active{direction=%q,transport=%q} %d\n", e.key.direction, e.key.transport, e.value)
+	}
+	header("diago_dialogs_total", "counter", "Total number of dialogs.")
+	for _, e := range dialogsTotal {
+		fmt.Fprintf(bw, "diago_dialogs_total{direction=%q,transport=%q} %d\n", e.key.direction, e.key.transport, e.value)
+	}
+	header("diago_invite_responses_total", "counter", "Total number of INVITE final responses by class. Server dialogs count sent and client dialogs received responses.")
+	for _, e := range inviteResponses {
+		fmt.Fprintf(bw, "diago_invite_responses_total{direction=%q,class=%q} %d\n", e.key.direction, e.key.class, e.value)
+	}
+	header("diago_registration_registered", "gauge", "Registration state of RegisterTransaction by AOR and contact. 1 is registered.")
+	for _, e := range registrations {
+		v := 0
+		if e.value {
+			v = 1
+		}
+		fmt.Fprintf(bw, "diago_registration_registered{aor=%q,contact=%q} %d\n", e.key.aor, e.key.contact, v)
+	}
+
+	header("diago_rtp_packets_received_total", "counter", "Total number of received RTP packets.")
+	fmt.Fprintf(bw, "diago_rtp_packets_received_total %d\n", rtpPacketsReceived)
+	header("diago_rtp_packets_sent_total", "counter", "Total number of sent RTP packets.")
+	fmt.Fprintf(bw, "diago_rtp_packets_sent_total %d\n", rtpPacketsSent)
+	header("diago_rtp_packets_lost_total", "counter", "Total number of lost received RTP packets as reported in RTCP.")
+	fmt.Fprintf(bw, "diago_rtp_packets_lost_total %d\n", rtpPacketsLost)
+	header("diago_rtp_jitter_seconds", "histogram", "Interarrival jitter of received RTP as reported in RTCP.")
+	rtpJitter.write(bw, "diago_rtp_jitter_seconds")
+	header("diago_rtp_rtt_seconds", "histogram", "Round trip time calculated from RTCP.")
+	rtpRTT.write(bw, "diago_rtp_rtt_seconds")
+
+	header("diago_jitter_buffer_packets_total", "counter", "Total number of RTP jitter buffer packet decisions.")
+	for _, e := range []struct {
+		event string
+		value uint64
+	}{
+		{"read", jitterStats.PacketsRead},
+		{"released", jitterStats.PacketsReleased},
+		{"lost", jitterStats.PacketsLost},
+		{"late", jitterStats.PacketsLate},
+		{"duplicate", jitterStats.PacketsDuplicate},
+		{"dropped", jitterStats.PacketsDropped},
+	} {
+		fmt.Fprintf(bw, "diago_jitter_buffer_packets_total{event=%q} %d\n", e.event, e.value)
+	}
+	header("diago_jitter_buffer_ssrc_resets_total", "counter", "Total number of RTP jitter buffer SSRC resets.")
+	fmt.Fprintf(bw, "diago_jitter_buffer_ssrc_resets_total %d\n", jitterStats.SSRCResets)
+
+	header("diago_bridge_participants", "gauge", "Number of dialogs in bridge.")
+	for _, e := range bridges {
+		fmt.Fprintf(bw, "diago_bridge_participants{bridge=%q} %d\n", e.key, len(e.value.DialogSessionsList()))
+	}
+	return bw.Flush()
+}
+
+type metricsEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func sortedMetrics[K comparable, V any](m map[K]V, sortKey func(k K) string) []metricsEntry[K, V] {
+	entries := make([]metricsEntry[K, V], 0, len(m))
+	for k, v := range m {
+		entries = append(entries, metricsEntry[K, V]{key: k, value: v})
+	}
+	slices.SortFunc(entries, func(a, b metricsEntry[K, V]) int {
+		return strings.Compare(sortKey(a.key), sortKey(b.key))
+	})
+	return entries
+}
+
+// metricsHistogram is cumulative histogram
+type metricsHistogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newMetricsHistogram(buckets []float64) metricsHistogram {
+	return metricsHistogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *metricsHistogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h metricsHistogram) clone() metricsHistogram {
+	h.counts = slices.Clone(h.counts)
+	return h
+}
+
+func (h metricsHistogram) write(w io.Writer, name string) {
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(b, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+func addJitterBufferStatistics(s *media.RTPJitterBufferStatistics, add media.RTPJitterBufferStatistics) {
+	s.PacketsRead += add.PacketsRead
+	s.PacketsReleased += add.PacketsReleased
+	s.PacketsLost += add.PacketsLost
+	s.PacketsLate += add.PacketsLate
+	s.PacketsDuplicate += add.PacketsDuplicate
+	s.PacketsDropped += add.PacketsDropped
+	s.SSRCResets += add.SSRCResets
+}
+
+func inviteResponseClass(statusCode int) string {
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+func (dg *Diago) trackServerMetrics(d *DialogServerSession) {
+	if dg.metrics == nil {
+		return
+	}
+	dg.metrics.trackDialog(metricsDirectionServer, d.InviteRequest.Transport(), d, func() *sip.Response {
+		return d.InviteResponse
+	})
+}
+
+func (dg *Diago) trackClientMetrics(d *DialogClientSession, transport string) {
+	if dg.metrics == nil {
+		return
+	}
+	dg.metrics.trackDialog(metricsDirectionClient, transport, d, func() *sip.Response {
+		return d.InviteResponse
+	})
+}
+
+// trackDialog counts dialog until it is closed. Final response of INVITE is counted on close
+func (m *Metrics) trackDialog(direction string, transport string, d DialogSession, inviteResponse func() *sip.Response) {
+	key := metricsDialogKey{direction: direction, transport: strings.ToLower(transport)}
+	m.mu.Lock()
+	m.dialogsActive[key]++
+	m.dialogsTotal[key]++
+	m.mu.Unlock()
+
+	d.Media().OnClose(func() error {
+		res := inviteResponse()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.dialogsActive[key]--
+		if res != nil && !res.IsProvisional() {
+			m.inviteResponses[metricsResponseKey{direction: direction, class: inviteResponseClass(res.StatusCode)}]++
+		}
+		return nil
+	})
+}
+
+func (m *Metrics) setRegistered(aor string, contact string, registered bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registrations[metricsRegistrationKey{aor: aor, contact: contact}] = registered
+}
+
+func (m *Metrics) trackJitterBuffer(j *media.RTPJitterBuffer) (untrack func() error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jitterBuffers[j] = struct{}{}
+	return func() error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, exists := m.jitterBuffers[j]; !exists {
+			return nil
+		}
+		delete(m.jitterBuffers, j)
+		addJitterBufferStatistics(&m.jitterBuffersClosed, j.Statistics())
+		return nil
+	}
+}
+
+// rtpMetrics aggregates RTP statistics of dialog media. RTP sessions can be replaced during dialog,
+// so counters are added as difference to last seen value
+type rtpMetrics struct {
+	m *Metrics
+
+	mu            sync.Mutex
+	readSSRC      uint32
+	readPackets   uint64
+	writeSSRC     uint32
+	writePackets  uint64
+	lostSSRC      uint32
+	lost          uint32
+	readClockRate uint32
+}
+
+func (r *rtpMetrics) read(stats media.RTPReadStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if stats.SSRC != r.readSSRC || stats.PacketsCount < r.readPackets {
+		r.readSSRC, r.readPackets = stats.SSRC, 0
+	}
+	received := stats.PacketsCount - r.readPackets
+	r.readPackets = stats.PacketsCount
+	if stats.SampleRate > 0 {
+		r.readClockRate = stats.SampleRate
+	}
+
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.rtpPacketsReceived += received
+}
+
+func (r *rtpMetrics) write(stats media.RTPWriteStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if stats.SSRC != r.writeSSRC || stats.PacketsCount < r.writePackets {
+		r.writeSSRC, r.writePackets = stats.SSRC, 0
+	}
+	sent := stats.PacketsCount - r.writePackets
+	r.writePackets = stats.PacketsCount
+
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.rtpPacketsSent += sent
+}
+
+// onReadRTCP observes round trip time. It is calculated from reception reports of previous RTCP
+func (r *rtpMetrics) onReadRTCP(pkt rtcp.Packet, stats media.RTPReadStats) {
+	if media.DefaultOnReadRTCP != nil {
+		media.DefaultOnReadRTCP(pkt, stats)
+	}
+
+	r.read(stats)
+	if stats.RTT > 0 {
+		r.m.mu.Lock()
+		r.m.rtpRTT.observe(stats.RTT.Seconds())
+		r.m.mu.Unlock()
+	}
+}
+
+// onWriteRTCP observes loss and jitter of received stream from reception reports we send
+func (r *rtpMetrics) onWriteRTCP(pkt rtcp.Packet, stats media.RTPWriteStats) {
+	if media.DefaultOnWriteRTCP != nil {
+		media.DefaultOnWriteRTCP(pkt, stats)
+	}
+
+	r.write(stats)
+	var reports []rtcp.ReceptionReport
+	switch p := pkt.(type) {
+	case *rtcp.SenderReport:
+		reports = p.Reports
+	case *rtcp.ReceiverReport:
+		reports = p.Reports
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rr := range reports {
+		if rr.SSRC != r.lostSSRC || rr.TotalLost < r.lost {
+			r.lostSSRC, r.lost = rr.SSRC, 0
+		}
+		lost := rr.TotalLost - r.lost
+		r.lost = rr.TotalLost
+
+		r.m.mu.Lock()
+		r.m.rtpPacketsLost += uint64(lost)
+		if r.readClockRate > 0 {
+			r.m.rtpJitter.observe(float64(rr.Jitter) / float64(r.readClockRate))
+		}
+		r.m.mu.Unlock()
+	}
+}
+
+// trackRTPSessionUnsafe attaches RTCP hooks on RTP session of dialog media
+func (d *DialogMedia) trackRTPSessionUnsafe(rtpSess *media.RTPSession) {
+	if d.metrics == nil {
+		return
+	}
+
+	if d.rtpMetrics == nil {
+		d.rtpMetrics = &rtpMetrics{m: d.metrics}
+		// Final counters are taken on close, as RTCP is sent only periodically
+		d.onCloseUnsafe(func() error {
+			d.mu.Lock()
+			rtpSess := d.rtpSession
+			d.mu.Unlock()
+			if rtpSess != nil {
+				d.rtpMetrics.read(rtpSess.ReadStats())
+				d.rtpMetrics.write(rtpSess.WriteStats())
+			}
+			return nil
+		})
+	}
+	rtpSess.OnReadRTCP(d.rtpMetrics.onReadRTCP)
+	rtpSess.OnWriteRTCP(d.rtpMetrics.onWriteRTCP)
+}
@@ -0,0 +1,193 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emiago/diago/media"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/pion/rtcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func metricsOutput(t *testing.T, m *Metrics) string {
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, m.Write(buf))
+	return buf.String()
+}
+
+func TestMetricsRTP(t *testing.T) {
+	m := NewMetrics()
+	r := &rtpMetrics{m: m}
+
+	r.onReadRTCP(&rtcp.SenderReport{}, media.RTPReadStats{SSRC: 1, PacketsCount: 100, SampleRate: 8000, RTT: 30 * time.Millisecond})
+	r.onWriteRTCP(&rtcp.SenderReport{Reports: []rtcp.ReceptionReport{{SSRC: 1, TotalLost: 3, Jitter: 40}}}, media.RTPWriteStats{SSRC: 2, PacketsCount: 90})
+	r.onReadRTCP(&rtcp.SenderReport{}, media.RTPReadStats{SSRC: 1, PacketsCount: 150, SampleRate: 8000})
+	r.onWriteRTCP(&rtcp.ReceiverReport{Reports: []rtcp.ReceptionReport{{SSRC: 1, TotalLost: 5, Jitter: 80}}}, media.RTPWriteStats{SSRC: 2, PacketsCount: 140})
+	// New RTP session starts counting from zero
+	r.read(media.RTPReadStats{SSRC: 3, PacketsCount: 10})
+
+	out := metricsOutput(t, m)
+	assert.Contains(t, out, "diago_rtp_packets_received_total 160\n")
+	assert.Contains(t, out, "diago_rtp_packets_sent_total 140\n")
+	assert.Contains(t, out, "diago_rtp_packets_lost_total 5\n")
+	// Jitter 40 and 80 at 8000 clock rate are 5ms and 10ms
+	assert.Contains(t, out, "diago_rtp_jitter_seconds_bucket{le=\"0.005\"} 1\n")
+	assert.Contains(t, out, "diago_rtp_jitter_seconds_bucket{le=\"0.01\"} 2\n")
+	assert.Contains(t, out, "diago_rtp_jitter_seconds_count 2\n")
+	assert.Contains(t, out, "diago_rtp_rtt_seconds_bucket{le=\"0.05\"} 1\n")
+	assert.Contains(t, out, "diago_rtp_rtt_seconds_bucket{le=\"+Inf\"} 1\n")
+}
+
+func TestMetricsBridgeMix(t *testing.T) {
+	m := NewMetrics()
+	m.RegisterBridgeMix("conference", NewBridgeMix())
+	assert.Contains(t, metricsOutput(t, m), "diago_bridge_participants{bridge=\"conference\"} 0\n")
+
+	m.UnregisterBridgeMix("conference")
+	assert.NotContains(t, metricsOutput(t, m), "conference")
+}
+
+// metricsFailingStore fails storing bindings when fail is set
+type metricsFailingStore struct {
+	registrarStoreMap
+	fail atomic.Bool
+}
+
+func (s *metricsFailingStore) BindingStore(ctx context.Context, b RegistrarBinding) error {
+	if s.fail.Load() {
+		return errors.New("store failed")
+	}
+	return s.registrarStoreMap.BindingStore(ctx, b)
+}
+
+func TestMetricsRegistrations(t *testing.T) {
+	m := NewMetrics()
+	m.setRegistered("sip:1001@127.0.0.1", "sip:1001@127.0.0.1:5060", true)
+	m.setRegistered("sip:1001@127.0.0.1", "sip:1001@127.0.0.1:5070", false)
+
+	// Same AOR registered with other contact does not change state
+	out := metricsOutput(t, m)
+	assert.Contains(t, out, "diago_registration_registered{aor=\"sip:1001@127.0.0.1\",contact=\"sip:1001@127.0.0.1:5060\"} 1\n")
+	assert.Contains(t, out, "diago_registration_registered{aor=\"sip:1001@127.0.0.1\",contact=\"sip:1001@127.0.0.1:5070\"} 0\n")
+}
+
+func TestIntegrationMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverMetrics := NewMetrics()
+	registrarStore := &metricsFailingStore{}
+	{
+		ua, _ := sipgo.NewUA()
+		defer ua.Close()
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15127,
+			},
+		), WithMetrics(serverMetrics), WithRegistrar(NewRegistrar(RegistrarOptions{Store: registrarStore})))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			if d.ToUser() == "busy" {
+				d.Respond(sip.StatusBusyHere, "Busy Here", nil)
+				return
+			}
+			if err := d.Answer(); err != nil {
+				return
+			}
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	clientMetrics := NewMetrics()
+	ua, _ := sipgo.NewUA(sipgo.WithUserAgent("1001"))
+	defer ua.Close()
+	dg := NewDiago(ua, WithTransport(
+		Transport{
+			Transport: "udp",
+			BindHost:  "127.0.0.1",
+			BindPort:  15128,
+		},
+	), WithMetrics(clientMetrics))
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	t.Run("Dialogs", func(t *testing.T) {
+		d, err := dg.Invite(ctx, sip.Uri{User: "bob", Host: "127.0.0.1", Port: 15127}, InviteOptions{})
+		require.NoError(t, err)
+		assert.Contains(t, metricsOutput(t, clientMetrics), "diago_dialogs_active{direction=\"client\",transport=\"udp\"} 1\n")
+
+		w, err := d.AudioWriter()
+		require.NoError(t, err)
+		for i := 0; i < 5; i++ {
+			_, err := w.Write(make([]byte, 160))
+			require.NoError(t, err)
+		}
+		require.NoError(t, d.Hangup(ctx))
+		require.NoError(t, d.Close())
+
+		_, err = dg.Invite(ctx, sip.Uri{User: "busy", Host: "127.0.0.1", Port: 15127}, InviteOptions{})
+		require.Error(t, err)
+
+		out := metricsOutput(t, clientMetrics)
+		assert.Contains(t, out, "diago_dialogs_active{direction=\"client\",transport=\"udp\"} 0\n")
+		assert.Contains(t, out, "diago_dialogs_total{direction=\"client\",transport=\"udp\"} 2\n")
+		assert.Contains(t, out, "diago_invite_responses_total{direction=\"client\",class=\"2xx\"} 1\n")
+		assert.Contains(t, out, "diago_invite_responses_total{direction=\"client\",class=\"4xx\"} 1\n")
+		assert.Contains(t, out, "diago_rtp_packets_sent_total 5\n")
+
+		require.Eventually(t, func() bool {
+			out := metricsOutput(t, serverMetrics)
+			return bytes.Contains([]byte(out), []byte("diago_dialogs_active{direction=\"server\",transport=\"udp\"} 0\n")) &&
+				bytes.Contains([]byte(out), []byte("diago_invite_responses_total{direction=\"server\",class=\"4xx\"} 1\n"))
+		}, time.Second, 50*time.Millisecond)
+		assert.Contains(t, metricsOutput(t, serverMetrics), "diago_dialogs_total{direction=\"server\",transport=\"udp\"} 2\n")
+	})
+
+	t.Run("Registration", func(t *testing.T) {
+		aor := sip.Uri{User: "1001", Host: "127.0.0.1", Port: 15127}
+		tx, err := dg.RegisterTransaction(ctx, aor, RegisterOptions{Expiry: time.Minute})
+		require.NoError(t, err)
+		require.NoError(t, tx.Register(ctx))
+		assert.Regexp(t, `diago_registration_registered\{aor="sip:1001@127.0.0.1:15127",contact="[^"]+"\} 1\n`, metricsOutput(t, clientMetrics))
+
+		require.NoError(t, tx.Unregister(ctx))
+		assert.Regexp(t, `diago_registration_registered\{aor="sip:1001@127.0.0.1:15127",contact="[^"]+"\} 0\n`, metricsOutput(t, clientMetrics))
+	})
+
+	t.Run("RegistrationRefreshFailed", func(t *testing.T) {
+		aor := sip.Uri{User: "1001", Host: "127.0.0.1", Port: 15127}
+		tx, err := dg.RegisterTransaction(ctx, aor, RegisterOptions{Expiry: time.Minute, RetryInterval: 50 * time.Millisecond})
+		require.NoError(t, err)
+		require.NoError(t, tx.Register(ctx))
+		_, registered := dg.registrations.Load(tx)
+		assert.True(t, registered)
+
+		registrarStore.fail.Store(true)
+		defer registrarStore.fail.Store(false)
+		require.Error(t, tx.QualifyLoop(ctx))
+		assert.Regexp(t, `diago_registration_registered\{aor="sip:1001@127.0.0.1:15127",contact="[^"]+"\} 0\n`, metricsOutput(t, clientMetrics))
+		_, registered = dg.registrations.Load(tx)
+		assert.False(t, registered)
+	})
+
+	t.Run("HTTP", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		clientMetrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		assert.Equal(t, 200, rec.Code)
+		assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain; version=0.0.4")
+		assert.Contains(t, rec.Body.String(), "# TYPE diago_dialogs_total counter\n")
+	})
+}
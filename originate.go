@@ -0,0 +1,308 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/emiago/diago/media"
+	"github.com/emiago/sipgo/sip"
+)
+
+// OriginateLeg identifies party of Originate. LegA is called first, ex. agent in click-to-call
+type OriginateLeg int
+
+const (
+	OriginateLegA OriginateLeg = iota
+	OriginateLegB
+)
+
+func (l OriginateLeg) String() string {
+	if l == OriginateLegB {
+		return "B"
+	}
+	return "A"
+}
+
+type OriginateEventType int
+
+const (
+	OriginateEventRinging OriginateEventType = iota + 1
+	OriginateEventAnswered
+	OriginateEventFailed
+	OriginateEventHangup
+)
+
+func (t OriginateEventType) String() string {
+	switch t {
+	case OriginateEventRinging:
+		return "ringing"
+	case OriginateEventAnswered:
+		return "answered"
+	case OriginateEventFailed:
+		return "failed"
+	case OriginateEventHangup:
+		return "hangup"
+	}
+	return ""
+}
+
+// OriginateEvent is call progress of single Originate leg
+type OriginateEvent struct {
+	Leg  OriginateLeg
+	Type OriginateEventType
+	// Response is provisional response on ringing, and final response on failure if received
+	Response *sip.Response
+	// Err is set on failure
+	Err error
+}
+
+// OriginateEndpoint is single party called by Originate
+type OriginateEndpoint struct {
+	Recipient sip.Uri
+	// Transport or protocol that should be used
+	Transport string
+	// TransportID matches diago transport by ID instead protocol
+	TransportID string
+	// For digest authentication
+	Username string
+	Password string
+	// Custom headers to pass. DO NOT SET THIS to nil
+	Headers []sip.Header
+}
+
+type OriginateOptions struct {
+	// Announcement is WAV file played to party A after answer, before party B is called
+	Announcement string
+	// Ringback plays ringtone to party A while party B is called
+	Ringback bool
+	// OnEvent is called on progress of each leg. It should not block
+	OnEvent func(ev OriginateEvent)
+}
+
+// OriginateSession is handle of bridged Originate call
+type OriginateSession struct {
+	LegA *DialogClientSession
+	LegB *DialogClientSession
+
+	bridge Bridge
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	states [2]OriginateEventType
+	events chan OriginateEvent
+}
+
+// Events returns hangup events of legs received after session is established.
+// Channel is closed when both legs are terminated
+func (s *OriginateSession) Events() <-chan OriginateEvent {
+	return s.events
+}
+
+// LegState returns type of last event of leg
+func (s *OriginateSession) LegState(leg OriginateLeg) OriginateEventType {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.states[leg]
+}
+
+// Context is done when both legs are terminated
+func (s *OriginateSession) Context() context.Context {
+	return s.ctx
+}
+
+// Hangup terminates both legs
+func (s *OriginateSession) Hangup(ctx context.Context) error {
+	return s.HangupOptions(ctx, HangupOptions{})
+}
+
+// HangupOptions terminates both legs with same hangup options
+func (s *OriginateSession) HangupOptions(ctx context.Context, opts HangupOptions) error {
+	return errors.Join(
+		s.LegA.HangupOptions(ctx, opts),
+		s.LegB.HangupOptions(ctx, opts),
+	)
+}
+
+// Close closes both legs. It should be called when done with session
+func (s *OriginateSession) Close() error {
+	return errors.Join(s.LegA.Close(), s.LegB.Close())
+}
+
+// Originate makes third party call control call between two endpoints, ex. click-to-call.
+// Party A is called without SDP offer, and party B is called preferring codec of party A answer.
+// Media of both legs is anchored and bridged by diago. If party B answers with different codec,
+// party A is re-invited with it, so that bridge does not transcode.
+//
+// Hangup of one party terminates the other.
+// Returned session must be closed.
+func (dg *Diago) Originate(ctx context.Context, a OriginateEndpoint, b OriginateEndpoint, opts OriginateOptions) (*OriginateSession, error) {
+	s := &OriginateSession{
+		events: make(chan OriginateEvent, 2),
+	}
+	emit := func(ev OriginateEvent) {
+		s.mu.Lock()
+		s.states[ev.Leg] = ev.Type
+		s.mu.Unlock()
+		if opts.OnEvent != nil {
+			opts.OnEvent(ev)
+		}
+	}
+
+	legA, err := dg.originateInvite(ctx, OriginateLegA, a, nil, emit)
+	if err != nil {
+		return nil, err
+	}
+
+	terminate := func(err error, legs ...*DialogClientSession) error {
+		hctx, cancel := context.WithTimeout(context.Background(), sip.Timer_F)
+		defer cancel()
+		for i, d := range legs {
+			if d == nil {
+				continue
+			}
+			err = errors.Join(err, d.Hangup(hctx), d.Close())
+			emit(OriginateEvent{Leg: OriginateLeg(i), Type: OriginateEventHangup})
+		}
+		return err
+	}
+
+	if opts.Announcement != "" {
+		pb, err := legA.PlaybackCreate()
+		if err == nil {
+			_, err = pb.PlayFile(opts.Announcement)
+		}
+		if err != nil {
+			return nil, terminate(err, legA)
+		}
+	}
+
+	var stopRingback func() error
+	if opts.Ringback {
+		ringtone, err := legA.PlaybackRingtoneCreate()
+		if err == nil {
+			stopRingback, err = ringtone.PlayBackground()
+		}
+		if err != nil {
+			return nil, terminate(err, legA)
+		}
+	}
+
+	// Party B is canceled if party A hangups while waiting answer
+	codec := media.CodecAudioFromSession(legA.MediaSession())
+	ctxB, cancelB := context.WithCancel(ctx)
+	stop := context.AfterFunc(legA.Context(), cancelB)
+	legB, err := dg.originateInvite(ctxB, OriginateLegB, b, &codec, emit)
+	stop()
+	cancelB()
+	if stopRingback != nil {
+		err = errors.Join(err, stopRingback())
+	}
+	if err != nil {
+		return nil, terminate(err, legA, legB)
+	}
+
+	if codecB := media.CodecAudioFromSession(legB.MediaSession()); codecB != codec {
+		m := legA.MediaSession().Fork()
+		m.Codecs = originateCodecs(m.Codecs, codecB, true)
+		if err := legA.reInviteMediaSession(ctx, m); err != nil {
+			return nil, terminate(err, legA, legB)
+		}
+	}
+
+	s.LegA, s.LegB = legA, legB
+	s.bridge = NewBridge()
+	if err := s.bridge.AddDialogSession(legA); err != nil {
+		return nil, terminate(err, legA, legB)
+	}
+	if err := s.bridge.AddDialogSession(legB); err != nil {
+		return nil, terminate(err, legA, legB)
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	watch := func(leg OriginateLeg, d *DialogClientSession, other *DialogClientSession) {
+		defer wg.Done()
+		<-d.Context().Done()
+		ev := OriginateEvent{Leg: leg, Type: OriginateEventHangup}
+		emit(ev)
+		s.events <- ev
+
+		// Hangup of one party terminates other
+		hctx, cancel := context.WithTimeout(context.Background(), sip.Timer_F)
+		defer cancel()
+		if err := other.Hangup(hctx); err != nil {
+			dg.log.Info("Originate hangup failed", "error", err, "id", other.Id())
+		}
+	}
+	wg.Add(2)
+	go watch(OriginateLegA, legA, legB)
+	go watch(OriginateLegB, legB, legA)
+	go func() {
+		wg.Wait()
+		close(s.events)
+		s.cancel()
+	}()
+	return s, nil
+}
+
+// originateInvite calls single leg. Party A is called with late offer, while party B is offered codec of party A first
+func (dg *Diago) originateInvite(ctx context.Context, leg OriginateLeg, ep OriginateEndpoint, codec *media.Codec, emit func(ev OriginateEvent)) (*DialogClientSession, error) {
+	d, err := dg.newDialog(ctx, ep.Recipient, NewDialogOptions{Transport: ep.Transport, TransportID: ep.TransportID})
+	if err != nil {
+		emit(OriginateEvent{Leg: leg, Type: OriginateEventFailed, Err: err})
+		return nil, err
+	}
+
+	err = func() error {
+		if codec != nil {
+			if err := d.initMediaSessionFromConf(d.mediaConfig); err != nil {
+				return err
+			}
+			d.mediaSession.Codecs = originateCodecs(d.mediaSession.Codecs, *codec, false)
+		}
+
+		if err := d.Invite(ctx, InviteClientOptions{
+			OnResponse: func(res *sip.Response) error {
+				switch res.StatusCode {
+				case sip.StatusRinging, sip.StatusSessionInProgress:
+					emit(OriginateEvent{Leg: leg, Type: OriginateEventRinging, Response: res})
+				}
+				return nil
+			},
+			Username:  ep.Username,
+			Password:  ep.Password,
+			Headers:   ep.Headers,
+			LateOffer: codec == nil,
+		}); err != nil {
+			return err
+		}
+		return d.Ack(ctx)
+	}()
+	if err != nil {
+		emit(OriginateEvent{Leg: leg, Type: OriginateEventFailed, Response: dialogErrorResponse(err), Err: err})
+		return nil, errors.Join(err, d.Close())
+	}
+
+	emit(OriginateEvent{Leg: leg, Type: OriginateEventAnswered})
+	return d, nil
+}
+
+// originateCodecs puts audio codec first. With only set, other audio codecs are removed
+func originateCodecs(codecs []media.Codec, codec media.Codec, only bool) []media.Codec {
+	result := []media.Codec{codec}
+	for _, c := range codecs {
+		if c == codec {
+			continue
+		}
+		if only && c.Name != "telephone-event" {
+			continue
+		}
+		result = append(result, c)
+	}
+	return result
+}
@@ -0,0 +1,214 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiago/diago/media"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationOriginate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Agent reports was it called with late offer and audio received from customer
+	agentLateOffer := make(chan bool, 1)
+	agentAudio := make(chan []byte, 1)
+	{
+		ua, _ := sipgo.NewUA()
+		defer ua.Close()
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15136,
+			},
+		))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			lateOffer := d.InviteRequest.Body() == nil
+			agentLateOffer <- lateOffer
+			answer := d.Answer
+			if lateOffer {
+				answer = d.AnswerLate
+			}
+			if err := answer(); err != nil {
+				return
+			}
+
+			r, err := d.AudioReader()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, media.RTPBufSize)
+			n, err := r.Read(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case agentAudio <- buf[:n]:
+			default:
+			}
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	payload := []byte("customer")
+	{
+		ua, _ := sipgo.NewUA()
+		defer ua.Close()
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15137,
+			},
+		))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			if d.ToUser() == "busy" {
+				d.Respond(sip.StatusBusyHere, "Busy Here", nil)
+				return
+			}
+
+			// Customer supports only alaw, so agent must be re-invited
+			if err := d.AnswerOptions(AnswerOptions{Codecs: []media.Codec{media.CodecAudioAlaw, media.CodecTelephoneEvent8000}}); err != nil {
+				return
+			}
+			if d.ToUser() == "hangup" {
+				time.Sleep(200 * time.Millisecond)
+				d.Hangup(d.Context())
+				return
+			}
+
+			w, err := d.AudioWriter()
+			if err != nil {
+				return
+			}
+			for d.Context().Err() == nil {
+				if _, err := w.Write(payload); err != nil {
+					return
+				}
+			}
+		})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	agent := OriginateEndpoint{Recipient: sip.Uri{User: "agent", Host: "127.0.0.1", Port: 15136}}
+	customer := func(user string) OriginateEndpoint {
+		return OriginateEndpoint{Recipient: sip.Uri{User: user, Host: "127.0.0.1", Port: 15137}}
+	}
+
+	type legEvent struct {
+		leg OriginateLeg
+		typ OriginateEventType
+	}
+	collect := func(events chan legEvent) OriginateOptions {
+		return OriginateOptions{
+			OnEvent: func(ev OriginateEvent) {
+				events <- legEvent{ev.Leg, ev.Type}
+			},
+		}
+	}
+	waitEvent := func(t *testing.T, events chan legEvent) legEvent {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(2 * time.Second):
+			t.Fatal("no originate event")
+		}
+		return legEvent{}
+	}
+	waitDone := func(t *testing.T, s *OriginateSession) {
+		select {
+		case <-s.Context().Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("originate session not terminated")
+		}
+	}
+
+	t.Run("Bridge", func(t *testing.T) {
+		events := make(chan legEvent, 10)
+		s, err := dg.Originate(ctx, agent, customer("customer"), collect(events))
+		require.NoError(t, err)
+		defer s.Close()
+
+		assert.True(t, <-agentLateOffer)
+		assert.Equal(t, legEvent{OriginateLegA, OriginateEventAnswered}, waitEvent(t, events))
+		assert.Equal(t, legEvent{OriginateLegB, OriginateEventAnswered}, waitEvent(t, events))
+		assert.Equal(t, media.CodecAudioAlaw, media.CodecAudioFromSession(s.LegA.MediaSession()))
+		assert.Equal(t, media.CodecAudioAlaw, media.CodecAudioFromSession(s.LegB.MediaSession()))
+
+		select {
+		case b := <-agentAudio:
+			assert.Equal(t, payload, b)
+		case <-time.After(2 * time.Second):
+			t.Fatal("agent did not receive customer audio")
+		}
+
+		assert.Equal(t, OriginateEventAnswered, s.LegState(OriginateLegA))
+		assert.Equal(t, OriginateEventAnswered, s.LegState(OriginateLegB))
+
+		require.NoError(t, s.Hangup(ctx))
+		waitDone(t, s)
+		hangups := []legEvent{waitEvent(t, events), waitEvent(t, events)}
+		assert.ElementsMatch(t, []legEvent{{OriginateLegA, OriginateEventHangup}, {OriginateLegB, OriginateEventHangup}}, hangups)
+
+		// Same events are on session handle
+		hangups = hangups[:0]
+		for ev := range s.Events() {
+			hangups = append(hangups, legEvent{ev.Leg, ev.Type})
+		}
+		assert.ElementsMatch(t, []legEvent{{OriginateLegA, OriginateEventHangup}, {OriginateLegB, OriginateEventHangup}}, hangups)
+		assert.Equal(t, OriginateEventHangup, s.LegState(OriginateLegA))
+		assert.Equal(t, OriginateEventHangup, s.LegState(OriginateLegB))
+	})
+
+	t.Run("RemoteHangup", func(t *testing.T) {
+		s, err := dg.Originate(ctx, agent, customer("hangup"), OriginateOptions{Ringback: true})
+		require.NoError(t, err)
+		defer s.Close()
+		<-agentLateOffer
+
+		// Customer hangup terminates agent
+		waitDone(t, s)
+		assert.Error(t, s.LegA.Context().Err())
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		events := make(chan legEvent, 10)
+		_, err := dg.Originate(ctx, agent, customer("busy"), collect(events))
+		require.Error(t, err)
+		assert.Equal(t, sip.StatusBusyHere, dialogErrorResponse(err).StatusCode)
+		<-agentLateOffer
+
+		assert.Equal(t, legEvent{OriginateLegA, OriginateEventAnswered}, waitEvent(t, events))
+		assert.Equal(t, legEvent{OriginateLegB, OriginateEventFailed}, waitEvent(t, events))
+		assert.Equal(t, legEvent{OriginateLegA, OriginateEventHangup}, waitEvent(t, events))
+	})
+}
+
+func TestOriginateCodecs(t *testing.T) {
+	codecs := []media.Codec{media.CodecAudioUlaw, media.CodecAudioAlaw, media.CodecTelephoneEvent8000}
+	assert.Equal(t,
+		[]media.Codec{media.CodecAudioAlaw, media.CodecAudioUlaw, media.CodecTelephoneEvent8000},
+		originateCodecs(codecs, media.CodecAudioAlaw, false),
+	)
+	assert.Equal(t,
+		[]media.Codec{media.CodecAudioAlaw, media.CodecTelephoneEvent8000},
+		originateCodecs(codecs, media.CodecAudioAlaw, true),
+	)
+}
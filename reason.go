@@ -0,0 +1,312 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+const (
+	ReasonProtocolQ850 = "Q.850"
+	ReasonProtocolSIP  = "SIP"
+)
+
+// Common Q.850 cause values
+const (
+	Q850UnallocatedNumber      = 1
+	Q850NormalClearing         = 16
+	Q850UserBusy               = 17
+	Q850NoUserResponding       = 18
+	Q850NoAnswer               = 19
+	Q850CallRejected           = 21
+	Q850NumberChanged          = 22
+	Q850DestinationOutOfOrder  = 27
+	Q850InvalidNumberFormat    = 28
+	Q850NormalUnspecified      = 31
+	Q850NoCircuitAvailable     = 34
+	Q850NetworkOutOfOrder      = 38
+	Q850TemporaryFailure       = 41
+	Q850SwitchingEquipmentBusy = 42
+	Q850BearerNotAvailable     = 58
+	Q850InterworkingError      = 127
+)
+
+// Reason is Reason header (RFC 3326) carrying Q.850 or SIP cause of call termination
+type Reason struct {
+	// Protocol is Q.850 or SIP
+	Protocol string
+	Cause    int
+	Text     string
+}
+
+// ReasonQ850 creates reason with ISDN cause code
+func ReasonQ850(cause int, text string) Reason {
+	return Reason{Protocol: ReasonProtocolQ850, Cause: cause, Text: text}
+}
+
+// ReasonSIP creates reason with SIP status code
+func ReasonSIP(statusCode int, text string) Reason {
+	return Reason{Protocol: ReasonProtocolSIP, Cause: statusCode, Text: text}
+}
+
+// String returns Reason header value
+func (r Reason) String() string {
+	s := r.Protocol + ";cause=" + strconv.Itoa(r.Cause)
+	if r.Text != "" {
+		s += ";text=" + strconv.Quote(r.Text)
+	}
+	return s
+}
+
+func (r Reason) Header() sip.Header {
+	return sip.NewHeader("Reason", r.String())
+}
+
+// ParseReason parses single Reason header value
+func ParseReason(value string) (Reason, error) {
+	r := Reason{}
+	protocol, params, _ := strings.Cut(value, ";")
+	r.Protocol = strings.TrimSpace(protocol)
+	if r.Protocol == "" {
+		return r, fmt.Errorf("reason has no protocol")
+	}
+
+	hasCause := false
+	for params != "" {
+		var param string
+		param, params = cutReasonParam(params)
+		name, val, _ := strings.Cut(param, "=")
+		name, val = strings.TrimSpace(name), strings.TrimSpace(val)
+		switch strings.ToLower(name) {
+		case "cause":
+			cause, err := strconv.Atoi(val)
+			if err != nil {
+				return r, fmt.Errorf("reason has invalid cause %q", val)
+			}
+			r.Cause, hasCause = cause, true
+		case "text":
+			if text, err := strconv.Unquote(val); err == nil {
+				val = text
+			}
+			r.Text = strings.Trim(val, `"`)
+		}
+	}
+	if !hasCause {
+		return r, fmt.Errorf("reason has no cause")
+	}
+	return r, nil
+}
+
+// cutReasonParam cuts first parameter, ignoring separators within quoted text
+func cutReasonParam(params string) (param string, rest string) {
+	quoted := false
+	for i, c := range params {
+		switch {
+		case c == '"' && (i == 0 || params[i-1] != '\\'):
+			quoted = !quoted
+		case c == ';' && !quoted:
+			return params[:i], params[i+1:]
+		}
+	}
+	return params, ""
+}
+
+// reasonFromMessage parses Reason headers of message. Q.850 reason is preferred when multiple are present
+func reasonFromMessage(msg sip.Message) (Reason, bool) {
+	var found *Reason
+	for _, h := range msg.GetHeaders("Reason") {
+		for _, v := range splitReasonValues(h.Value()) {
+			r, err := ParseReason(v)
+			if err != nil {
+				continue
+			}
+			if r.Protocol == ReasonProtocolQ850 {
+				return r, true
+			}
+			if found == nil {
+				found = &r
+			}
+		}
+	}
+	if found == nil {
+		return Reason{}, false
+	}
+	return *found, true
+}
+
+// splitReasonValues splits comma separated Reason values, ignoring commas within quoted text
+func splitReasonValues(value string) []string {
+	var values []string
+	quoted := false
+	start := 0
+	for i, c := range value {
+		switch {
+		case c == '"' && (i == 0 || value[i-1] != '\\'):
+			quoted = !quoted
+		case c == ',' && !quoted:
+			values = append(values, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(values, value[start:])
+}
+
+// ReasonFromError returns Reason header of final response when error is sipgo.ErrDialogResponse,
+// ex. returned by Invite
+func ReasonFromError(err error) (Reason, bool) {
+	res := dialogErrorResponse(err)
+	if res == nil {
+		return Reason{}, false
+	}
+	return reasonFromMessage(res)
+}
+
+// HangupOptions for hanguping dialog
+type HangupOptions struct {
+	// Reason is added as Reason header. If nil reason carried from bridged dialog is used
+	Reason *Reason
+	// Headers are added to BYE
+	Headers []sip.Header
+}
+
+// setRemoteReason stores reason received from remote and carries it to bridged dialogs
+func (d *DialogMedia) setRemoteReason(msg sip.Message) {
+	r, ok := reasonFromMessage(msg)
+	if !ok {
+		return
+	}
+
+	d.mu.Lock()
+	d.remoteReason = &r
+	onRemoteReason := d.onRemoteReason
+	d.mu.Unlock()
+
+	if onRemoteReason != nil {
+		onRemoteReason(r)
+	}
+}
+
+func (d *DialogMedia) getRemoteReason() (Reason, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.remoteReason == nil {
+		return Reason{}, false
+	}
+	return *d.remoteReason, true
+}
+
+// carryReason sets reason used on hangup when no reason is passed
+func (d *DialogMedia) carryReason(r Reason) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.carriedReason = &r
+}
+
+func (d *DialogMedia) onRemoteReasonUnsafe(f func(r Reason)) {
+	if d.onRemoteReason != nil {
+		prev := d.onRemoteReason
+		d.onRemoteReason = func(r Reason) {
+			prev(r)
+			f(r)
+		}
+		return
+	}
+	d.onRemoteReason = f
+}
+
+// hangupHeaders returns BYE headers with reason of hangup options or carried reason
+func (d *DialogMedia) hangupHeaders(opts HangupOptions) []sip.Header {
+	reason := opts.Reason
+	if reason == nil {
+		d.mu.Lock()
+		reason = d.carriedReason
+		d.mu.Unlock()
+	}
+
+	headers := opts.Headers
+	if reason != nil {
+		headers = append([]sip.Header{reason.Header()}, headers...)
+	}
+	return headers
+}
+
+// bridgeCarryReason carries reason received on one dialog to other, so it is used when other is hanguped
+func bridgeCarryReason(from DialogSession, to DialogSession) {
+	m := from.Media()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onRemoteReasonUnsafe(to.Media().carryReason)
+}
+
+// RemoteReason returns reason received with BYE or CANCEL
+func (d *DialogServerSession) RemoteReason() (Reason, bool) {
+	return d.getRemoteReason()
+}
+
+// RemoteReason returns reason received with BYE or final response
+func (d *DialogClientSession) RemoteReason() (Reason, bool) {
+	if r, ok := d.getRemoteReason(); ok {
+		return r, true
+	}
+
+	res := d.InviteResponse
+	if res == nil || res.IsProvisional() {
+		return Reason{}, false
+	}
+	return reasonFromMessage(res)
+}
+
+// HangupOptions hangups dialog with Reason header. Unanswered dialog is rejected with 480 Temporarily Unavailable.
+// It does nothing if dialog is already ended
+func (d *DialogServerSession) HangupOptions(ctx context.Context, opts HangupOptions) error {
+	headers := d.hangupHeaders(opts)
+	state := d.LoadState()
+	if state == sip.DialogStateEnded {
+		// Final response is already sent, ex. with Reject or Redirect, or dialog is terminated
+		return nil
+	}
+	if state < sip.DialogStateConfirmed {
+		return d.Respond(sip.StatusTemporarilyUnavailable, "Temporarly unavailable", nil, headers...)
+	}
+
+	// Same as sipgo Bye, extended with headers
+	req := d.InviteRequest
+	bye := sip.NewRequest(sip.BYE, req.Contact().Address)
+	bye.SetTransport(req.Transport())
+	for _, h := range headers {
+		bye.AppendHeader(h)
+	}
+	d.cdr.localBye(bye)
+	return d.WriteBye(ctx, bye)
+}
+
+// HangupOptions hangups dialog sending BYE with Reason header
+func (d *DialogClientSession) HangupOptions(ctx context.Context, opts HangupOptions) error {
+	headers := d.hangupHeaders(opts)
+
+	// Same as sipgo Bye, extended with headers
+	req, res := d.InviteRequest, d.InviteResponse
+	if res == nil {
+		return fmt.Errorf("bye: can not send as no invite response present")
+	}
+	recipient := req.Recipient
+	if cont := res.Contact(); cont != nil {
+		recipient = cont.Address
+	}
+	bye := sip.NewRequest(sip.BYE, *recipient.Clone())
+	bye.SetTransport(req.Transport())
+	if len(req.GetHeaders("Route")) > 0 {
+		sip.CopyHeaders("Route", req, bye)
+	}
+	for _, h := range headers {
+		bye.AppendHeader(h)
+	}
+	d.cdr.localBye(bye)
+	return d.WriteBye(ctx, bye)
+}
@@ -0,0 +1,179 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReason(t *testing.T) {
+	r, err := ParseReason(`Q.850;cause=16;text="Normal call clearing"`)
+	require.NoError(t, err)
+	assert.Equal(t, ReasonQ850(Q850NormalClearing, "Normal call clearing"), r)
+	assert.Equal(t, `Q.850;cause=16;text="Normal call clearing"`, r.String())
+
+	r, err = ParseReason(`SIP ; cause=486 ; text="Busy; here"`)
+	require.NoError(t, err)
+	assert.Equal(t, ReasonSIP(sip.StatusBusyHere, "Busy; here"), r)
+
+	r, err = ParseReason("SIP;cause=200")
+	require.NoError(t, err)
+	assert.Equal(t, ReasonSIP(sip.StatusOK, ""), r)
+
+	_, err = ParseReason(`Q.850;text="no cause"`)
+	require.Error(t, err)
+	_, err = ParseReason("Q.850;cause=abc")
+	require.Error(t, err)
+
+	t.Run("PreferQ850", func(t *testing.T) {
+		req := sip.NewRequest(sip.BYE, sip.Uri{Host: "127.0.0.1"})
+		req.AppendHeader(sip.NewHeader("Reason", `SIP;cause=600;text="Busy, Everywhere", Q.850;cause=17`))
+		r, ok := reasonFromMessage(req)
+		require.True(t, ok)
+		assert.Equal(t, ReasonQ850(Q850UserBusy, ""), r)
+	})
+}
+
+func TestIntegrationReason(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Callee hangups with cause or rejects
+	{
+		ua, _ := sipgo.NewUA()
+		defer ua.Close()
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15130,
+			},
+		))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			if d.ToUser() == "busy" {
+				d.Respond(sip.StatusBusyHere, "Busy Here", nil)
+				return
+			}
+			if err := d.Answer(); err != nil {
+				return
+			}
+			select {
+			case <-d.Context().Done():
+			case <-time.After(200 * time.Millisecond):
+				d.HangupOptions(ctx, HangupOptions{Reason: &Reason{Protocol: ReasonProtocolQ850, Cause: Q850UserBusy}})
+			}
+		})
+		require.NoError(t, err)
+	}
+
+	// B2BUA bridges calls to callee
+	serverReasons := make(chan Reason, 1)
+	{
+		ua, _ := sipgo.NewUA()
+		defer ua.Close()
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15129,
+			},
+		))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			switch d.ToUser() {
+			case "answer":
+				// BYE may arrive before ACK, terminating answer
+				d.Answer()
+				<-d.Context().Done()
+				r, _ := d.RemoteReason()
+				serverReasons <- r
+				return
+			case "reject":
+				d.Respond(sip.StatusForbidden, "Forbidden", nil, ReasonQ850(Q850CallRejected, "Call rejected").Header())
+				return
+			}
+
+			bridge := NewBridge()
+			if d.ToUser() != "busy" {
+				if err := d.Answer(); err != nil {
+					return
+				}
+				if err := bridge.AddDialogSession(d); err != nil {
+					return
+				}
+			} else {
+				bridge.Originator = d
+			}
+
+			out, err := dg.InviteBridge(d.Context(), sip.Uri{User: d.ToUser(), Host: "127.0.0.1", Port: 15130}, &bridge, InviteOptions{})
+			if err != nil {
+				return
+			}
+			defer out.Close()
+			select {
+			case <-out.Context().Done():
+			case <-d.Context().Done():
+			}
+		})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	t.Run("Hangup", func(t *testing.T) {
+		d, err := dg.Invite(ctx, sip.Uri{User: "answer", Host: "127.0.0.1", Port: 15129}, InviteOptions{})
+		require.NoError(t, err)
+		defer d.Close()
+
+		reason := ReasonQ850(Q850NormalClearing, "Normal call clearing")
+		require.NoError(t, d.HangupOptions(ctx, HangupOptions{Reason: &reason}))
+		select {
+		case r := <-serverReasons:
+			assert.Equal(t, reason, r)
+		case <-time.After(time.Second):
+			t.Fatal("no reason received")
+		}
+	})
+
+	t.Run("InviteFailure", func(t *testing.T) {
+		_, err := dg.Invite(ctx, sip.Uri{User: "reject", Host: "127.0.0.1", Port: 15129}, InviteOptions{})
+		require.Error(t, err)
+		r, ok := ReasonFromError(err)
+		require.True(t, ok)
+		assert.Equal(t, ReasonQ850(Q850CallRejected, "Call rejected"), r)
+	})
+
+	t.Run("BridgeHangup", func(t *testing.T) {
+		d, err := dg.Invite(ctx, sip.Uri{User: "bob", Host: "127.0.0.1", Port: 15129}, InviteOptions{})
+		require.NoError(t, err)
+		defer d.Close()
+
+		select {
+		case <-d.Context().Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("call not hanguped")
+		}
+		r, ok := d.RemoteReason()
+		require.True(t, ok)
+		assert.Equal(t, ReasonQ850(Q850UserBusy, ""), r)
+	})
+
+	t.Run("InviteBridgeFailure", func(t *testing.T) {
+		_, err := dg.Invite(ctx, sip.Uri{User: "busy", Host: "127.0.0.1", Port: 15129}, InviteOptions{})
+		require.Error(t, err)
+		r, ok := ReasonFromError(err)
+		require.True(t, ok)
+		assert.Equal(t, ReasonSIP(sip.StatusBusyHere, "Busy Here"), r)
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/emiago/sipgo"
@@ -58,6 +59,14 @@ type RegisterTransaction struct {
 	log    *slog.Logger
 
 	expiry time.Duration
+	// onRegistered tracks registration state
+	onRegistered func(registered bool)
+
+	// refreshCtx is canceled to stop QualifyLoop, ex. on shutdown
+	refreshCtx    context.Context
+	refreshCancel context.CancelFunc
+	// mu serializes requests as they share Origin
+	mu sync.Mutex
 }
 
 func newRegisterTransaction(client *sipgo.Client, recipient sip.Uri, contact sip.ContactHeader, log *slog.Logger, opts RegisterOptions) *RegisterTransaction {
@@ -91,14 +100,21 @@ func newRegisterTransaction(client *sipgo.Client, recipient sip.Uri, contact sip
 		client: client,
 		log:    log.With("caller", "Register"),
 	}
+	t.refreshCtx, t.refreshCancel = context.WithCancel(context.Background())
 
 	return t
 }
 
 func (t *RegisterTransaction) Register(ctx context.Context) error {
-	if err := t.register(ctx); err != nil {
+	t.mu.Lock()
+	err := t.register(ctx)
+	t.mu.Unlock()
+	if err != nil {
 		return err
 	}
+	if t.onRegistered != nil {
+		t.onRegistered(true)
+	}
 
 	if t.opts.OnRegistered != nil {
 		t.opts.OnRegistered()
@@ -180,7 +196,7 @@ func (t *RegisterTransaction) register(ctx context.Context) error {
 	}
 
 	if res.StatusCode == sip.StatusUnauthorized || res.StatusCode == sip.StatusProxyAuthRequired {
-		res, err = client.DoDigestAuth(ctx, req, res, sipgo.DigestAuth{
+		res, err = digestAuthDo(ctx, client, req, res, sipgo.DigestAuth{
 			Username: username,
 			Password: password,
 		})
@@ -219,6 +235,11 @@ func (t *RegisterTransaction) QualifyLoop(ctx context.Context) error {
 }
 
 func (t *RegisterTransaction) reregisterLoop(ctx context.Context, retry time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(t.refreshCtx, cancel)
+	defer stop()
+
 	ticker := time.NewTicker(retry)
 	defer ticker.Stop()
 
@@ -231,6 +252,10 @@ func (t *RegisterTransaction) reregisterLoop(ctx context.Context, retry time.Dur
 		expiry := t.expiry
 		err := t.Qualify(ctx)
 		if err != nil {
+			// Registration is lost when refresh fails, unless loop is stopped
+			if ctx.Err() == nil && t.onRegistered != nil {
+				t.onRegistered(false)
+			}
 			return err
 		}
 
@@ -262,7 +287,14 @@ func (t *RegisterTransaction) calcRetry(expiry time.Duration) time.Duration {
 	return retry
 }
 
+// stopRefresh stops QualifyLoop including ongoing refresh request
+func (t *RegisterTransaction) stopRefresh() {
+	t.refreshCancel()
+}
+
 func (t *RegisterTransaction) Unregister(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	req := t.Origin
 
 	req.RemoveHeader("Expires")
@@ -270,11 +302,26 @@ func (t *RegisterTransaction) Unregister(ctx context.Context) error {
 	req.AppendHeader(sip.NewHeader("Contact", "*"))
 	expires := sip.ExpiresHeader(0)
 	req.AppendHeader(&expires)
-	return t.doRequest(ctx, req)
+	if err := t.doRequest(ctx, req); err != nil {
+		return err
+	}
+	if t.onRegistered != nil {
+		t.onRegistered(false)
+	}
+	return nil
 }
 
 func (t *RegisterTransaction) Qualify(ctx context.Context) error {
-	return t.doRequest(ctx, t.Origin)
+	t.mu.Lock()
+	err := t.doRequest(ctx, t.Origin)
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if t.onRegistered != nil {
+		t.onRegistered(true)
+	}
+	return nil
 }
 
 func (t *RegisterTransaction) doRequest(ctx context.Context, req *sip.Request) error {
@@ -290,7 +337,7 @@ func (t *RegisterTransaction) doRequest(ctx context.Context, req *sip.Request) e
 	}
 
 	if res.StatusCode == sip.StatusUnauthorized || res.StatusCode == sip.StatusProxyAuthRequired {
-		res, err = client.DoDigestAuth(ctx, req, res, sipgo.DigestAuth{
+		res, err = digestAuthDo(ctx, client, req, res, sipgo.DigestAuth{
 			Username: username,
 			Password: password,
 		})
@@ -0,0 +1,415 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// RegistrarBinding is registered contact of address of record (AOR)
+type RegistrarBinding struct {
+	// AOR is address of record in form user@domain
+	AOR     string
+	Contact sip.Uri
+	Expires time.Time
+	// Q is contact preference 0-1. Higher is preferred
+	Q float64
+	// Path is list of Path header values (RFC 3327) used as Route when reaching contact
+	Path []string
+
+	CallID string
+	CSeq   uint32
+	// Source is address from which REGISTER was received
+	Source string
+}
+
+// RegistrarStore stores bindings of address of records
+type RegistrarStore interface {
+	// BindingStore adds or updates binding. Binding is identified by AOR and Contact
+	BindingStore(ctx context.Context, b RegistrarBinding) error
+	// BindingLoad returns all stored bindings of AOR. Expired bindings can be returned
+	BindingLoad(ctx context.Context, aor string) ([]RegistrarBinding, error)
+	BindingDelete(ctx context.Context, aor string, contact sip.Uri) error
+}
+
+// registrarStoreMap is default in memory store
+type registrarStoreMap struct {
+	mu       sync.Mutex
+	bindings map[string][]RegistrarBinding
+}
+
+func (m *registrarStoreMap) BindingStore(ctx context.Context, b RegistrarBinding) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.bindings == nil {
+		m.bindings = make(map[string][]RegistrarBinding)
+	}
+
+	bindings := m.bindings[b.AOR]
+	for i, e := range bindings {
+		if e.Contact.String() == b.Contact.String() {
+			bindings[i] = b
+			return nil
+		}
+	}
+	m.bindings[b.AOR] = append(bindings, b)
+	return nil
+}
+
+func (m *registrarStoreMap) BindingLoad(ctx context.Context, aor string) ([]RegistrarBinding, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.bindings[aor]), nil
+}
+
+func (m *registrarStoreMap) BindingDelete(ctx context.Context, aor string, contact sip.Uri) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bindings := slices.DeleteFunc(m.bindings[aor], func(e RegistrarBinding) bool {
+		return e.Contact.String() == contact.String()
+	})
+	if len(bindings) == 0 {
+		delete(m.bindings, aor)
+		return nil
+	}
+	m.bindings[aor] = bindings
+	return nil
+}
+
+type RegistrarOptions struct {
+	// Store for bindings. Default is in memory store
+	Store RegistrarStore
+
+	// DigestAuth authenticates REGISTER requests. Credentials are looked up with Credentials
+	// or with digest server credential provider when Credentials is nil
+	DigestAuth *DigestAuthServer
+	// Credentials returns expected credentials for REGISTER. Returning error rejects request with 403 Forbidden
+	Credentials func(req *sip.Request) (DigestAuth, error)
+	// Realm used with digest server credential provider. Default is sipgo
+	Realm string
+	// Authorize checks is authenticated identity allowed to modify bindings of AOR (RFC 3261 section 10.3 step 4).
+	// Returning false rejects request with 403 Forbidden. Default allows only AOR matching username
+	Authorize func(identity AuthIdentity, aor sip.Uri) bool
+
+	// Domains served by registrar. If empty, any domain is accepted
+	Domains []string
+
+	// Expiry limits. Defaults are 60s min and 3600s max and default
+	MinExpires     time.Duration
+	MaxExpires     time.Duration
+	DefaultExpires time.Duration
+}
+
+// Registrar accepts REGISTER requests (RFC 3261 section 10.3) and acts as location service.
+// When enabled on Diago with WithRegistrar, NewDialog resolves registered AOR to its most preferred contact,
+// while Invite calls all registered contacts
+type Registrar struct {
+	opts RegistrarOptions
+}
+
+func NewRegistrar(opts RegistrarOptions) *Registrar {
+	if opts.Store == nil {
+		opts.Store = &registrarStoreMap{}
+	}
+	if opts.MinExpires <= 0 {
+		opts.MinExpires = 60 * time.Second
+	}
+	if opts.MaxExpires <= 0 {
+		opts.MaxExpires = 3600 * time.Second
+	}
+	if opts.DefaultExpires <= 0 {
+		opts.DefaultExpires = opts.MaxExpires
+	}
+	if opts.Realm == "" {
+		opts.Realm = "sipgo"
+	}
+	if opts.Authorize == nil {
+		opts.Authorize = registrarAuthorize
+	}
+	return &Registrar{opts: opts}
+}
+
+// WithRegistrar enables handling REGISTER requests and resolving AOR on outgoing dialogs
+func WithRegistrar(r *Registrar) DiagoOption {
+	return func(dg *Diago) {
+		dg.registrar = r
+	}
+}
+
+// registrarAuthorize is default authorization allowing user to modify only own AOR
+func registrarAuthorize(identity AuthIdentity, aor sip.Uri) bool {
+	return identity.Username == aor.User
+}
+
+// registrarAOR returns AOR key. Port is ignored as AOR is user@domain
+func registrarAOR(uri sip.Uri) string {
+	return uri.User + "@" + strings.ToLower(uri.Host)
+}
+
+// Lookup returns non expired bindings of AOR ordered by preference
+func (r *Registrar) Lookup(ctx context.Context, aor sip.Uri) ([]RegistrarBinding, error) {
+	key := registrarAOR(aor)
+	bindings, err := r.opts.Store.BindingLoad(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	active := make([]RegistrarBinding, 0, len(bindings))
+	for _, b := range bindings {
+		if !b.Expires.After(now) {
+			if err := r.opts.Store.BindingDelete(ctx, key, b.Contact); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		active = append(active, b)
+	}
+	slices.SortStableFunc(active, func(a, b RegistrarBinding) int {
+		return cmp.Compare(b.Q, a.Q)
+	})
+	return active, nil
+}
+
+func (r *Registrar) handleRegister(req *sip.Request, tx sip.ServerTransaction) error {
+	res, err := r.handle(context.Background(), req)
+	return errors.Join(err, tx.Respond(res))
+}
+
+// handle authenticates and authorizes REGISTER request and updates bindings. Response is always returned
+func (r *Registrar) handle(ctx context.Context, req *sip.Request) (*sip.Response, error) {
+	if len(r.opts.Domains) > 0 && !slices.ContainsFunc(r.opts.Domains, func(d string) bool {
+		return strings.EqualFold(d, req.Recipient.Host)
+	}) {
+		return sip.NewResponseFromRequest(req, sip.StatusForbidden, "Forbidden", nil), nil
+	}
+
+	if r.opts.DigestAuth != nil {
+		var identity AuthIdentity
+		var res *sip.Response
+		var err error
+		if r.opts.Credentials != nil {
+			auth, authErr := r.opts.Credentials(req)
+			if authErr != nil {
+				return sip.NewResponseFromRequest(req, sip.StatusForbidden, "Forbidden", nil), authErr
+			}
+			res, err = r.opts.DigestAuth.AuthorizeRequest(req, auth)
+			identity = AuthIdentity{Username: auth.Username, Realm: auth.Realm}
+		} else {
+			identity, res, err = r.opts.DigestAuth.Authorize(ctx, req, r.opts.Realm)
+		}
+		if err != nil || res.StatusCode != sip.StatusOK {
+			return res, err
+		}
+
+		// https://datatracker.ietf.org/doc/html/rfc3261#section-10.3 step 4
+		if !r.opts.Authorize(identity, req.To().Address) {
+			return sip.NewResponseFromRequest(req, sip.StatusForbidden, "Forbidden", nil), nil
+		}
+	}
+
+	res, err := r.register(ctx, req)
+	if err != nil {
+		return sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error", nil), err
+	}
+	return res, nil
+}
+
+// register processes bindings of REGISTER request. Bindings are updated only if request is valid
+func (r *Registrar) register(ctx context.Context, req *sip.Request) (*sip.Response, error) {
+	aor := req.To().Address
+	key := registrarAOR(aor)
+	callID := req.CallID().Value()
+	cseq := req.CSeq().SeqNo
+
+	expiresHdr := -1
+	if h := req.GetHeader("Expires"); h != nil {
+		val, err := strconv.Atoi(h.Value())
+		if err != nil || val < 0 {
+			return sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - Invalid Expires", nil), nil
+		}
+		expiresHdr = val
+	}
+
+	existing, err := r.Lookup(ctx, aor)
+	if err != nil {
+		return nil, err
+	}
+
+	findExisting := func(contact sip.Uri) (RegistrarBinding, bool) {
+		for _, b := range existing {
+			if b.Contact.String() == contact.String() {
+				return b, true
+			}
+		}
+		return RegistrarBinding{}, false
+	}
+
+	var path []string
+	for _, h := range req.GetHeaders("Path") {
+		path = append(path, h.Value())
+	}
+
+	contacts := req.GetHeaders("Contact")
+	now := time.Now()
+	updates := make([]RegistrarBinding, 0, len(contacts))
+	var granted time.Duration
+	for i, h := range contacts {
+		contact, ok := h.(*sip.ContactHeader)
+		if !ok {
+			return sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - Invalid Contact", nil), nil
+		}
+
+		if contact.Address.Wildcard {
+			// https://datatracker.ietf.org/doc/html/rfc3261#section-10.3 step 6
+			if len(contacts) != 1 || expiresHdr != 0 {
+				return sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - Invalid Wildcard", nil), nil
+			}
+			for _, b := range existing {
+				if b.CallID == callID && cseq <= b.CSeq {
+					continue
+				}
+				b.Expires = time.Time{}
+				updates = append(updates, b)
+			}
+			break
+		}
+
+		expires := time.Duration(expiresHdr) * time.Second
+		if expiresHdr < 0 {
+			expires = r.opts.DefaultExpires
+		}
+		if v, ok := contact.Params.Get("expires"); ok {
+			val, err := strconv.Atoi(v)
+			if err != nil || val < 0 {
+				return sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - Invalid Contact Expires", nil), nil
+			}
+			expires = time.Duration(val) * time.Second
+		}
+
+		if expires > 0 && expires < r.opts.MinExpires {
+			res := sip.NewResponseFromRequest(req, sip.StatusIntervalToBrief, "Interval Too Brief", nil)
+			res.AppendHeader(sip.NewHeader("Min-Expires", strconv.Itoa(int(r.opts.MinExpires.Seconds()))))
+			return res, nil
+		}
+		expires = min(expires, r.opts.MaxExpires)
+		if i == 0 {
+			granted = expires
+		}
+
+		q := 1.0
+		if v, ok := contact.Params.Get("q"); ok {
+			val, err := strconv.ParseFloat(v, 64)
+			if err != nil || val < 0 || val > 1 {
+				return sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - Invalid Contact q", nil), nil
+			}
+			q = val
+		}
+
+		if b, exists := findExisting(contact.Address); exists && b.CallID == callID && cseq <= b.CSeq {
+			// Out of order request
+			return sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Server Internal Error", nil), nil
+		}
+
+		b := RegistrarBinding{
+			AOR:     key,
+			Contact: *contact.Address.Clone(),
+			Q:       q,
+			Path:    path,
+			CallID:  callID,
+			CSeq:    cseq,
+			Source:  req.Source(),
+		}
+		if expires > 0 {
+			b.Expires = now.Add(expires)
+		}
+		updates = append(updates, b)
+	}
+
+	for _, b := range updates {
+		if b.Expires.IsZero() {
+			if err := r.opts.Store.BindingDelete(ctx, key, b.Contact); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := r.opts.Store.BindingStore(ctx, b); err != nil {
+			return nil, err
+		}
+	}
+
+	bindings, err := r.Lookup(ctx, aor)
+	if err != nil {
+		return nil, err
+	}
+
+	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+	for _, b := range bindings {
+		h := &sip.ContactHeader{
+			Address: b.Contact,
+			Params:  sip.NewParams(),
+		}
+		h.Params.Add("expires", strconv.Itoa(int(time.Until(b.Expires).Round(time.Second).Seconds())))
+		res.AppendHeader(h)
+	}
+	if len(contacts) > 0 && granted > 0 {
+		expires := sip.ExpiresHeader(granted.Seconds())
+		res.AppendHeader(&expires)
+	}
+	for _, p := range path {
+		res.AppendHeader(sip.NewHeader("Path", p))
+	}
+	return res, nil
+}
+
+// resolve returns registered contacts of AOR ordered by preference as call targets.
+// Path of binding is added as Route header. If AOR has no bindings, nil is returned
+func (r *Registrar) resolve(ctx context.Context, recipient sip.Uri) ([]InviteForkTarget, error) {
+	bindings, err := r.Lookup(ctx, recipient)
+	if err != nil {
+		return nil, fmt.Errorf("registrar lookup failed: %w", err)
+	}
+
+	targets := make([]InviteForkTarget, 0, len(bindings))
+	for _, b := range bindings {
+		t := InviteForkTarget{
+			Recipient: *b.Contact.Clone(),
+			resolved:  true,
+		}
+		for _, p := range b.Path {
+			t.Headers = append(t.Headers, sip.NewHeader("Route", p))
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// inviteRegistered calls all registered contacts in parallel and returns first answered
+func (dg *Diago) inviteRegistered(ctx context.Context, targets []InviteForkTarget, opts InviteOptions) (*DialogClientSession, error) {
+	for i := range targets {
+		targets[i].Transport = opts.Transport
+	}
+	forkOpts := InviteForkOptions{
+		Originator: opts.Originator,
+		Username:   opts.Username,
+		Password:   opts.Password,
+		Headers:    opts.Headers,
+	}
+	if opts.OnResponse != nil {
+		forkOpts.OnResponse = func(leg int, res *sip.Response) error {
+			return opts.OnResponse(res)
+		}
+	}
+	return dg.InviteFork(ctx, targets, forkOpts)
+}
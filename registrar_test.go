@@ -0,0 +1,263 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistrarRegister(t *testing.T) {
+	ctx := context.Background()
+	r := NewRegistrar(RegistrarOptions{})
+	aor := sip.Uri{Scheme: "sip", User: "1001", Host: "example.com"}
+
+	cseq := uint32(0)
+	newRegister := func(contacts ...string) *sip.Request {
+		cseq++
+		req := sip.NewRequest(sip.REGISTER, sip.Uri{Scheme: "sip", Host: "example.com"})
+		req.AppendHeader(&sip.ToHeader{Address: aor})
+		from := &sip.FromHeader{Address: aor, Params: sip.NewParams()}
+		from.Params.Add("tag", "abc")
+		req.AppendHeader(from)
+		callid := sip.CallIDHeader("registrar-test")
+		req.AppendHeader(&callid)
+		req.AppendHeader(&sip.CSeqHeader{SeqNo: cseq, MethodName: sip.REGISTER})
+		for _, c := range contacts {
+			h := &sip.ContactHeader{}
+			_, err := sip.ParseAddressValue(c, &h.Address, &h.Params)
+			require.NoError(t, err)
+			req.AppendHeader(h)
+		}
+		return req
+	}
+
+	t.Run("IntervalTooBrief", func(t *testing.T) {
+		res, err := r.register(ctx, newRegister("<sip:1001@10.0.0.1>;expires=10"))
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusIntervalToBrief, res.StatusCode)
+		assert.Equal(t, "60", res.GetHeader("Min-Expires").Value())
+	})
+
+	t.Run("Bindings", func(t *testing.T) {
+		res, err := r.register(ctx, newRegister("<sip:1001@10.0.0.1>;q=0.5", "<sip:1001@10.0.0.2>;expires=120"))
+		require.NoError(t, err)
+		require.Equal(t, sip.StatusOK, res.StatusCode)
+		assert.Len(t, res.GetHeaders("Contact"), 2)
+
+		bindings, err := r.Lookup(ctx, sip.Uri{User: "1001", Host: "EXAMPLE.com", Port: 5060})
+		require.NoError(t, err)
+		require.Len(t, bindings, 2)
+		assert.Equal(t, "10.0.0.2", bindings[0].Contact.Host)
+		assert.Equal(t, 1.0, bindings[0].Q)
+		assert.WithinDuration(t, time.Now().Add(120*time.Second), bindings[0].Expires, time.Second)
+		assert.Equal(t, "10.0.0.1", bindings[1].Contact.Host)
+		assert.Equal(t, 0.5, bindings[1].Q)
+		assert.WithinDuration(t, time.Now().Add(3600*time.Second), bindings[1].Expires, time.Second)
+
+		targets, err := r.resolve(ctx, aor)
+		require.NoError(t, err)
+		require.Len(t, targets, 2)
+		assert.Equal(t, "10.0.0.2", targets[0].Recipient.Host)
+		assert.Equal(t, "10.0.0.1", targets[1].Recipient.Host)
+	})
+
+	t.Run("Query", func(t *testing.T) {
+		res, err := r.register(ctx, newRegister())
+		require.NoError(t, err)
+		require.Equal(t, sip.StatusOK, res.StatusCode)
+		assert.Len(t, res.GetHeaders("Contact"), 2)
+	})
+
+	t.Run("OutOfOrder", func(t *testing.T) {
+		req := newRegister("<sip:1001@10.0.0.1>")
+		req.CSeq().SeqNo = 1
+		res, err := r.register(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, sip.StatusInternalServerError, res.StatusCode)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		res, err := r.register(ctx, newRegister("<sip:1001@10.0.0.1>;expires=0"))
+		require.NoError(t, err)
+		require.Equal(t, sip.StatusOK, res.StatusCode)
+		assert.Len(t, res.GetHeaders("Contact"), 1)
+
+		req := newRegister("*")
+		expires := sip.ExpiresHeader(0)
+		req.AppendHeader(&expires)
+		res, err = r.register(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, sip.StatusOK, res.StatusCode)
+		assert.Empty(t, res.GetHeaders("Contact"))
+
+		bindings, err := r.Lookup(ctx, aor)
+		require.NoError(t, err)
+		assert.Empty(t, bindings)
+	})
+}
+
+func TestRegistrarAuthorize(t *testing.T) {
+	ctx := context.Background()
+	provider := DigestCredentialProviderFunc(func(ctx context.Context, username string, realm string) (DigestCredentials, error) {
+		return DigestCredentials{Password: "secret"}, nil
+	})
+	auth := NewDigestServer(WithDigestCredentialProvider(provider))
+	defer auth.Close()
+
+	register := func(r *Registrar, username string, user string) *sip.Response {
+		aor := sip.Uri{Scheme: "sip", User: user, Host: "example.com"}
+		req := sip.NewRequest(sip.REGISTER, sip.Uri{Scheme: "sip", Host: "example.com"})
+		req.AppendHeader(&sip.ToHeader{Address: aor})
+		req.AppendHeader(&sip.FromHeader{Address: aor, Params: sip.NewParams()})
+		callid := sip.CallIDHeader("registrar-auth-" + username + user)
+		req.AppendHeader(&callid)
+		req.AppendHeader(&sip.CSeqHeader{SeqNo: 1, MethodName: sip.REGISTER})
+		req.AppendHeader(&sip.ContactHeader{Address: sip.Uri{Scheme: "sip", User: user, Host: "10.0.0.1"}})
+
+		res, err := r.handle(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, sip.StatusUnauthorized, res.StatusCode)
+		require.NoError(t, digestAuthApply(req, res, sipgo.DigestAuth{Username: username, Password: "secret"}))
+		res, _ = r.handle(ctx, req)
+		return res
+	}
+
+	r := NewRegistrar(RegistrarOptions{DigestAuth: auth})
+	assert.Equal(t, sip.StatusOK, register(r, "1001", "1001").StatusCode)
+	assert.Equal(t, sip.StatusForbidden, register(r, "1001", "1002").StatusCode)
+
+	// Custom authorization allows admin to register any AOR
+	r = NewRegistrar(RegistrarOptions{
+		DigestAuth: auth,
+		Authorize: func(identity AuthIdentity, aor sip.Uri) bool {
+			return identity.Username == "admin" || identity.Username == aor.User
+		},
+	})
+	assert.Equal(t, sip.StatusOK, register(r, "admin", "1002").StatusCode)
+	assert.Equal(t, sip.StatusForbidden, register(r, "1001", "1002").StatusCode)
+}
+
+func TestIntegrationRegistrar(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ua, _ := sipgo.NewUA(sipgo.WithUserAgent("registrar"))
+	defer ua.Close()
+
+	auth := NewDigestServer()
+	defer auth.Close()
+	registrar := NewRegistrar(RegistrarOptions{
+		DigestAuth: auth,
+		Credentials: func(req *sip.Request) (DigestAuth, error) {
+			if req.To().Address.User != "1001" {
+				return DigestAuth{}, fmt.Errorf("unknown user")
+			}
+			return DigestAuth{Username: "1001", Password: "secret", Realm: "test"}, nil
+		},
+	})
+	dg := NewDiago(ua, WithTransport(
+		Transport{
+			Transport: "udp",
+			BindHost:  "127.0.0.1",
+			BindPort:  15117,
+		},
+	), WithRegistrar(registrar))
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	phoneUA, _ := sipgo.NewUA(sipgo.WithUserAgent("1001"))
+	defer phoneUA.Close()
+	phone := NewDiago(phoneUA, WithTransport(
+		Transport{
+			Transport: "udp",
+			BindHost:  "127.0.0.1",
+			BindPort:  15118,
+		},
+	))
+	err = phone.ServeBackground(ctx, func(d *DialogServerSession) {
+		if err := d.Answer(); err != nil {
+			return
+		}
+		<-d.Context().Done()
+	})
+	require.NoError(t, err)
+
+	recipient := sip.Uri{User: "1001", Host: "127.0.0.1", Port: 15117}
+
+	t.Run("BadCredentials", func(t *testing.T) {
+		tx, err := phone.RegisterTransaction(ctx, recipient, RegisterOptions{Username: "1001", Password: "wrong"})
+		require.NoError(t, err)
+		err = tx.Register(ctx)
+		var resErr *RegisterResponseError
+		require.ErrorAs(t, err, &resErr)
+		assert.Equal(t, sip.StatusUnauthorized, resErr.RegisterRes.StatusCode)
+	})
+
+	t.Run("RegisterAndCall", func(t *testing.T) {
+		tx, err := phone.RegisterTransaction(ctx, recipient, RegisterOptions{Username: "1001", Password: "secret", Expiry: 5 * time.Minute})
+		require.NoError(t, err)
+		require.NoError(t, tx.Register(ctx))
+
+		bindings, err := registrar.Lookup(ctx, recipient)
+		require.NoError(t, err)
+		require.Len(t, bindings, 1)
+		assert.Equal(t, 15118, bindings[0].Contact.Port)
+		assert.WithinDuration(t, time.Now().Add(5*time.Minute), bindings[0].Expires, time.Second)
+
+		d, err := dg.Invite(ctx, sip.Uri{User: "1001", Host: "127.0.0.1"}, InviteOptions{})
+		require.NoError(t, err)
+		defer d.Close()
+		assert.Equal(t, 15118, d.InviteRequest.Recipient.Port)
+		require.NoError(t, d.Hangup(ctx))
+
+		require.NoError(t, tx.Unregister(ctx))
+		bindings, err = registrar.Lookup(ctx, recipient)
+		require.NoError(t, err)
+		assert.Empty(t, bindings)
+	})
+	t.Run("MultipleContacts", func(t *testing.T) {
+		busyUA, _ := sipgo.NewUA(sipgo.WithUserAgent("1001"))
+		defer busyUA.Close()
+		busy := NewDiago(busyUA, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15138,
+			},
+		))
+		busyInvite := make(chan struct{}, 1)
+		err := busy.ServeBackground(ctx, func(d *DialogServerSession) {
+			busyInvite <- struct{}{}
+			d.Respond(sip.StatusBusyHere, "Busy Here", nil)
+		})
+		require.NoError(t, err)
+
+		for _, dg := range []*Diago{phone, busy} {
+			tx, err := dg.RegisterTransaction(ctx, recipient, RegisterOptions{Username: "1001", Password: "secret", Expiry: 5 * time.Minute})
+			require.NoError(t, err)
+			require.NoError(t, tx.Register(ctx))
+			defer tx.Unregister(ctx)
+		}
+
+		d, err := dg.Invite(ctx, sip.Uri{User: "1001", Host: "127.0.0.1"}, InviteOptions{})
+		require.NoError(t, err)
+		defer d.Close()
+		assert.Equal(t, 15118, d.InviteRequest.Recipient.Port)
+
+		select {
+		case <-busyInvite:
+		case <-time.After(2 * time.Second):
+			t.Fatal("second contact not called")
+		}
+		require.NoError(t, d.Hangup(ctx))
+	})
+}
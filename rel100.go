@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// Rel100Mode decides when reliable provisional responses (100rel, RFC 3262) are offered or required.
+// Remote side requiring 100rel is always honored.
+type Rel100Mode int
+
+const (
+	// Rel100ModeDisabled does not offer 100rel. This is default
+	Rel100ModeDisabled Rel100Mode = iota
+	// Rel100ModeSupported offers 100rel with Supported header.
+	// Inbound calls get reliable provisional responses if caller supports 100rel.
+	Rel100ModeSupported
+	// Rel100ModeRequired requires 100rel with Require header.
+	// Inbound calls without 100rel support are rejected with 421 Extension Required.
+	Rel100ModeRequired
+)
+
+// WithRel100 sets usage of reliable provisional responses (100rel) for inbound and outbound calls
+func WithRel100(mode Rel100Mode) DiagoOption {
+	return func(dg *Diago) {
+		dg.rel100 = mode
+	}
+}
+
+const optionTag100rel = "100rel"
+
+// sipHeaderHasOption checks is option tag present in headers like Supported or Require
+func sipHeaderHasOption(msg sip.Message, name string, option string) bool {
+	for _, h := range msg.GetHeaders(name) {
+		for _, v := range strings.Split(h.Value(), ",") {
+			if strings.EqualFold(strings.TrimSpace(v), option) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseRAck parses RAck header value: response-num CSeq-num Method
+func parseRAck(val string) (rseq uint32, cseq uint32, method sip.RequestMethod, err error) {
+	fields := strings.Fields(val)
+	if len(fields) != 3 {
+		return 0, 0, "", fmt.Errorf("invalid RAck header %q", val)
+	}
+
+	n, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid RAck response num: %w", err)
+	}
+	rseq = uint32(n)
+
+	n, err = strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid RAck CSeq num: %w", err)
+	}
+	cseq = uint32(n)
+
+	return rseq, cseq, sip.RequestMethod(strings.ToUpper(fields[2])), nil
+}
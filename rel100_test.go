@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRAck(t *testing.T) {
+	rseq, cseq, method, err := parseRAck("776656 1 INVITE")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(776656), rseq)
+	assert.Equal(t, uint32(1), cseq)
+	assert.Equal(t, sip.INVITE, method)
+
+	for _, val := range []string{"", "1 INVITE", "a 1 INVITE", "1 b INVITE", "1 2 INVITE x"} {
+		_, _, _, err := parseRAck(val)
+		assert.Error(t, err, val)
+	}
+}
+
+func TestSIPHeaderHasOption(t *testing.T) {
+	req := sip.NewRequest(sip.INVITE, sip.Uri{User: "test", Host: "localhost"})
+	req.AppendHeader(sip.NewHeader("Supported", "timer, 100REL"))
+	req.AppendHeader(sip.NewHeader("Supported", "replaces"))
+
+	assert.True(t, sipHeaderHasOption(req, "Supported", "100rel"))
+	assert.True(t, sipHeaderHasOption(req, "supported", "replaces"))
+	assert.False(t, sipHeaderHasOption(req, "Supported", "path"))
+	assert.False(t, sipHeaderHasOption(req, "Require", "100rel"))
+}
+
+func TestIntegrationRel100PRACK(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	answered := make(chan error, 1)
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("server"))
+		defer ua.Close()
+
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15101,
+			},
+		))
+
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			if err := d.Ringing(); err != nil {
+				answered <- err
+				return
+			}
+			if err := d.ProgressMedia(); err != nil {
+				answered <- err
+				return
+			}
+			answered <- d.Answer()
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := NewDiago(ua,
+		WithTransport(Transport{Transport: "udp", BindHost: "127.0.0.1", BindPort: 0}),
+		WithRel100(Rel100ModeRequired),
+	)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	dialog, err := dg.NewDialog(sip.Uri{User: "dialer", Host: "127.0.0.1", Port: 15101}, NewDialogOptions{})
+	require.NoError(t, err)
+	defer dialog.Close()
+
+	reliable := []int{}
+	err = dialog.Invite(ctx, InviteClientOptions{
+		OnResponse: func(res *sip.Response) error {
+			if res.GetHeader("RSeq") != nil {
+				reliable = append(reliable, res.StatusCode)
+			}
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{sip.StatusRinging, sip.StatusSessionInProgress}, reliable)
+	require.NoError(t, dialog.Ack(ctx))
+
+	// ACK must match INVITE otherwise server would wait for ACK with 64*T1
+	select {
+	case err := <-answered:
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("ACK not accepted")
+	}
+
+	require.NoError(t, dialog.Hangup(ctx))
+}
+
+func TestIntegrationRel100Required(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("server"))
+		defer ua.Close()
+
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15102,
+			},
+		), WithRel100(Rel100ModeRequired))
+
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			d.Answer()
+		})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	_, err = dg.Invite(ctx, sip.Uri{User: "dialer", Host: "127.0.0.1", Port: 15102}, InviteOptions{})
+	var dErr *sipgo.ErrDialogResponse
+	require.True(t, errors.As(err, &dErr), err)
+	assert.Equal(t, sip.StatusExtensionRequired, dErr.Res.StatusCode)
+	assert.True(t, sipHeaderHasOption(dErr.Res, "Require", "100rel"))
+}
@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+)
+
+const optionTagReplaces = "replaces"
+
+// replaces is Replaces header value (RFC 3891).
+// Tags are from perspective of UA receiving INVITE with Replaces, where
+// to-tag is its local tag and from-tag is its remote tag.
+type replaces struct {
+	callID    string
+	toTag     string
+	fromTag   string
+	earlyOnly bool
+}
+
+func (r replaces) String() string {
+	val := r.callID + ";to-tag=" + r.toTag + ";from-tag=" + r.fromTag
+	if r.earlyOnly {
+		val += ";early-only"
+	}
+	return val
+}
+
+// parseReplaces parses Replaces value ex. 425928@bobster.example.org;to-tag=7743;from-tag=6472
+func parseReplaces(val string) (replaces, error) {
+	r := replaces{}
+	callID, params, _ := strings.Cut(val, ";")
+	r.callID = strings.TrimSpace(callID)
+	if r.callID == "" {
+		return r, fmt.Errorf("invalid Replaces header %q: missing call-id", val)
+	}
+
+	for _, p := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+		switch strings.ToLower(name) {
+		case "to-tag":
+			r.toTag = value
+		case "from-tag":
+			r.fromTag = value
+		case "early-only":
+			r.earlyOnly = true
+		}
+	}
+
+	if r.toTag == "" || r.fromTag == "" {
+		return r, fmt.Errorf("invalid Replaces header %q: missing tags", val)
+	}
+	return r, nil
+}
+
+// dialogReplaces builds Replaces for dialog as seen by its remote side
+func dialogReplaces(d DialogSession) (replaces, error) {
+	var inviteReq *sip.Request
+	var inviteRes *sip.Response
+	client := false
+	switch d := d.(type) {
+	case *DialogClientSession:
+		inviteReq, inviteRes, client = d.InviteRequest, d.InviteResponse, true
+	case *DialogServerSession:
+		inviteReq, inviteRes = d.InviteRequest, d.InviteResponse
+	default:
+		return replaces{}, fmt.Errorf("unsupported dialog type %T", d)
+	}
+
+	if inviteRes == nil {
+		return replaces{}, fmt.Errorf("dialog is not established")
+	}
+
+	fromTag, _ := inviteReq.From().Params.Get("tag")
+	toTag, _ := inviteRes.To().Params.Get("tag")
+	if fromTag == "" || toTag == "" {
+		return replaces{}, fmt.Errorf("dialog is missing tags")
+	}
+
+	r := replaces{callID: inviteReq.CallID().Value()}
+	if client {
+		// Remote is UAS, so its local tag is in To header
+		r.toTag, r.fromTag = toTag, fromTag
+	} else {
+		r.toTag, r.fromTag = fromTag, toTag
+	}
+	return r, nil
+}
+
+// dialogReferReplacesTarget returns remote target of dialog with embedded Replaces header.
+// Used as Refer-To for attended transfer
+// https://datatracker.ietf.org/doc/html/rfc3891#section-7
+func dialogReferReplacesTarget(d DialogSession) (sip.Uri, error) {
+	r, err := dialogReplaces(d)
+	if err != nil {
+		return sip.Uri{}, err
+	}
+
+	var contact *sip.ContactHeader
+	switch d := d.(type) {
+	case *DialogClientSession:
+		contact = d.RemoteContact()
+	case *DialogServerSession:
+		contact = d.RemoteContact()
+	}
+	if contact == nil {
+		return sip.Uri{}, fmt.Errorf("dialog has no remote contact")
+	}
+
+	target := *contact.Address.Clone()
+	target.Headers = sip.NewParams()
+	target.Headers.Add("Replaces", replacesEscape(r.String()))
+	return target, nil
+}
+
+// replacesEscape escapes Replaces value for uri header. Space is escaped as %20, as '+' is valid in Call-ID
+func replacesEscape(val string) string {
+	return strings.ReplaceAll(url.QueryEscape(val), "+", "%20")
+}
+
+// referToReplaces removes Replaces embedded in Refer-To uri and returns it as header for INVITE
+func referToReplaces(referTo *sip.Uri) sip.Header {
+	for _, name := range []string{"Replaces", "replaces"} {
+		val, ok := referTo.Headers.Get(name)
+		if !ok {
+			continue
+		}
+		referTo.Headers.Remove(name)
+
+		// '+' is valid in Call-ID, so only percent encoding is decoded
+		unescaped, err := url.PathUnescape(val)
+		if err != nil {
+			return nil
+		}
+		return sip.NewHeader("Replaces", unescaped)
+	}
+	return nil
+}
+
+// matchReplaces finds dialog that INVITE with Replaces header should replace
+// https://datatracker.ietf.org/doc/html/rfc3891#section-3
+func (p *DialogCachePool) matchReplaces(ctx context.Context, r replaces) (DialogSession, error) {
+	// Dialog ID is built from UAS tag and UAC tag
+	sd, err := p.server.DialogLoad(ctx, sip.DialogIDMake(r.callID, r.toTag, r.fromTag))
+	if err == nil {
+		return sd, nil
+	}
+
+	cd, err := p.client.DialogLoad(ctx, sip.DialogIDMake(r.callID, r.fromTag, r.toTag))
+	if err == nil {
+		return cd, nil
+	}
+	return nil, sipgo.ErrDialogDoesNotExists
+}
+
+// replacedDialog finds dialog for INVITE with Replaces header.
+// If dialog can not be replaced it returns response code and reason for INVITE.
+func (dg *Diago) replacedDialog(val string) (DialogSession, int, string) {
+	r, err := parseReplaces(val)
+	if err != nil {
+		dg.log.Info("Received INVITE with invalid Replaces header", "error", err)
+		return nil, sip.StatusBadRequest, "Bad Request"
+	}
+
+	d, err := dg.cache.matchReplaces(context.Background(), r)
+	if err != nil {
+		return nil, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist"
+	}
+
+	switch state := d.DialogSIP().LoadState(); {
+	case state == sip.DialogStateEnded:
+		// https://datatracker.ietf.org/doc/html/rfc3891#section-3
+		// If the Replaces header field matches a dialog which has already
+		// terminated, the UA SHOULD decline the request with a 603 Declined response.
+		return nil, sip.StatusGlobalDecline, "Declined"
+	case state == sip.DialogStateConfirmed && r.earlyOnly:
+		return nil, sip.StatusBusyHere, "Busy Here"
+	case state != sip.DialogStateConfirmed:
+		// Replacing early dialogs is not supported
+		return nil, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist"
+	}
+	return d, 0, ""
+}
+
+// hangupReplaced terminates dialog replaced by new call
+func (dg *Diago) hangupReplaced(d DialogSession) {
+	ctx, cancel := context.WithTimeout(context.Background(), sip.Timer_F)
+	defer cancel()
+	if err := d.Hangup(ctx); err != nil {
+		dg.log.Info("Failed to hangup replaced dialog", "error", err, "id", d.Id())
+	}
+}
@@ -0,0 +1,209 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReplaces(t *testing.T) {
+	r, err := parseReplaces("425928@bobster.example.org;to-tag=7743;from-tag=6472")
+	require.NoError(t, err)
+	assert.Equal(t, "425928@bobster.example.org", r.callID)
+	assert.Equal(t, "7743", r.toTag)
+	assert.Equal(t, "6472", r.fromTag)
+	assert.False(t, r.earlyOnly)
+	assert.Equal(t, "425928@bobster.example.org;to-tag=7743;from-tag=6472", r.String())
+
+	r, err = parseReplaces("abc; from-tag=1;to-tag=2;early-only")
+	require.NoError(t, err)
+	assert.True(t, r.earlyOnly)
+
+	for _, val := range []string{"", ";to-tag=1;from-tag=2", "abc;to-tag=1", "abc;from-tag=2"} {
+		_, err := parseReplaces(val)
+		assert.Error(t, err, val)
+	}
+}
+
+func TestReferToReplaces(t *testing.T) {
+	r := replaces{callID: "abc@127.0.0.1", toTag: "1", fromTag: "2"}
+	referTo := sip.Uri{}
+	params := sip.NewParams()
+	_, err := sip.ParseAddressValue("<sip:bob@127.0.0.1:5060?Replaces="+replacesEscape(r.String())+">", &referTo, &params)
+	require.NoError(t, err)
+
+	h := referToReplaces(&referTo)
+	require.NotNil(t, h)
+	assert.Equal(t, "Replaces", h.Name())
+	assert.Equal(t, r.String(), h.Value())
+	assert.Equal(t, "sip:bob@127.0.0.1:5060", referTo.String())
+
+	assert.Nil(t, referToReplaces(&referTo))
+
+	// Escaped space must be unescaped same way
+	val := "abc@127.0.0.1; to-tag=1; from-tag=2"
+	_, err = sip.ParseAddressValue("<sip:bob@127.0.0.1:5060?Replaces="+replacesEscape(val)+">", &referTo, &params)
+	require.NoError(t, err)
+	h = referToReplaces(&referTo)
+	require.NotNil(t, h)
+	assert.Equal(t, val, h.Value())
+
+	// '+' is valid in Call-ID, escaped or not
+	r = replaces{callID: "a+b/c@127.0.0.1", toTag: "1", fromTag: "2"}
+	for _, escaped := range []string{replacesEscape(r.String()), "a+b%2Fc%40127.0.0.1%3Bto-tag%3D1%3Bfrom-tag%3D2"} {
+		_, err = sip.ParseAddressValue("<sip:bob@127.0.0.1:5060?Replaces="+escaped+">", &referTo, &params)
+		require.NoError(t, err)
+		h = referToReplaces(&referTo)
+		require.NotNil(t, h)
+		assert.Equal(t, r.String(), h.Value())
+		parsed, err := parseReplaces(h.Value())
+		require.NoError(t, err)
+		assert.Equal(t, "a+b/c@127.0.0.1", parsed.callID)
+	}
+}
+
+func TestIntegrationReferReplaces(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// Transferee. It calls transferor and receives REFER with Replaces
+	var transferee *Diago
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("transferee"))
+		defer ua.Close()
+
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15105,
+			},
+		))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+		require.NoError(t, err)
+		transferee = dg
+	}
+
+	// Transfer target. It has consult call with transferor which gets replaced
+	consultDialog := make(chan *DialogServerSession, 1)
+	replacedDialog := make(chan DialogSession, 1)
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("target"))
+		defer ua.Close()
+
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15107,
+			},
+		))
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			if d.ReplacedDialog() == nil {
+				consultDialog <- d
+			} else {
+				replacedDialog <- d.ReplacedDialog()
+			}
+
+			if err := d.Answer(); err != nil {
+				return
+			}
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	// Transferor
+	ua, _ := sipgo.NewUA(sipgo.WithUserAgent("transferor"))
+	defer ua.Close()
+	dg := NewDiago(ua, WithTransport(
+		Transport{
+			Transport: "udp",
+			BindHost:  "127.0.0.1",
+			BindPort:  15106,
+		},
+	))
+
+	transferorDialog := make(chan *DialogServerSession)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+		transferorDialog <- d
+		<-d.Context().Done()
+	})
+	require.NoError(t, err)
+
+	transferred := make(chan sip.Header, 1)
+	go func() {
+		dialog, err := transferee.NewDialog(sip.Uri{User: "transferor", Host: "127.0.0.1", Port: 15106}, NewDialogOptions{})
+		if err != nil {
+			t.Log("Transferee dialog failed", err)
+			return
+		}
+		defer dialog.Close()
+
+		err = dialog.Invite(ctx, InviteClientOptions{
+			OnRefer: func(referDialog *DialogClientSession) error {
+				if err := referDialog.Invite(ctx, InviteClientOptions{}); err != nil {
+					return err
+				}
+				if err := referDialog.Ack(ctx); err != nil {
+					return err
+				}
+				transferred <- referDialog.InviteRequest.GetHeader("Replaces")
+				<-done
+				return referDialog.Hangup(ctx)
+			},
+		})
+		if err != nil {
+			t.Log("Transferee call failed", err)
+			return
+		}
+		dialog.Ack(ctx)
+		<-dialog.Context().Done()
+	}()
+
+	d := <-transferorDialog
+	require.NoError(t, d.Answer())
+
+	consult, err := dg.Invite(ctx, sip.Uri{User: "target", Host: "127.0.0.1", Port: 15107}, InviteOptions{})
+	require.NoError(t, err)
+	defer consult.Close()
+	targetConsult := <-consultDialog
+
+	require.NoError(t, d.ReferReplaces(ctx, consult))
+
+	select {
+	case h := <-transferred:
+		require.NotNil(t, h)
+		expected, _ := dialogReplaces(consult)
+		assert.Equal(t, expected.String(), h.Value())
+	case <-time.After(3 * time.Second):
+		t.Fatal("transfer not done")
+	}
+
+	select {
+	case replaced := <-replacedDialog:
+		assert.Equal(t, targetConsult.Id(), replaced.Id())
+	case <-time.After(3 * time.Second):
+		t.Fatal("dialog not replaced")
+	}
+
+	// Target hangups consult call and transferor call is terminated after NOTIFY
+	for _, sess := range []DialogSession{consult, d} {
+		select {
+		case <-sess.Context().Done():
+		case <-time.After(3 * time.Second):
+			t.Fatal("dialog not terminated", sess.Id())
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// ServerAuthOptions configures digest authentication of incoming requests
+type ServerAuthOptions struct {
+	// Credentials looks up credentials of user in realm. Required
+	Credentials DigestCredentialProvider
+	// Realm returns realm for request, allowing different realm per domain. Default realm is sipgo
+	Realm func(req *sip.Request) string
+	// Proxy challenges with 407 Proxy-Authenticate instead of 401 WWW-Authenticate
+	Proxy bool
+	// Methods that are challenged. Default is INVITE, REFER, MESSAGE and SUBSCRIBE
+	Methods []sip.RequestMethod
+	// NonceExpire is lifetime of challenge nonce. Default is 5s
+	NonceExpire time.Duration
+	// DigestOptions configure digest server, ex. WithDigestAlgorithms or WithDigestQOP
+	DigestOptions []DigestServerOption
+}
+
+type serverAuth struct {
+	opts   ServerAuthOptions
+	digest *DigestAuthServer
+}
+
+// WithServerAuth challenges incoming requests before they reach handlers.
+// Only requests outside of dialog are challenged, as requests within dialog belong to already authenticated call.
+// Authenticated identity is available with DialogServerSession, MessageRequest and SubscriptionServer AuthIdentity
+func WithServerAuth(opts ServerAuthOptions) DiagoOption {
+	return func(dg *Diago) {
+		if opts.Methods == nil {
+			opts.Methods = []sip.RequestMethod{sip.INVITE, sip.REFER, sip.MESSAGE, sip.SUBSCRIBE}
+		}
+		digestOpts := append([]DigestServerOption{WithDigestCredentialProvider(opts.Credentials)}, opts.DigestOptions...)
+		if opts.Proxy {
+			digestOpts = append(digestOpts, WithDigestProxyAuth())
+		}
+		if opts.NonceExpire > 0 {
+			digestOpts = append(digestOpts, WithDigestNonceExpire(opts.NonceExpire))
+		}
+		dg.serverAuth = &serverAuth{
+			opts:   opts,
+			digest: NewDigestServer(digestOpts...),
+		}
+	}
+}
+
+// requires checks is request challenged
+func (a *serverAuth) requires(req *sip.Request) bool {
+	if _, ok := req.To().Params.Get("tag"); ok {
+		return false
+	}
+	return slices.Contains(a.opts.Methods, req.Method)
+}
+
+// keepsIdentity checks is request authorized by its handler, which keeps authenticated identity
+func (a *serverAuth) keepsIdentity(method sip.RequestMethod) bool {
+	return method == sip.INVITE || method == sip.MESSAGE || method == sip.SUBSCRIBE
+}
+
+func (a *serverAuth) realm(req *sip.Request) string {
+	if a.opts.Realm != nil {
+		if realm := a.opts.Realm(req); realm != "" {
+			return realm
+		}
+	}
+	return "sipgo"
+}
+
+// authorize authorizes request. If not authorized, challenge or error response is sent
+func (a *serverAuth) authorize(req *sip.Request, tx sip.ServerTransaction) (AuthIdentity, bool, error) {
+	realm := a.realm(req)
+	identity, res, err := a.digest.Authorize(context.TODO(), req, realm)
+	if err != nil || res.StatusCode != sip.StatusOK {
+		return AuthIdentity{}, false, errors.Join(err, tx.Respond(res))
+	}
+	return identity, true, nil
+}
+
+// AuthIdentity returns identity authenticated with WithServerAuth. It is empty if call was not authenticated
+func (d *DialogServerSession) AuthIdentity() AuthIdentity {
+	return d.authIdentity
+}
+
+// AuthIdentity returns identity authenticated with WithServerAuth. It is empty if MESSAGE was not authenticated
+func (m *MessageRequest) AuthIdentity() AuthIdentity {
+	return m.authIdentity
+}
+
+// AuthIdentity returns identity authenticated with WithServerAuth. It is empty if SUBSCRIBE was not authenticated
+func (s *SubscriptionServer) AuthIdentity() AuthIdentity {
+	return s.authIdentity
+}
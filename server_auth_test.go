@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationServerAuth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	identities := make(chan AuthIdentity, 1)
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("server"))
+		defer ua.Close()
+
+		dg := NewDiago(ua,
+			WithTransport(
+				Transport{
+					Transport: "udp",
+					BindHost:  "127.0.0.1",
+					BindPort:  15120,
+				},
+			),
+			WithServerAuth(ServerAuthOptions{
+				Credentials: DigestCredentialProviderFunc(func(ctx context.Context, username string, realm string) (DigestCredentials, error) {
+					if username != "alice" || realm != "example.com" {
+						return DigestCredentials{}, ErrDigestAuthUnknownUser
+					}
+					return DigestCredentials{Password: "secret"}, nil
+				}),
+				Realm: func(req *sip.Request) string {
+					return "example.com"
+				},
+				Proxy: true,
+			}),
+		)
+		dg.HandleMessage(func(m *MessageRequest) {
+			identities <- m.AuthIdentity()
+		})
+		dg.HandleSubscribe("test", func(s *SubscriptionServer) {
+			identities <- s.AuthIdentity()
+			if err := s.Accept(time.Minute); err != nil {
+				return
+			}
+			<-s.Context().Done()
+		})
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			identities <- d.AuthIdentity()
+			if err := d.Answer(); err != nil {
+				return
+			}
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	recipient := sip.Uri{User: "bob", Host: "127.0.0.1", Port: 15120}
+
+	t.Run("Invite", func(t *testing.T) {
+		d, err := dg.Invite(ctx, recipient, InviteOptions{Username: "alice", Password: "secret"})
+		require.NoError(t, err)
+		defer d.Close()
+		assert.NotNil(t, d.InviteRequest.GetHeader("Proxy-Authorization"))
+
+		select {
+		case identity := <-identities:
+			assert.Equal(t, AuthIdentity{Username: "alice", Realm: "example.com"}, identity)
+		case <-time.After(time.Second):
+			t.Fatal("handler not called")
+		}
+		require.NoError(t, d.Hangup(ctx))
+	})
+
+	t.Run("InviteUnauthorized", func(t *testing.T) {
+		_, err := dg.Invite(ctx, recipient, InviteOptions{})
+		res := dialogErrorResponse(err)
+		require.NotNil(t, res, err)
+		assert.Equal(t, sip.StatusProxyAuthRequired, res.StatusCode)
+
+		_, err = dg.Invite(ctx, recipient, InviteOptions{Username: "alice", Password: "wrong"})
+		res = dialogErrorResponse(err)
+		require.NotNil(t, res, err)
+		assert.Equal(t, sip.StatusProxyAuthRequired, res.StatusCode)
+	})
+
+	t.Run("Message", func(t *testing.T) {
+		err := dg.Message(ctx, recipient, "text/plain", []byte("hello"), MessageOptions{})
+		res := dialogErrorResponse(err)
+		require.NotNil(t, res, err)
+		assert.Equal(t, sip.StatusProxyAuthRequired, res.StatusCode)
+
+		err = dg.Message(ctx, recipient, "text/plain", []byte("hello"), MessageOptions{Username: "alice", Password: "secret"})
+		require.NoError(t, err)
+		assert.Equal(t, AuthIdentity{Username: "alice", Realm: "example.com"}, <-identities)
+	})
+
+	t.Run("Subscribe", func(t *testing.T) {
+		s, err := dg.Subscribe(ctx, recipient, SubscribeOptions{Event: "test", Username: "alice", Password: "secret"})
+		require.NoError(t, err)
+		defer s.Close()
+		assert.Equal(t, AuthIdentity{Username: "alice", Realm: "example.com"}, <-identities)
+		require.NoError(t, s.Unsubscribe(ctx))
+	})
+}
@@ -0,0 +1,240 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emiago/diago/media"
+	"github.com/emiago/sipgo/sip"
+)
+
+var (
+	// ErrSessionTimerExpired is context cause of dialog terminated due to missed session refresh
+	ErrSessionTimerExpired = errors.New("session timer expired")
+)
+
+const (
+	optionTagTimer = "timer"
+
+	refresherUAC = "uac"
+	refresherUAS = "uas"
+
+	// sessionTimerMinSE is lowest allowed Min-SE by RFC 4028
+	sessionTimerMinSE = 90 * time.Second
+)
+
+// SessionTimerOptions configures session timer (RFC 4028).
+// When negotiated, refresher side periodically refreshes dialog with re-INVITE.
+// If refresh is missed, dialog is terminated with BYE and its context is canceled with ErrSessionTimerExpired.
+type SessionTimerOptions struct {
+	// SessionExpires is requested session interval. Zero value disables session timer
+	SessionExpires time.Duration
+	// MinSE is minimum session interval we accept. Default and lowest value is 90s
+	MinSE time.Duration
+}
+
+func (o SessionTimerOptions) enabled() bool {
+	return o.SessionExpires > 0
+}
+
+func (o SessionTimerOptions) minSE() time.Duration {
+	return max(o.MinSE, sessionTimerMinSE)
+}
+
+func (o SessionTimerOptions) interval() time.Duration {
+	return max(o.SessionExpires, o.minSE())
+}
+
+// sessionExpires is Session-Expires header value
+type sessionExpires struct {
+	interval  time.Duration
+	refresher string
+}
+
+func (se sessionExpires) String() string {
+	val := strconv.Itoa(int(se.interval / time.Second))
+	if se.refresher != "" {
+		val += ";refresher=" + se.refresher
+	}
+	return val
+}
+
+// parseSessionExpires parses Session-Expires value ex. 1800;refresher=uac
+func parseSessionExpires(val string) (sessionExpires, error) {
+	se := sessionExpires{}
+	delta, params, _ := strings.Cut(val, ";")
+	interval, err := parseDeltaSeconds(delta)
+	if err != nil {
+		return se, err
+	}
+	se.interval = interval
+
+	for _, p := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+		if strings.EqualFold(name, "refresher") {
+			se.refresher = strings.ToLower(strings.TrimSpace(value))
+		}
+	}
+	return se, nil
+}
+
+// parseDeltaSeconds parses seconds value of Session-Expires or Min-SE header. Params are ignored
+func parseDeltaSeconds(val string) (time.Duration, error) {
+	delta, _, _ := strings.Cut(val, ";")
+	n, err := strconv.ParseUint(strings.TrimSpace(delta), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid delta seconds %q: %w", val, err)
+	}
+	return time.Duration(n) * time.Second, nil
+}
+
+func sessionExpiresFromMessage(msg sip.Message) (sessionExpires, bool) {
+	hdrs := msg.GetHeaders("Session-Expires")
+	if len(hdrs) == 0 {
+		return sessionExpires{}, false
+	}
+	se, err := parseSessionExpires(hdrs[0].Value())
+	if err != nil {
+		return sessionExpires{}, false
+	}
+	return se, true
+}
+
+// sessionTimerExpireWait is time after last refresh when session is considered expired
+// https://datatracker.ietf.org/doc/html/rfc4028#section-10
+func sessionTimerExpireWait(interval time.Duration) time.Duration {
+	return interval - min(32*time.Second, interval/3)
+}
+
+// sessionTimer keeps negotiated session interval and runs refreshes or expiry
+type sessionTimer struct {
+	mu       sync.Mutex
+	interval time.Duration
+	// refresher is true when we are responsible for refresh
+	refresher bool
+	updated   chan struct{}
+}
+
+func newSessionTimer(interval time.Duration, refresher bool) *sessionTimer {
+	return &sessionTimer{
+		interval:  interval,
+		refresher: refresher,
+		updated:   make(chan struct{}, 1),
+	}
+}
+
+func (st *sessionTimer) load() (time.Duration, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.interval, st.refresher
+}
+
+func (st *sessionTimer) set(interval time.Duration, refresher bool) {
+	st.mu.Lock()
+	st.interval = interval
+	st.refresher = refresher
+	st.mu.Unlock()
+}
+
+// update sets new session interval and restarts timer
+func (st *sessionTimer) update(interval time.Duration, refresher bool) {
+	st.set(interval, refresher)
+	select {
+	case st.updated <- struct{}{}:
+	default:
+	}
+}
+
+// handleRefresh restarts timer on refresh received from remote and returns headers for 2xx response
+func (st *sessionTimer) handleRefresh(req *sip.Request) []sip.Header {
+	interval, refresher := st.load()
+	se, ok := sessionExpiresFromMessage(req)
+	if !ok {
+		st.update(interval, refresher)
+		return nil
+	}
+
+	// Remote is UAC of refresh request
+	if se.refresher == "" {
+		se.refresher = refresherUAC
+	}
+	st.update(se.interval, se.refresher == refresherUAS)
+
+	headers := []sip.Header{sip.NewHeader("Session-Expires", se.String())}
+	if sipHeaderHasOption(req, "Supported", optionTagTimer) {
+		headers = append(headers, sip.NewHeader("Require", optionTagTimer))
+	}
+	return headers
+}
+
+// run refreshes session when we are refresher, otherwise it waits refresh from remote.
+// expire is called when session is not refreshed in time.
+func (st *sessionTimer) run(ctx context.Context, refresh func(ctx context.Context, se sessionExpires) (*sip.Response, error), expire func()) {
+	for {
+		interval, refresher := st.load()
+		expiresAt := time.Now().Add(interval)
+
+		wait := sessionTimerExpireWait(interval)
+		if refresher {
+			wait = interval / 2
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-st.updated:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		if !refresher {
+			expire()
+			return
+		}
+
+		refreshCtx, cancel := context.WithDeadline(ctx, expiresAt)
+		res, err := refresh(refreshCtx, sessionExpires{interval: interval, refresher: refresherUAC})
+		cancel()
+		if err == nil {
+			// We are UAC of refresh request
+			if se, ok := sessionExpiresFromMessage(res); ok {
+				st.set(se.interval, se.refresher != refresherUAS)
+			}
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		media.DefaultLogger().Info("Session refresh failed", "error", err)
+
+		if res := dialogErrorResponse(err); res != nil && (res.StatusCode == sip.StatusRequestTimeout || res.StatusCode == sip.StatusCallTransactionDoesNotExists) {
+			// https://datatracker.ietf.org/doc/html/rfc4028#section-10
+			// If 408 or 481 is received, UA terminates the session
+			expire()
+			return
+		}
+
+		// Session lasts until it expires unless remote refreshes it
+		select {
+		case <-ctx.Done():
+			return
+		case <-st.updated:
+			continue
+		case <-time.After(time.Until(expiresAt)):
+		}
+		expire()
+		return
+	}
+}
@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSessionExpires(t *testing.T) {
+	se, err := parseSessionExpires("1800;refresher=UAC")
+	require.NoError(t, err)
+	assert.Equal(t, 1800*time.Second, se.interval)
+	assert.Equal(t, refresherUAC, se.refresher)
+	assert.Equal(t, "1800;refresher=uac", se.String())
+
+	se, err = parseSessionExpires(" 90 ")
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, se.interval)
+	assert.Equal(t, "", se.refresher)
+
+	_, err = parseSessionExpires("abc;refresher=uas")
+	require.Error(t, err)
+
+	minSE, err := parseDeltaSeconds("300;custom=1")
+	require.NoError(t, err)
+	assert.Equal(t, 300*time.Second, minSE)
+}
+
+func TestSessionTimerRun(t *testing.T) {
+	t.Run("Expire", func(t *testing.T) {
+		st := newSessionTimer(300*time.Millisecond, false)
+		expired := make(chan struct{})
+		start := time.Now()
+		go st.run(context.Background(), nil, func() { close(expired) })
+
+		// Remote refresh restarts timer
+		time.Sleep(100 * time.Millisecond)
+		st.handleRefresh(sip.NewRequest(sip.INVITE, sip.Uri{Host: "localhost"}))
+
+		select {
+		case <-expired:
+			assert.Greater(t, time.Since(start), 250*time.Millisecond)
+		case <-time.After(time.Second):
+			t.Fatal("session did not expire")
+		}
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		st := newSessionTimer(100*time.Millisecond, true)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		refreshes := make(chan sessionExpires, 10)
+		refresh := func(ctx context.Context, se sessionExpires) (*sip.Response, error) {
+			refreshes <- se
+			res := sip.NewResponse(sip.StatusOK, "OK")
+			// Remote takes over refreshing
+			res.AppendHeader(sip.NewHeader("Session-Expires", "1;refresher=uas"))
+			return res, nil
+		}
+		go st.run(ctx, refresh, func() {})
+
+		select {
+		case se := <-refreshes:
+			assert.Equal(t, refresherUAC, se.refresher)
+			assert.Equal(t, 100*time.Millisecond, se.interval)
+		case <-time.After(time.Second):
+			t.Fatal("session not refreshed")
+		}
+
+		assert.Eventually(t, func() bool {
+			interval, refresher := st.load()
+			return interval == time.Second && !refresher
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestIntegrationSessionTimerNegotiation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("server"))
+		defer ua.Close()
+
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15103,
+			},
+		))
+
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {
+			err := d.AnswerOptions(AnswerOptions{
+				SessionTimer: SessionTimerOptions{
+					SessionExpires: 1800 * time.Second,
+					MinSE:          300 * time.Second,
+				},
+			})
+			if err != nil {
+				return
+			}
+			<-d.Context().Done()
+		})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := newDialer(ua)
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	dialog, err := dg.NewDialog(sip.Uri{User: "dialer", Host: "127.0.0.1", Port: 15103}, NewDialogOptions{})
+	require.NoError(t, err)
+	defer dialog.Close()
+
+	statuses := []int{}
+	err = dialog.Invite(ctx, InviteClientOptions{
+		OnResponse: func(res *sip.Response) error {
+			statuses = append(statuses, res.StatusCode)
+			return nil
+		},
+		SessionTimer: SessionTimerOptions{
+			SessionExpires: 120 * time.Second,
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, dialog.Ack(ctx))
+
+	// First INVITE is rejected with 422 and resent with Min-SE
+	assert.Contains(t, statuses, sip.StatusIntervalToBrief)
+	assert.Equal(t, "300", dialog.InviteRequest.GetHeader("Session-Expires").Value())
+
+	res := dialog.InviteResponse
+	assert.Equal(t, "300;refresher=uac", res.GetHeader("Session-Expires").Value())
+	assert.True(t, sipHeaderHasOption(res, "Require", optionTagTimer))
+
+	interval, refresher := dialog.sessionTimer.load()
+	assert.Equal(t, 300*time.Second, interval)
+	assert.True(t, refresher)
+
+	require.NoError(t, dialog.Hangup(ctx))
+}
@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+// ShutdownOptions for graceful shutdown
+type ShutdownOptions struct {
+	// Unregister sends unregister for every registered RegisterTransaction
+	Unregister bool
+	// RetryAfter is sent with 503 Service Unavailable to new calls. Default is 5s
+	RetryAfter time.Duration
+	// HangupTimeout limits hanguping of dialogs that are still active at deadline. Default is 5s
+	HangupTimeout time.Duration
+}
+
+// Shutdown is same as ShutdownOptions without unregistering
+func (dg *Diago) Shutdown(ctx context.Context) error {
+	return dg.ShutdownOptions(ctx, ShutdownOptions{})
+}
+
+// ShutdownOptions gracefully shuts down diago. New dialog creating requests (INVITE, SUBSCRIBE) are rejected
+// with 503 Service Unavailable and it waits for active dialogs to end. Dialogs still active when ctx is done are hanguped and ctx error is returned.
+//
+// Listeners keep running so that in dialog requests are still handled. Cancel Serve context after shutdown to stop them.
+func (dg *Diago) ShutdownOptions(ctx context.Context, opts ShutdownOptions) error {
+	if opts.RetryAfter <= 0 {
+		opts.RetryAfter = 5 * time.Second
+	}
+	if opts.HangupTimeout <= 0 {
+		opts.HangupTimeout = 5 * time.Second
+	}
+	dg.shutdownRetryAfter.Store(int64(opts.RetryAfter))
+	dg.shutdown.Store(true)
+
+	var errs []error
+	if opts.Unregister {
+		dg.registrations.Range(func(key, value any) bool {
+			t := key.(*RegisterTransaction)
+			// Register deferred unregister may have taken it already
+			if _, ok := dg.registrations.LoadAndDelete(t); !ok {
+				return true
+			}
+			// Refresh must not register again after unregister
+			t.stopRefresh()
+			if err := t.Unregister(ctx); err != nil {
+				errs = append(errs, err)
+			}
+			return true
+		})
+	}
+
+	for {
+		// Channel is taken before counting so that no removal is missed
+		removed := dg.dialogRemovedCh()
+		if dg.activeDialogs() == 0 {
+			return errors.Join(errs...)
+		}
+
+		select {
+		case <-removed:
+		case <-ctx.Done():
+			dg.log.Info("Shutdown deadline reached. Hanguping active dialogs", "dialogs", dg.activeDialogs())
+			errs = append(errs, ctx.Err(), dg.hangupActiveDialogs(opts.HangupTimeout))
+			return errors.Join(errs...)
+		}
+	}
+}
+
+// shutdownRejects checks is request creating new dialog, which is rejected during shutdown
+func (dg *Diago) shutdownRejects(req *sip.Request) bool {
+	if !dg.shutdown.Load() {
+		return false
+	}
+	if _, ok := req.To().Params.Get("tag"); ok {
+		return false
+	}
+	return req.Method == sip.INVITE || req.Method == sip.SUBSCRIBE
+}
+
+func (dg *Diago) shutdownResponse(req *sip.Request) *sip.Response {
+	res := sip.NewResponseFromRequest(req, sip.StatusServiceUnavailable, "Service Unavailable", nil)
+	retryAfter := time.Duration(dg.shutdownRetryAfter.Load())
+	res.AppendHeader(sip.NewHeader("Retry-After", strconv.Itoa(int(retryAfter.Seconds()))))
+	return res
+}
+
+// dialogRemovedCh returns channel closed on next dialog removal from cache
+func (dg *Diago) dialogRemovedCh() <-chan struct{} {
+	dg.dialogRemovedMu.Lock()
+	defer dg.dialogRemovedMu.Unlock()
+	if dg.dialogRemoved == nil {
+		dg.dialogRemoved = make(chan struct{})
+	}
+	return dg.dialogRemoved
+}
+
+func (dg *Diago) notifyDialogRemoved() {
+	dg.dialogRemovedMu.Lock()
+	defer dg.dialogRemovedMu.Unlock()
+	if dg.dialogRemoved != nil {
+		close(dg.dialogRemoved)
+		dg.dialogRemoved = nil
+	}
+}
+
+// dialogCacheNotify notifies on dialog removal, so that shutdown does not need to poll active dialogs
+type dialogCacheNotify[T DialogSession] struct {
+	DialogCache[T]
+	onDelete func()
+}
+
+func (c *dialogCacheNotify[T]) DialogDelete(ctx context.Context, id string) error {
+	err := c.DialogCache.DialogDelete(ctx, id)
+	c.onDelete()
+	return err
+}
+
+func (dg *Diago) activeDialogs() int {
+	n := 0
+	dg.cache.server.DialogRange(context.Background(), func(id string, d *DialogServerSession) bool {
+		n++
+		return true
+	})
+	dg.cache.client.DialogRange(context.Background(), func(id string, d *DialogClientSession) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func (dg *Diago) hangupActiveDialogs(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	hangup := func(d DialogSession) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := d.Hangup(ctx); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	dg.cache.server.DialogRange(ctx, func(id string, d *DialogServerSession) bool {
+		hangup(d)
+		return true
+	})
+	dg.cache.client.DialogRange(ctx, func(id string, d *DialogClientSession) bool {
+		hangup(d)
+		return true
+	})
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	registrar := NewRegistrar(RegistrarOptions{})
+	dg := NewDiago(ua, WithTransport(
+		Transport{
+			Transport: "udp",
+			BindHost:  "127.0.0.1",
+			BindPort:  15122,
+		},
+	), WithRegistrar(registrar))
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	phoneUA, _ := sipgo.NewUA(sipgo.WithUserAgent("1001"))
+	defer phoneUA.Close()
+	phone := NewDiago(phoneUA, WithTransport(
+		Transport{
+			Transport: "udp",
+			BindHost:  "127.0.0.1",
+			BindPort:  15123,
+		},
+	))
+	err = phone.ServeBackground(ctx, func(d *DialogServerSession) {
+		if err := d.Answer(); err != nil {
+			return
+		}
+		<-d.Context().Done()
+	})
+	require.NoError(t, err)
+
+	aor := sip.Uri{User: "1001", Host: "127.0.0.1", Port: 15122}
+	tx, err := phone.RegisterTransaction(ctx, aor, RegisterOptions{Expiry: 5 * time.Minute})
+	require.NoError(t, err)
+	require.NoError(t, tx.Register(ctx))
+	qualifyErr := make(chan error, 1)
+	go func() {
+		qualifyErr <- tx.QualifyLoop(ctx)
+	}()
+
+	recipient := sip.Uri{User: "1001", Host: "127.0.0.1"}
+	d1, err := dg.Invite(ctx, recipient, InviteOptions{})
+	require.NoError(t, err)
+	defer d1.Close()
+	d2, err := dg.Invite(ctx, recipient, InviteOptions{})
+	require.NoError(t, err)
+	defer d2.Close()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, time.Second)
+	defer shutdownCancel()
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- phone.ShutdownOptions(shutdownCtx, ShutdownOptions{Unregister: true})
+	}()
+
+	require.Eventually(t, func() bool {
+		bindings, err := registrar.Lookup(ctx, aor)
+		return err == nil && len(bindings) == 0
+	}, time.Second, 50*time.Millisecond)
+
+	// Refresh is stopped, so it does not register again
+	select {
+	case err := <-qualifyErr:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("register refresh not stopped")
+	}
+
+	// New calls are rejected
+	_, err = dg.Invite(ctx, sip.Uri{User: "1001", Host: "127.0.0.1", Port: 15123}, InviteOptions{})
+	res := dialogErrorResponse(err)
+	require.NotNil(t, res, err)
+	assert.Equal(t, sip.StatusServiceUnavailable, res.StatusCode)
+	assert.Equal(t, "5", res.GetHeader("Retry-After").Value())
+
+	_, err = dg.Subscribe(ctx, sip.Uri{User: "1001", Host: "127.0.0.1", Port: 15123}, SubscribeOptions{Event: "presence"})
+	res = dialogErrorResponse(err)
+	require.NotNil(t, res, err)
+	assert.Equal(t, sip.StatusServiceUnavailable, res.StatusCode)
+
+	require.NoError(t, d1.Hangup(ctx))
+
+	// Dialog still active at deadline receives BYE
+	select {
+	case <-d2.Context().Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("dialog not terminated on shutdown deadline")
+	}
+	require.ErrorIs(t, <-shutdownErr, context.DeadlineExceeded)
+
+	// Without active dialogs shutdown returns once dialogs are removed
+	shutdownCtx, shutdownCancel = context.WithTimeout(ctx, 2*time.Second)
+	defer shutdownCancel()
+	require.NoError(t, phone.Shutdown(shutdownCtx))
+}
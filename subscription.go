@@ -0,0 +1,899 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/google/uuid"
+)
+
+// Subscription-State values (RFC 6665)
+const (
+	SubscriptionStateActive     = "active"
+	SubscriptionStatePending    = "pending"
+	SubscriptionStateTerminated = "terminated"
+)
+
+const (
+	statusBadEvent = 489
+
+	// subscriptionDefaultExpires is used when SUBSCRIBE has no Expires header
+	subscriptionDefaultExpires = 3600 * time.Second
+	// subscriptionMaxExpires is longest subscription granted when notifier does not limit it
+	subscriptionMaxExpires = 24 * time.Hour
+)
+
+var (
+	// ErrSubscriptionTerminated is context cause of subscription that is terminated
+	ErrSubscriptionTerminated = errors.New("subscription terminated")
+)
+
+// SubscribeHandlerFunc handles new subscription for event package.
+// Handler must Accept or Reject subscription and subscription lasts until handler returns.
+// Use subscription Context to know when it is terminated by subscriber or expiry.
+type SubscribeHandlerFunc func(s *SubscriptionServer)
+
+// HandleSubscribe registers handler for SUBSCRIBE requests of event package, ex. presence, dialog.
+// SUBSCRIBE for package without handler is rejected with 489 Bad Event.
+// https://datatracker.ietf.org/doc/html/rfc6665
+func (dg *Diago) HandleSubscribe(event string, f SubscribeHandlerFunc) {
+	dg.subscriptions.mu.Lock()
+	defer dg.subscriptions.mu.Unlock()
+	if dg.subscriptions.handlers == nil {
+		dg.subscriptions.handlers = make(map[string]SubscribeHandlerFunc)
+	}
+	dg.subscriptions.handlers[strings.ToLower(event)] = f
+}
+
+// subscriptionCache keeps active subscriptions. Subscriptions are matched by
+// Call-ID, local tag and Event, as NOTIFY can arrive before dialog is created.
+type subscriptionCache struct {
+	mu       sync.Mutex
+	handlers map[string]SubscribeHandlerFunc
+
+	server sync.Map
+	client sync.Map
+}
+
+func (c *subscriptionCache) handler(event string) (SubscribeHandlerFunc, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.handlers[event]
+	return f, ok
+}
+
+func (c *subscriptionCache) events() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	events := make([]string, 0, len(c.handlers))
+	for e := range c.handlers {
+		events = append(events, e)
+	}
+	slices.Sort(events)
+	return events
+}
+
+func (c *subscriptionCache) loadServer(key string) (*SubscriptionServer, bool) {
+	s, ok := c.server.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return s.(*SubscriptionServer), true
+}
+
+func (c *subscriptionCache) loadClient(key string) (*SubscriptionClient, bool) {
+	s, ok := c.client.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return s.(*SubscriptionClient), true
+}
+
+// subscriptionKey identifies subscription. Local tag is To tag of incoming request within subscription.
+func subscriptionKey(callID string, localTag string, event string) string {
+	return callID + ";" + localTag + ";" + subscriptionEventKey(event)
+}
+
+func subscriptionKeyFromRequest(req *sip.Request) string {
+	localTag, _ := req.To().Params.Get("tag")
+	event := ""
+	if h := req.GetHeader("Event"); h != nil {
+		event = h.Value()
+	}
+	return subscriptionKey(req.CallID().Value(), localTag, event)
+}
+
+// subscriptionEventKey returns event package with id parameter, ex. dialog;id=1
+func subscriptionEventKey(val string) string {
+	pkg, params, _ := strings.Cut(val, ";")
+	key := eventPackage(pkg)
+	for _, p := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+		if strings.EqualFold(name, "id") {
+			key += ";id=" + strings.TrimSpace(value)
+		}
+	}
+	return key
+}
+
+// eventPackage returns event package name from Event header value
+func eventPackage(val string) string {
+	pkg, _, _ := strings.Cut(val, ";")
+	return strings.ToLower(strings.TrimSpace(pkg))
+}
+
+// subscriptionState is Subscription-State header value ex. active;expires=600
+type subscriptionState struct {
+	state   string
+	reason  string
+	expires time.Duration
+}
+
+func (s subscriptionState) String() string {
+	val := s.state
+	switch s.state {
+	case SubscriptionStateTerminated:
+		if s.reason != "" {
+			val += ";reason=" + s.reason
+		}
+	default:
+		val += ";expires=" + strconv.Itoa(int(s.expires/time.Second))
+	}
+	return val
+}
+
+func parseSubscriptionState(val string) (subscriptionState, error) {
+	state, params, _ := strings.Cut(val, ";")
+	s := subscriptionState{state: strings.ToLower(strings.TrimSpace(state))}
+	if s.state == "" {
+		return s, fmt.Errorf("invalid Subscription-State header %q", val)
+	}
+
+	for _, p := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+		switch strings.ToLower(name) {
+		case "reason":
+			s.reason = strings.TrimSpace(value)
+		case "expires":
+			expires, err := parseDeltaSeconds(value)
+			if err != nil {
+				return s, err
+			}
+			s.expires = expires
+		}
+	}
+	return s, nil
+}
+
+// subscriptionDialog is dialog created by SUBSCRIBE.
+// It keeps only what is needed to build requests within dialog.
+type subscriptionDialog struct {
+	mu     sync.Mutex
+	client *sipgo.Client
+
+	callID sip.CallIDHeader
+	// from is local and to is remote side of dialog
+	from    sip.FromHeader
+	to      sip.ToHeader
+	contact sip.ContactHeader
+	event   string
+
+	remoteTarget sip.Uri
+	routes       []string
+	transport    string
+	cseq         uint32
+	// remoteCSeq is CSeq of last request received within dialog
+	remoteCSeq uint32
+}
+
+func (d *subscriptionDialog) remoteTag() string {
+	tag, _ := d.to.Params.Get("tag")
+	return tag
+}
+
+// readCSeqUnsafe checks that request within dialog is not out of order and updates remote CSeq
+// https://datatracker.ietf.org/doc/html/rfc3261#section-12.2.2
+func (d *subscriptionDialog) readCSeqUnsafe(req *sip.Request) bool {
+	cseq := req.CSeq().SeqNo
+	if d.remoteCSeq != 0 && cseq <= d.remoteCSeq {
+		return false
+	}
+	d.remoteCSeq = cseq
+	return true
+}
+
+// setRoutes sets route set from Record-Route headers. UAC of dialog creating request uses reverse order
+func (d *subscriptionDialog) setRoutes(msg sip.Message, reverse bool) {
+	d.routes = d.routes[:0]
+	for _, rr := range msg.GetHeaders("Record-Route") {
+		d.routes = append(d.routes, rr.Value())
+	}
+	if reverse {
+		slices.Reverse(d.routes)
+	}
+}
+
+// newRequest builds request within dialog
+// https://datatracker.ietf.org/doc/html/rfc3261#section-12.2.1
+func (d *subscriptionDialog) newRequest(method sip.RequestMethod) *sip.Request {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.cseq++
+	req := sip.NewRequest(method, *d.remoteTarget.Clone())
+	maxFwd := sip.MaxForwardsHeader(70)
+	req.AppendHeader(sip.HeaderClone(&d.from))
+	req.AppendHeader(sip.HeaderClone(&d.to))
+	req.AppendHeader(sip.HeaderClone(&d.callID))
+	req.AppendHeader(&sip.CSeqHeader{SeqNo: d.cseq, MethodName: method})
+	req.AppendHeader(&maxFwd)
+	req.AppendHeader(sip.HeaderClone(&d.contact))
+	req.AppendHeader(sip.NewHeader("Event", d.event))
+
+	for i, r := range d.routes {
+		req.AppendHeader(sip.NewHeader("Route", r))
+		if i > 0 {
+			continue
+		}
+		route := sip.Uri{}
+		params := sip.NewParams()
+		if _, err := sip.ParseAddressValue(r, &route, &params); err == nil {
+			req.SetDestination(route.HostPort())
+		}
+	}
+	req.SetTransport(d.transport)
+	return req
+}
+
+func (d *subscriptionDialog) do(ctx context.Context, req *sip.Request) (*sip.Response, error) {
+	return d.client.Do(ctx, req)
+}
+
+// SubscriptionServer is subscription on notifier side created by SUBSCRIBE
+type SubscriptionServer struct {
+	dialog subscriptionDialog
+
+	// SubscribeRequest is initial SUBSCRIBE request
+	SubscribeRequest *sip.Request
+
+	tx        sip.ServerTransaction
+	key       string
+	cache     *subscriptionCache
+	requested time.Duration
+	// maxExpires is longest duration granted on refresh. It is set on Accept
+	maxExpires time.Duration
+	// authIdentity is set when SUBSCRIBE is authenticated with WithServerAuth
+	authIdentity AuthIdentity
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	mu          sync.Mutex
+	responded   bool
+	terminated  bool
+	expiresAt   time.Time
+	expiry      *time.Timer
+	contentType string
+	body        []byte
+}
+
+// Context is canceled when subscription is terminated
+func (s *SubscriptionServer) Context() context.Context {
+	return s.ctx
+}
+
+// Event returns event package of subscription
+func (s *SubscriptionServer) Event() string {
+	return eventPackage(s.dialog.event)
+}
+
+// Expires returns duration requested by subscriber
+func (s *SubscriptionServer) Expires() time.Duration {
+	return s.requested
+}
+
+// Accept accepts subscription with 200 OK. Expires is longest duration granted now and on refreshes.
+// If zero, maximum of 24h is used. Duration longer than requested is never granted.
+// As per RFC 6665 Notify should be called immediately after accepting.
+func (s *SubscriptionServer) Accept(expires time.Duration) error {
+	maxExpires := expires
+	if maxExpires <= 0 {
+		maxExpires = subscriptionMaxExpires
+	}
+	expires = min(maxExpires, s.requested)
+
+	s.mu.Lock()
+	if s.responded {
+		s.mu.Unlock()
+		return fmt.Errorf("subscription already responded")
+	}
+	s.responded = true
+	s.maxExpires = maxExpires
+	s.setExpiryUnsafe(expires)
+	s.mu.Unlock()
+
+	// Store before responding as refresh can come right after
+	s.cache.server.Store(s.key, s)
+	if err := s.respond(s.SubscribeRequest, s.tx, expires); err != nil {
+		s.end()
+		return err
+	}
+	return nil
+}
+
+// Reject rejects subscription with non 2xx response
+func (s *SubscriptionServer) Reject(statusCode int, reason string, headers ...sip.Header) error {
+	s.mu.Lock()
+	if s.responded {
+		s.mu.Unlock()
+		return fmt.Errorf("subscription already responded")
+	}
+	s.responded = true
+	s.terminated = true
+	s.mu.Unlock()
+
+	defer s.cancel(ErrSubscriptionTerminated)
+	res := sip.NewResponseFromRequest(s.SubscribeRequest, statusCode, reason, nil)
+	for _, h := range headers {
+		res.AppendHeader(h)
+	}
+	return s.tx.Respond(res)
+}
+
+// Notify sends NOTIFY with current state of subscribed resource.
+// Body is stored and resent on every subscription refresh.
+// If subscription has expired, NOTIFY terminates subscription.
+func (s *SubscriptionServer) Notify(ctx context.Context, contentType string, body []byte) error {
+	s.mu.Lock()
+	s.contentType, s.body = contentType, body
+	remaining := time.Until(s.expiresAt)
+	s.mu.Unlock()
+
+	if remaining <= 0 {
+		return s.Terminate(ctx, "timeout")
+	}
+	return s.notify(ctx, subscriptionState{state: SubscriptionStateActive, expires: remaining})
+}
+
+// Terminate terminates subscription with NOTIFY containing last notified state.
+// Reason is Subscription-State reason, ex. noresource, rejected, timeout, or empty.
+func (s *SubscriptionServer) Terminate(ctx context.Context, reason string) error {
+	s.mu.Lock()
+	if s.terminated {
+		s.mu.Unlock()
+		return nil
+	}
+	s.terminated = true
+	s.mu.Unlock()
+
+	defer s.end()
+	return s.notify(ctx, subscriptionState{state: SubscriptionStateTerminated, reason: reason})
+}
+
+func (s *SubscriptionServer) notify(ctx context.Context, state subscriptionState) error {
+	req := s.dialog.newRequest(sip.NOTIFY)
+	req.AppendHeader(sip.NewHeader("Subscription-State", state.String()))
+
+	s.mu.Lock()
+	if s.body != nil {
+		req.AppendHeader(sip.NewHeader("Content-Type", s.contentType))
+		req.SetBody(s.body)
+	}
+	s.mu.Unlock()
+
+	res, err := s.dialog.do(ctx, req)
+	if err != nil {
+		return fmt.Errorf("sending NOTIFY failed: %w", err)
+	}
+	if !res.IsSuccess() {
+		if res.StatusCode == sip.StatusCallTransactionDoesNotExists {
+			// https://datatracker.ietf.org/doc/html/rfc6665#section-4.2.2
+			// Subscriber no longer has subscription
+			s.end()
+		}
+		return sipgo.ErrDialogResponse{Res: res}
+	}
+	return nil
+}
+
+func (s *SubscriptionServer) respond(req *sip.Request, tx sip.ServerTransaction, expires time.Duration) error {
+	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+	// Response must carry our dialog tag and not generated one
+	res.To().Params.Add("tag", s.dialog.from.Params.GetOr("tag", ""))
+	res.AppendHeader(sip.HeaderClone(&s.dialog.contact))
+	expiresHDR := sip.ExpiresHeader(expires.Seconds())
+	res.AppendHeader(&expiresHDR)
+	return tx.Respond(res)
+}
+
+// setExpiryUnsafe restarts expiry timer. Expired subscription is terminated with reason timeout
+func (s *SubscriptionServer) setExpiryUnsafe(expires time.Duration) {
+	s.expiresAt = time.Now().Add(expires)
+	if s.expiry != nil {
+		s.expiry.Stop()
+	}
+	if expires <= 0 {
+		// Fetch. It is terminated with first NOTIFY
+		return
+	}
+
+	s.expiry = time.AfterFunc(expires, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sip.Timer_F)
+		defer cancel()
+		s.Terminate(ctx, "timeout")
+	})
+}
+
+// handleRefresh handles SUBSCRIBE within subscription, which refreshes or unsubscribes it
+func (s *SubscriptionServer) handleRefresh(req *sip.Request, tx sip.ServerTransaction, expires time.Duration) error {
+	s.mu.Lock()
+	if s.terminated {
+		s.mu.Unlock()
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil))
+	}
+	s.dialog.mu.Lock()
+	ordered := s.dialog.readCSeqUnsafe(req)
+	s.dialog.mu.Unlock()
+	if !ordered {
+		s.mu.Unlock()
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Server Internal Error - CSeq out of order", nil))
+	}
+	expires = min(expires, s.maxExpires)
+	s.setExpiryUnsafe(expires)
+	s.mu.Unlock()
+
+	if err := s.respond(req, tx, expires); err != nil {
+		return err
+	}
+
+	// https://datatracker.ietf.org/doc/html/rfc6665#section-4.2.1.2
+	// Notifier sends NOTIFY after refresh with current state
+	ctx, cancel := context.WithTimeout(context.Background(), sip.Timer_F)
+	defer cancel()
+	if expires <= 0 {
+		return s.Terminate(ctx, "")
+	}
+	return s.notify(ctx, subscriptionState{state: SubscriptionStateActive, expires: expires})
+}
+
+func (s *SubscriptionServer) end() {
+	s.mu.Lock()
+	s.terminated = true
+	if s.expiry != nil {
+		s.expiry.Stop()
+	}
+	s.mu.Unlock()
+
+	s.cache.server.CompareAndDelete(s.key, s)
+	s.cancel(ErrSubscriptionTerminated)
+}
+
+func (dg *Diago) handleSubscribe(req *sip.Request, tx sip.ServerTransaction) error {
+	var identity AuthIdentity
+	if dg.serverAuth != nil && dg.serverAuth.requires(req) {
+		var authorized bool
+		var err error
+		identity, authorized, err = dg.serverAuth.authorize(req, tx)
+		if !authorized {
+			return err
+		}
+	}
+
+	event := req.GetHeader("Event")
+	if event == nil {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - missing Event", nil))
+	}
+
+	expires := subscriptionDefaultExpires
+	if h := req.GetHeader("Expires"); h != nil {
+		e, err := parseDeltaSeconds(h.Value())
+		if err != nil {
+			return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - invalid Expires", nil))
+		}
+		expires = e
+	}
+
+	if toTag, _ := req.To().Params.Get("tag"); toTag != "" {
+		s, ok := dg.subscriptions.loadServer(subscriptionKeyFromRequest(req))
+		if !ok {
+			return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil))
+		}
+		return s.handleRefresh(req, tx, expires)
+	}
+
+	handler, ok := dg.subscriptions.handler(eventPackage(event.Value()))
+	if !ok {
+		// https://datatracker.ietf.org/doc/html/rfc6665#section-4.2.1.1
+		res := sip.NewResponseFromRequest(req, statusBadEvent, "Bad Event", nil)
+		res.AppendHeader(sip.NewHeader("Allow-Events", strings.Join(dg.subscriptions.events(), ", ")))
+		return tx.Respond(res)
+	}
+
+	contact := req.Contact()
+	if contact == nil {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - missing Contact", nil))
+	}
+
+	tran, _ := dg.getTransport(req.Transport())
+	s := &SubscriptionServer{
+		dialog: subscriptionDialog{
+			client:       dg.getClient(tran),
+			callID:       *req.CallID(),
+			from:         req.To().AsFrom(),
+			to:           req.From().AsTo(),
+			event:        event.Value(),
+			remoteTarget: *contact.Address.Clone(),
+			transport:    req.Transport(),
+			remoteCSeq:   req.CSeq().SeqNo,
+		},
+		SubscribeRequest: req,
+		tx:               tx,
+		cache:            &dg.subscriptions,
+		requested:        expires,
+		authIdentity:     identity,
+	}
+	s.dialog.from.Params = s.dialog.from.Params.Clone()
+	s.dialog.from.Params.Add("tag", sip.GenerateTagN(16))
+	s.dialog.to.Params = s.dialog.to.Params.Clone()
+	s.dialog.setRoutes(req, false)
+	dg.contactHDRFromTransport(tran, &s.dialog.contact)
+	s.key = subscriptionKey(req.CallID().Value(), s.dialog.from.Params.GetOr("tag", ""), event.Value())
+	s.ctx, s.cancel = context.WithCancelCause(context.Background())
+
+	handler(s)
+
+	s.mu.Lock()
+	responded := s.responded
+	s.mu.Unlock()
+	if !responded {
+		return s.Reject(sip.StatusForbidden, "Forbidden")
+	}
+
+	// Subscription lasts until handler returns
+	ctx, cancel := context.WithTimeout(context.Background(), sip.Timer_F)
+	defer cancel()
+	return s.Terminate(ctx, "noresource")
+}
+
+// SubscribeOptions are options for Diago Subscribe
+type SubscribeOptions struct {
+	// Event is event package, ex. presence, dialog. Required
+	Event string
+	// Accept is Accept header value, ex. application/dialog-info+xml
+	Accept string
+	// Expires is requested subscription duration. Default is 1h
+	Expires time.Duration
+	// Transport or protocol that should be used
+	Transport string
+	// For digest authentication
+	Username string
+	Password string
+	// Custom headers to pass on SUBSCRIBE
+	Headers []sip.Header
+
+	// OnNotify is called for every NOTIFY within subscription, including last one with terminated state.
+	OnNotify func(n SubscriptionNotify)
+}
+
+// SubscriptionNotify is NOTIFY received within subscription
+type SubscriptionNotify struct {
+	Request *sip.Request
+	// State is Subscription-State value: active, pending or terminated
+	State string
+	// Reason is present for terminated state, ex. timeout, noresource
+	Reason  string
+	Expires time.Duration
+}
+
+// ContentType returns content type of NOTIFY body
+func (n SubscriptionNotify) ContentType() string {
+	if h := n.Request.ContentType(); h != nil {
+		return h.Value()
+	}
+	return ""
+}
+
+// Body returns NOTIFY body
+func (n SubscriptionNotify) Body() []byte {
+	return n.Request.Body()
+}
+
+// SubscriptionClient is subscription on subscriber side. It is refreshed until
+// Unsubscribe or Close is called, or notifier terminates it.
+type SubscriptionClient struct {
+	dialog subscriptionDialog
+
+	// SubscribeRequest is initial SUBSCRIBE request
+	SubscribeRequest *sip.Request
+
+	opts  SubscribeOptions
+	key   string
+	cache *subscriptionCache
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	mu      sync.Mutex
+	expires time.Duration
+	// confirmed is true once remote tag is known from 2xx or NOTIFY
+	confirmed bool
+	refreshed chan struct{}
+}
+
+// Subscribe sends SUBSCRIBE and waits for 2xx response. NOTIFY requests are delivered to OnNotify callback.
+// Subscription is refreshed before it expires.
+// https://datatracker.ietf.org/doc/html/rfc6665
+func (dg *Diago) Subscribe(ctx context.Context, recipient sip.Uri, opts SubscribeOptions) (*SubscriptionClient, error) {
+	if opts.Event == "" {
+		return nil, fmt.Errorf("subscribe: event package is required")
+	}
+	if opts.Expires <= 0 {
+		opts.Expires = subscriptionDefaultExpires
+	}
+
+	tran, err := dg.recipientTransport(&recipient, opts.Transport, "")
+	if err != nil {
+		return nil, err
+	}
+
+	callID, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SubscriptionClient{
+		dialog: subscriptionDialog{
+			client:       dg.getClient(tran),
+			callID:       sip.CallIDHeader(callID.String()),
+			event:        opts.Event,
+			remoteTarget: recipient,
+			transport:    sip.NetworkToUpper(tran.Transport),
+		},
+		opts:      opts,
+		cache:     &dg.subscriptions,
+		expires:   opts.Expires,
+		refreshed: make(chan struct{}, 1),
+	}
+	s.ctx, s.cancel = context.WithCancelCause(context.Background())
+
+	scheme := recipient.Scheme
+	if scheme == "" {
+		scheme = "sip"
+	}
+	s.dialog.from = sip.FromHeader{
+		Address: sip.Uri{Scheme: scheme, User: dg.ua.Name(), Host: tran.ExternalHost},
+		Params:  sip.NewParams(),
+	}
+	s.dialog.from.Params.Add("tag", sip.GenerateTagN(16))
+	s.dialog.to = sip.ToHeader{
+		Address: sip.Uri{Scheme: scheme, User: recipient.User, Host: recipient.Host},
+		Params:  sip.NewParams(),
+	}
+	dg.contactHDRFromTransport(tran, &s.dialog.contact)
+
+	// NOTIFY can arrive before 2xx, so subscription is stored before sending
+	s.key = subscriptionKey(s.dialog.callID.Value(), s.dialog.from.Params.GetOr("tag", ""), opts.Event)
+	dg.subscriptions.client.Store(s.key, s)
+
+	req := s.dialog.newRequest(sip.SUBSCRIBE)
+	s.SubscribeRequest = req
+	if err := s.subscribe(ctx, req, opts.Expires, true); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	go s.refreshLoop()
+	return s, nil
+}
+
+// Context is canceled when subscription is terminated
+func (s *SubscriptionClient) Context() context.Context {
+	return s.ctx
+}
+
+// Refresh refreshes subscription with SUBSCRIBE within dialog
+func (s *SubscriptionClient) Refresh(ctx context.Context) error {
+	s.mu.Lock()
+	expires := s.expires
+	s.mu.Unlock()
+
+	if err := s.subscribe(ctx, s.dialog.newRequest(sip.SUBSCRIBE), expires, false); err != nil {
+		return err
+	}
+	select {
+	case s.refreshed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Unsubscribe sends SUBSCRIBE with Expires 0 and waits for NOTIFY terminating subscription
+func (s *SubscriptionClient) Unsubscribe(ctx context.Context) error {
+	if err := s.subscribe(ctx, s.dialog.newRequest(sip.SUBSCRIBE), 0, false); err != nil {
+		s.Close()
+		return err
+	}
+
+	timer := time.NewTimer(sip.Timer_F)
+	defer timer.Stop()
+	select {
+	case <-s.ctx.Done():
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return s.Close()
+}
+
+// Close terminates subscription locally without sending any request
+func (s *SubscriptionClient) Close() error {
+	s.end(ErrSubscriptionTerminated)
+	return nil
+}
+
+func (s *SubscriptionClient) subscribe(ctx context.Context, req *sip.Request, expires time.Duration, initial bool) error {
+	expiresHDR := sip.ExpiresHeader(expires.Seconds())
+	req.AppendHeader(&expiresHDR)
+	if s.opts.Accept != "" {
+		req.AppendHeader(sip.NewHeader("Accept", s.opts.Accept))
+	}
+	for _, h := range s.opts.Headers {
+		req.AppendHeader(h)
+	}
+
+	res, err := s.dialog.do(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if (res.StatusCode == sip.StatusUnauthorized || res.StatusCode == sip.StatusProxyAuthRequired) && s.opts.Password != "" {
+		res, err = digestAuthDo(ctx, s.dialog.client, req, res, sipgo.DigestAuth{
+			Username: s.opts.Username,
+			Password: s.opts.Password,
+		})
+		if err != nil {
+			return err
+		}
+		// Digest auth increases CSeq
+		s.dialog.mu.Lock()
+		s.dialog.cseq = req.CSeq().SeqNo
+		s.dialog.mu.Unlock()
+	}
+
+	if !res.IsSuccess() {
+		if res.StatusCode == sip.StatusCallTransactionDoesNotExists {
+			s.end(ErrSubscriptionTerminated)
+		}
+		return sipgo.ErrDialogResponse{Res: res}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h := res.GetHeader("Expires"); h != nil {
+		if e, err := parseDeltaSeconds(h.Value()); err == nil {
+			s.expires = e
+		}
+	}
+
+	if !initial || s.confirmed {
+		return nil
+	}
+
+	// Dialog is created with 2xx response
+	s.confirmed = true
+	s.dialog.mu.Lock()
+	defer s.dialog.mu.Unlock()
+	if tag, _ := res.To().Params.Get("tag"); tag != "" {
+		s.dialog.to.Params.Add("tag", tag)
+	}
+	if cont := res.Contact(); cont != nil {
+		s.dialog.remoteTarget = *cont.Address.Clone()
+	}
+	s.dialog.setRoutes(res, true)
+	return nil
+}
+
+// refreshLoop refreshes subscription before it expires
+func (s *SubscriptionClient) refreshLoop() {
+	for {
+		s.mu.Lock()
+		expires := s.expires
+		s.mu.Unlock()
+		if expires <= 0 {
+			return
+		}
+
+		timer := time.NewTimer(expires / 2)
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-s.refreshed:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		ctx, cancel := context.WithTimeout(s.ctx, expires/2)
+		err := s.Refresh(ctx)
+		cancel()
+		if err != nil {
+			if s.ctx.Err() == nil {
+				s.end(fmt.Errorf("subscription refresh failed: %w", err))
+			}
+			return
+		}
+	}
+}
+
+func (s *SubscriptionClient) handleNotify(req *sip.Request, tx sip.ServerTransaction) error {
+	h := req.GetHeader("Subscription-State")
+	if h == nil {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request - missing Subscription-State", nil))
+	}
+	state, err := parseSubscriptionState(h.Value())
+	if err != nil {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil))
+	}
+
+	remoteTag, _ := req.From().Params.Get("tag")
+	s.mu.Lock()
+	s.dialog.mu.Lock()
+	if !s.confirmed {
+		// https://datatracker.ietf.org/doc/html/rfc6665#section-4.1.2.4
+		// NOTIFY can create dialog before 2xx is received
+		s.confirmed = true
+		s.dialog.to.Params.Add("tag", remoteTag)
+		s.dialog.setRoutes(req, false)
+	}
+	matched := s.dialog.remoteTag() == remoteTag
+	ordered := matched && s.dialog.readCSeqUnsafe(req)
+	if ordered {
+		if cont := req.Contact(); cont != nil {
+			s.dialog.remoteTarget = *cont.Address.Clone()
+		}
+	}
+	s.dialog.mu.Unlock()
+	s.mu.Unlock()
+
+	if !matched {
+		// Forked subscriptions are not supported
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil))
+	}
+	if !ordered {
+		return tx.Respond(sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Server Internal Error - CSeq out of order", nil))
+	}
+
+	if err := tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)); err != nil {
+		return err
+	}
+
+	if s.opts.OnNotify != nil {
+		s.opts.OnNotify(SubscriptionNotify{
+			Request: req,
+			State:   state.state,
+			Reason:  state.reason,
+			Expires: state.expires,
+		})
+	}
+
+	if state.state == SubscriptionStateTerminated {
+		s.end(ErrSubscriptionTerminated)
+	}
+	return nil
+}
+
+func (s *SubscriptionClient) end(cause error) {
+	s.cache.client.CompareAndDelete(s.key, s)
+	s.cancel(cause)
+}
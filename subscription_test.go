@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: MPL-2.0
+// SPDX-FileCopyrightText: Copyright (c) 2024, Emir Aganovic
+
+package diago
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSubscriptionState(t *testing.T) {
+	s, err := parseSubscriptionState("active;expires=600")
+	require.NoError(t, err)
+	assert.Equal(t, SubscriptionStateActive, s.state)
+	assert.Equal(t, 600*time.Second, s.expires)
+	assert.Equal(t, "active;expires=600", s.String())
+
+	s, err = parseSubscriptionState("terminated; reason=timeout")
+	require.NoError(t, err)
+	assert.Equal(t, SubscriptionStateTerminated, s.state)
+	assert.Equal(t, "timeout", s.reason)
+	assert.Equal(t, "terminated;reason=timeout", s.String())
+
+	_, err = parseSubscriptionState("")
+	assert.Error(t, err)
+}
+
+func TestSubscriptionEventKey(t *testing.T) {
+	assert.Equal(t, "dialog", subscriptionEventKey("Dialog"))
+	assert.Equal(t, "dialog;id=1", subscriptionEventKey("dialog; id=1;foo=bar"))
+}
+
+func TestIntegrationSubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverTerminated := make(chan error, 1)
+	{
+		ua, _ := sipgo.NewUA(sipgo.WithUserAgent("notifier"))
+		defer ua.Close()
+
+		dg := NewDiago(ua, WithTransport(
+			Transport{
+				Transport: "udp",
+				BindHost:  "127.0.0.1",
+				BindPort:  15111,
+			},
+		))
+		dg.HandleSubscribe("test", func(s *SubscriptionServer) {
+			if s.SubscribeRequest.To().Address.User == "reject" {
+				s.Reject(sip.StatusForbidden, "Forbidden")
+				return
+			}
+
+			if err := s.Accept(time.Minute); err != nil {
+				return
+			}
+			if err := s.Notify(s.Context(), "text/plain", []byte("state1")); err != nil {
+				return
+			}
+			<-s.Context().Done()
+			serverTerminated <- context.Cause(s.Context())
+		})
+		err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+		require.NoError(t, err)
+	}
+
+	ua, _ := sipgo.NewUA()
+	defer ua.Close()
+	dg := NewDiago(ua, WithTransport(
+		Transport{
+			Transport: "udp",
+			BindHost:  "127.0.0.1",
+			BindPort:  15112,
+		},
+	))
+	err := dg.ServeBackground(ctx, func(d *DialogServerSession) {})
+	require.NoError(t, err)
+
+	recipient := sip.Uri{User: "alice", Host: "127.0.0.1", Port: 15111}
+
+	t.Run("BadEvent", func(t *testing.T) {
+		_, err := dg.Subscribe(ctx, recipient, SubscribeOptions{Event: "unknown"})
+		res := dialogErrorResponse(err)
+		require.NotNil(t, res, err)
+		assert.Equal(t, statusBadEvent, res.StatusCode)
+		assert.Equal(t, "test", res.GetHeader("Allow-Events").Value())
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		_, err := dg.Subscribe(ctx, sip.Uri{User: "reject", Host: "127.0.0.1", Port: 15111}, SubscribeOptions{Event: "test"})
+		res := dialogErrorResponse(err)
+		require.NotNil(t, res, err)
+		assert.Equal(t, sip.StatusForbidden, res.StatusCode)
+	})
+
+	t.Run("Subscription", func(t *testing.T) {
+		notifications := make(chan SubscriptionNotify, 10)
+		sub, err := dg.Subscribe(ctx, recipient, SubscribeOptions{
+			Event:   "test",
+			Expires: 2 * time.Minute,
+			OnNotify: func(n SubscriptionNotify) {
+				notifications <- n
+			},
+		})
+		require.NoError(t, err)
+		defer sub.Close()
+
+		waitNotify := func() SubscriptionNotify {
+			select {
+			case n := <-notifications:
+				return n
+			case <-time.After(3 * time.Second):
+				t.Fatal("no NOTIFY received")
+			}
+			return SubscriptionNotify{}
+		}
+
+		n := waitNotify()
+		assert.Equal(t, SubscriptionStateActive, n.State)
+		assert.Equal(t, "text/plain", n.ContentType())
+		assert.Equal(t, "state1", string(n.Body()))
+		// Granted expiry is lower than requested
+		assert.LessOrEqual(t, n.Expires, time.Minute)
+
+		// Refresh resends current state
+		require.NoError(t, sub.Refresh(ctx))
+		n = waitNotify()
+		assert.Equal(t, SubscriptionStateActive, n.State)
+		assert.Equal(t, "state1", string(n.Body()))
+		// Refresh is limited by notifier, not by initially requested expiry
+		assert.LessOrEqual(t, n.Expires, time.Minute)
+
+		require.NoError(t, sub.Unsubscribe(ctx))
+		n = waitNotify()
+		assert.Equal(t, SubscriptionStateTerminated, n.State)
+		assert.True(t, errors.Is(context.Cause(sub.Context()), ErrSubscriptionTerminated))
+
+		select {
+		case err := <-serverTerminated:
+			assert.ErrorIs(t, err, ErrSubscriptionTerminated)
+		case <-time.After(3 * time.Second):
+			t.Fatal("server subscription not terminated")
+		}
+	})
+}
+
+func TestSubscriptionDialogReadCSeq(t *testing.T) {
+	request := func(cseq uint32) *sip.Request {
+		req := sip.NewRequest(sip.NOTIFY, sip.Uri{User: "test", Host: "localhost"})
+		req.AppendHeader(&sip.CSeqHeader{SeqNo: cseq, MethodName: sip.NOTIFY})
+		return req
+	}
+
+	d := subscriptionDialog{}
+	// Remote CSeq is empty until first request
+	assert.True(t, d.readCSeqUnsafe(request(5)))
+	assert.False(t, d.readCSeqUnsafe(request(5)))
+	assert.False(t, d.readCSeqUnsafe(request(4)))
+	assert.True(t, d.readCSeqUnsafe(request(7)))
+}
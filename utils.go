@@ -4,6 +4,7 @@
 package diago
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -13,6 +14,7 @@ import (
 	"sync"
 
 	"github.com/emiago/diago/media"
+	"github.com/emiago/sipgo"
 	"github.com/emiago/sipgo/sip"
 	"github.com/pion/rtp"
 )
@@ -71,3 +73,29 @@ func uri2Header(uri sip.Uri) string {
 	buf.WriteString(">")
 	return buf.String()
 }
+
+// dialogErrorResponse returns response of sipgo.ErrDialogResponse error if present
+func dialogErrorResponse(err error) *sip.Response {
+	var resErr *sipgo.ErrDialogResponse
+	if errors.As(err, &resErr) {
+		return resErr.Res
+	}
+	var resErrVal sipgo.ErrDialogResponse
+	if errors.As(err, &resErrVal) {
+		return resErrVal.Res
+	}
+	return nil
+}
+
+// mergeHeaders returns new slice of headers common to all calls followed by headers of single call
+func mergeHeaders(common []sip.Header, own []sip.Header) []sip.Header {
+	headers := make([]sip.Header, 0, len(common)+len(own))
+	headers = append(headers, common...)
+	return append(headers, own...)
+}
+
+// requestNewTransaction prepares request to be resent within same call. It must have new transaction and higher CSeq
+func requestNewTransaction(req *sip.Request) {
+	req.RemoveHeader("Via")
+	req.CSeq().SeqNo++
+}